@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+)
+
+const commandUsage = `Usage: nokode [-f config] [command]
+
+Without a command the HTTP server is started.
+
+Commands:
+  migrate up       Apply pending migrations from the migrations directory
+  migrate status   List migrations and when they were applied
+  snapshot list    List snapshots, newest first
+  snapshot create [tenant]
+                   Take a snapshot of the application tables now
+  snapshot restore <name>
+                   Restore the application tables from a snapshot
+  export <format> [-o file] [-author name] [-since date] [-until date] [-tenant name]
+                   Export poems as markdown, jsonl, csv or epub, by default to
+                   poems-<date>.<ext>; dates are YYYY-MM-DD or RFC3339`
+
+// runCommand runs a CLI subcommand and returns the process exit code.
+func runCommand(c *config.Config, args []string) int {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(args[1:])
+	case "snapshot":
+		return runSnapshot(args[1:])
+	case "export":
+		return runExport(args[1:])
+	default:
+		fmt.Println(commandUsage)
+		return 2
+	}
+}
+
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(commandUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := tools.MigrateUp(cliRequest("migrate up"))
+		for _, version := range applied {
+			fmt.Printf("applied %s\n", version)
+		}
+		if err != nil {
+			fmt.Printf("migrate up failed: %v\n", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return 0
+
+	case "status":
+		migrations, err := tools.MigrationStatus()
+		if err != nil {
+			fmt.Printf("migrate status failed: %v\n", err)
+			return 1
+		}
+		for _, m := range migrations {
+			status := "pending"
+			if m.AppliedAt != nil {
+				status = "applied " + m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%-60s %s\n", m.Version, status)
+		}
+		return 0
+
+	default:
+		fmt.Println(commandUsage)
+		return 2
+	}
+}
+
+func runSnapshot(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(commandUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "list":
+		snapshots, err := tools.ListSnapshots()
+		if err != nil {
+			fmt.Printf("snapshot list failed: %v\n", err)
+			return 1
+		}
+		for _, s := range snapshots {
+			fmt.Printf("%-60s %10d bytes\n", s.Name, s.Size)
+		}
+		return 0
+
+	case "create":
+		tenant := ""
+		if len(args) > 1 {
+			tenant = tools.TenantID(args[1])
+		}
+		name, err := tools.TakeSnapshot(tenant, "manual")
+		if err != nil {
+			fmt.Printf("snapshot create failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("created %s\n", name)
+		return 0
+
+	case "restore":
+		if len(args) < 2 {
+			fmt.Println(commandUsage)
+			return 2
+		}
+		if err := tools.RestoreSnapshot(cliRequest("snapshot restore"), args[1]); err != nil {
+			fmt.Printf("snapshot restore failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("restored %s\n", args[1])
+		return 0
+
+	default:
+		fmt.Println(commandUsage)
+		return 2
+	}
+}
+
+func runExport(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(commandUsage)
+		return 2
+	}
+	format, ok := tools.ExportFormats[args[0]]
+	if !ok {
+		fmt.Printf("unknown export format %q, use one of %s\n", args[0], strings.Join(tools.ExportFormatNames(), ", "))
+		return 2
+	}
+
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", fmt.Sprintf("poems-%s.%s", time.Now().Format("20060102"), format.Extension), "file to write")
+	author := flags.String("author", "", "only export poems by this author")
+	since := flags.String("since", "", "only export poems created at or after this date")
+	until := flags.String("until", "", "only export poems created at or before this date")
+	tenant := flags.String("tenant", "", "export the poems of this tenant")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	filter := tools.ExportFilter{Author: *author}
+	for _, bound := range []struct {
+		value  string
+		target *time.Time
+		upper  bool
+	}{{*since, &filter.Since, false}, {*until, &filter.Until, true}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := tools.ParseExportTime(bound.value, bound.upper)
+		if err != nil {
+			fmt.Printf("export failed: %v\n", err)
+			return 2
+		}
+		*bound.target = t
+	}
+
+	// Statements for the shared database are issued as nokode itself
+	var info *tools.RequestInfo
+	if *tenant != "" {
+		info = cliRequest("export")
+		info.Tenant = tools.TenantID(*tenant)
+	}
+	poems, err := tools.ExportPoems(info, filter)
+	if err == nil && len(poems) == 0 {
+		err = tools.ErrNoPoems
+	}
+	if err != nil {
+		fmt.Printf("export failed: %v\n", err)
+		return 1
+	}
+
+	// Written to a file rather than stdout, which carries the log
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Printf("export failed: %v\n", err)
+		return 1
+	}
+	err = format.Write(f, filter.Title(), poems)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("export failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("exported %d poems to %s\n", len(poems), *output)
+	return 0
+}
+
+// cliRequest identifies a CLI subcommand in the audit log.
+func cliRequest(route string) *tools.RequestInfo {
+	return &tools.RequestInfo{RequestID: "cli", Method: "CLI", Route: route}
+}
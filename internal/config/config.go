@@ -2,7 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/zeromicro/go-zero/core/conf"
 	"github.com/zeromicro/go-zero/rest"
@@ -12,11 +15,73 @@ type Config struct {
 	RestConf rest.RestConf `yaml:",inline"`
 	Provider string        `json:",optional"`
 	Database struct {
+		Driver   string `json:",optional"` // mysql、sqlite 或 postgres
+		Path     string `json:",optional"` // SQLite 数据库文件路径
 		Host     string `json:",optional"`
 		Port     int    `json:",optional"`
 		User     string `json:",optional"`
 		Password string `json:",optional"`
 		Database string `json:",optional"`
+		// 只读副本 DSN（驱动原生格式），为空时所有查询走主库
+		ReplicaDSN string `json:",optional"`
+		// 连接池，为 0 或空时使用各数据库的默认值
+		MaxOpenConns    int    `json:",optional"`
+		MaxIdleConns    int    `json:",optional"`
+		ConnMaxLifetime string `json:",optional"` // 如 30m
+		ConnMaxIdleTime string `json:",optional"` // 如 5m
+		// 连接参数
+		TLS      string            `json:",optional"` // mysql: true/false/skip-verify/preferred；postgres: sslmode
+		Timezone string            `json:",optional"` // 时间值使用的时区，默认 Local
+		Socket   string            `json:",optional"` // Unix socket 路径，设置后忽略 Host/Port
+		Params   map[string]string `json:",optional"` // 额外 DSN 参数
+		// EXPLAIN 估算行数超过该值的 SELECT 将被拒绝，0 表示不检查
+		MaxEstimatedRows int64 `json:",optional"`
+	}
+	Audit struct {
+		Sink string `json:",optional"` // table、file 或 off
+		File string `json:",optional"` // file 模式下的 JSON Lines 文件
+	}
+	Migrations struct {
+		Dir            string `json:",optional"` // 迁移文件目录
+		DisableCapture bool   `json:",optional"` // 不记录模型执行的 DDL
+	}
+	Snapshots struct {
+		Dir      string `json:",optional"` // 快照目录
+		Interval string `json:",optional"` // 定时快照间隔，如 6h，为空时不定时快照
+		Keep     int    `json:",optional"` // 保留的快照数量
+	}
+	Tenancy struct {
+		Mode    string            `json:",optional"` // off 或 schema（每个租户独立的 schema）
+		Source  string            `json:",optional"` // host（按域名）或 auth（按身份）
+		Tenants map[string]string `json:",optional"` // 域名或身份到租户的映射，未列出的请求不会分配租户
+		Header  string            `json:",optional"` // auth 模式下携带身份的请求头，仅采用来自 Proxies.Trusted 的请求；为空时使用 Basic 认证用户名
+		// auth 模式下 Basic 认证的用户名与密码，密码校验通过后才采用该身份
+		Credentials map[string]string `json:",optional"`
+		Default     string            `json:",optional"` // 无法识别租户时使用的租户，为空时拒绝请求
+		MaxOpen     int               `json:",optional"` // 同时打开的租户连接池上限，超出时关闭最久未使用的，默认 32
+		IdleTimeout string            `json:",optional"` // 租户连接池空闲多久后关闭，如 30m
+	}
+	Proxies struct {
+		Trusted []string `json:",optional"` // 受信任的反向代理 IP 或 CIDR，只采用来自它们的转发头
+	}
+	Prosody struct {
+		MinScore int `json:",optional"` // 格律总分（0-100）低于该值的绝句、律诗会被要求重写，0 表示不限制
+	}
+	Duplicates struct {
+		Threshold int `json:",optional"` // 与已有诗作或名篇的相似度（0-100）达到该值时要求重写，大于 100 表示不限制
+	}
+	Jobs struct {
+		Concurrency int `json:",optional"` // 批量生成任务同时调用模型生成诗歌的数量，由所有任务共享
+	}
+	Async struct {
+		Enabled   bool   `json:",optional"` // 为 true 时所有由模型生成的页面默认异步返回，否则仅在请求带 Prefer: respond-async 或 async=1 时异步
+		Retention string `json:",optional"` // 异步请求结果的保留时长，如 1h，过期后删除
+	}
+	Cards struct {
+		Font string `json:",optional"` // 渲染 PNG 诗卡所用的含中文字形的 TrueType/OpenType 字体文件，为空时在常见系统字体路径中查找
+	}
+	Admin struct {
+		Token string `json:",optional"` // 管理接口令牌，为空时禁用管理接口
 	}
 	Qwen struct {
 		Model  string `json:",optional"`
@@ -55,7 +120,7 @@ func Load(configFile string) (*Config, error) {
 	
 	// Override with environment variables
 	if c.Provider == "" {
-		c.Provider = getEnv("LLM_PROVIDER", "spark")
+		c.Provider = getEnv("LLM_PROVIDER", "qwen")
 	}
 	if c.RestConf.Port == 0 {
 		portStr := getEnv("PORT", "3001")
@@ -74,14 +139,24 @@ func Load(configFile string) (*Config, error) {
 	}
 
 	// Database configuration
+	if c.Database.Driver == "" {
+		c.Database.Driver = getEnv("DB_DRIVER", "mysql")
+	}
+	if c.Database.Path == "" {
+		c.Database.Path = getEnv("DB_PATH", "nokode.db")
+	}
 	if c.Database.Host == "" {
 		c.Database.Host = getEnv("DB_HOST", "localhost")
 	}
 	if c.Database.Port == 0 {
-		portStr := getEnv("DB_PORT", "3306")
+		defaultPort := "3306"
+		if c.Database.Driver == "postgres" {
+			defaultPort = "5432"
+		}
+		portStr := getEnv("DB_PORT", defaultPort)
 		fmt.Sscanf(portStr, "%d", &c.Database.Port)
 		if c.Database.Port == 0 {
-			c.Database.Port = 3306
+			c.Database.Port, _ = strconv.Atoi(defaultPort)
 		}
 	}
 	if c.Database.User == "" {
@@ -93,6 +168,107 @@ func Load(configFile string) (*Config, error) {
 	if c.Database.Database == "" {
 		c.Database.Database = getEnv("DB_NAME", "nokode")
 	}
+	if c.Database.ReplicaDSN == "" {
+		c.Database.ReplicaDSN = getEnv("DB_REPLICA_DSN", "")
+	}
+	if c.Database.MaxOpenConns == 0 {
+		c.Database.MaxOpenConns, _ = strconv.Atoi(getEnv("DB_MAX_OPEN_CONNS", "0"))
+	}
+	if c.Database.MaxIdleConns == 0 {
+		c.Database.MaxIdleConns, _ = strconv.Atoi(getEnv("DB_MAX_IDLE_CONNS", "0"))
+	}
+	if c.Database.ConnMaxLifetime == "" {
+		c.Database.ConnMaxLifetime = getEnv("DB_CONN_MAX_LIFETIME", "")
+	}
+	if c.Database.ConnMaxIdleTime == "" {
+		c.Database.ConnMaxIdleTime = getEnv("DB_CONN_MAX_IDLE_TIME", "")
+	}
+	if c.Database.TLS == "" {
+		c.Database.TLS = getEnv("DB_TLS", "")
+	}
+	if c.Database.Timezone == "" {
+		c.Database.Timezone = getEnv("DB_TIMEZONE", "Local")
+	}
+	if c.Database.Socket == "" {
+		c.Database.Socket = getEnv("DB_SOCKET", "")
+	}
+	if c.Database.MaxEstimatedRows == 0 {
+		c.Database.MaxEstimatedRows, _ = strconv.ParseInt(getEnv("DB_MAX_ESTIMATED_ROWS", "0"), 10, 64)
+	}
+	if c.Database.Params == nil {
+		c.Database.Params = envMap("DB_PARAMS")
+	}
+
+	if c.Audit.Sink == "" {
+		c.Audit.Sink = getEnv("AUDIT_SINK", "table")
+	}
+	if c.Audit.File == "" {
+		c.Audit.File = getEnv("AUDIT_FILE", "logs/audit.jsonl")
+	}
+	if c.Migrations.Dir == "" {
+		c.Migrations.Dir = getEnv("MIGRATIONS_DIR", "migrations")
+	}
+	if c.Snapshots.Dir == "" {
+		c.Snapshots.Dir = getEnv("SNAPSHOT_DIR", "snapshots")
+	}
+	if c.Snapshots.Interval == "" {
+		c.Snapshots.Interval = getEnv("SNAPSHOT_INTERVAL", "")
+	}
+	if c.Snapshots.Keep == 0 {
+		c.Snapshots.Keep, _ = strconv.Atoi(getEnv("SNAPSHOT_KEEP", "50"))
+	}
+	if c.Tenancy.Mode == "" {
+		c.Tenancy.Mode = getEnv("TENANCY_MODE", "off")
+	}
+	if c.Tenancy.Source == "" {
+		c.Tenancy.Source = getEnv("TENANCY_SOURCE", "host")
+	}
+	if c.Tenancy.Header == "" {
+		c.Tenancy.Header = getEnv("TENANCY_HEADER", "")
+	}
+	if c.Tenancy.Tenants == nil {
+		c.Tenancy.Tenants = envMap("TENANCY_TENANTS")
+	}
+	if c.Tenancy.Credentials == nil {
+		c.Tenancy.Credentials = envMap("TENANCY_CREDENTIALS")
+	}
+	if c.Tenancy.Default == "" {
+		c.Tenancy.Default = getEnv("TENANCY_DEFAULT", "")
+	}
+	if c.Tenancy.MaxOpen == 0 {
+		c.Tenancy.MaxOpen, _ = strconv.Atoi(getEnv("TENANCY_MAX_OPEN", "32"))
+	}
+	if c.Tenancy.IdleTimeout == "" {
+		c.Tenancy.IdleTimeout = getEnv("TENANCY_IDLE_TIMEOUT", "30m")
+	}
+	if c.Proxies.Trusted == nil {
+		for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				c.Proxies.Trusted = append(c.Proxies.Trusted, proxy)
+			}
+		}
+	}
+	if c.Prosody.MinScore == 0 {
+		c.Prosody.MinScore, _ = strconv.Atoi(getEnv("PROSODY_MIN_SCORE", "0"))
+	}
+	if c.Duplicates.Threshold == 0 {
+		c.Duplicates.Threshold, _ = strconv.Atoi(getEnv("DUPLICATE_THRESHOLD", "80"))
+	}
+	if c.Jobs.Concurrency == 0 {
+		c.Jobs.Concurrency, _ = strconv.Atoi(getEnv("JOB_CONCURRENCY", "2"))
+	}
+	if !c.Async.Enabled {
+		c.Async.Enabled = getEnv("ASYNC_REQUESTS", "") == "true"
+	}
+	if c.Async.Retention == "" {
+		c.Async.Retention = getEnv("ASYNC_RETENTION", "1h")
+	}
+	if c.Cards.Font == "" {
+		c.Cards.Font = getEnv("CARD_FONT", "")
+	}
+	if c.Admin.Token == "" {
+		c.Admin.Token = getEnv("ADMIN_TOKEN", "")
+	}
 
 	c.Qwen.Model = getEnv("QWEN_MODEL", "qwen-turbo")
 	if c.Qwen.APIKey == "" {
@@ -109,7 +285,7 @@ func Load(configFile string) (*Config, error) {
 		c.OpenAI.APIKey = getEnv("OPENAI_API_KEY", "")
 	}
 
-	c.Baidu.Model = getEnv("BAIDU_MODEL", "ernie-speed-128k")
+	c.Baidu.Model = getEnv("BAIDU_MODEL", c.Baidu.Model)
 	if c.Baidu.APIKey == "" {
 		c.Baidu.APIKey = getEnv("BAIDU_API_KEY", "")
 	}
@@ -123,7 +299,7 @@ func Load(configFile string) (*Config, error) {
 		c.Baidu.AppID = getEnv("BAIDU_APP_ID", "")
 	}
 
-	c.Spark.Model = getEnv("SPARK_MODEL", "spark-x")
+	c.Spark.Model = getEnv("SPARK_MODEL", c.Spark.Model)
 	if c.Spark.AppID == "" {
 		c.Spark.AppID = getEnv("SPARK_APP_ID", "")
 	}
@@ -137,6 +313,20 @@ func Load(configFile string) (*Config, error) {
 	return &c, nil
 }
 
+// envMap reads a map from an environment variable in query string form,
+// e.g. "a=1&b=2".
+func envMap(key string) map[string]string {
+	values, err := url.ParseQuery(getEnv(key, ""))
+	if err != nil {
+		return nil
+	}
+	m := make(map[string]string)
+	for k := range values {
+		m[k] = values.Get(k)
+	}
+	return m
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
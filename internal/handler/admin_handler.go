@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+// requireAdmin checks the admin token sent as a Bearer token or in the
+// X-Admin-Token header. Admin endpoints are disabled when no token is set.
+func requireAdmin(cfg *config.Config, w http.ResponseWriter, r *http.Request) bool {
+	if cfg.Admin.Token == "" {
+		writeJSONError(w, http.StatusForbidden, "admin endpoints are disabled: set ADMIN_TOKEN to enable them")
+		return false
+	}
+
+	token := r.Header.Get("X-Admin-Token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Admin.Token)) != 1 {
+		utils.Log.Warn("admin", "Rejected admin request with invalid token", map[string]interface{}{
+			"path": r.URL.Path,
+			"ip":   getClientIP(cfg, r),
+		})
+		writeJSONError(w, http.StatusUnauthorized, "invalid admin token")
+		return false
+	}
+	return true
+}
+
+// HandleAuditLog serves GET /admin/audit, listing the SQL statements issued
+// through the database tool. Supported query parameters: request_id, route,
+// ip, contains, since, until (RFC3339) and limit.
+func HandleAuditLog(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+
+		q := r.URL.Query()
+		filter := tools.AuditFilter{
+			RequestID: q.Get("request_id"),
+			Route:     q.Get("route"),
+			ClientIP:  q.Get("ip"),
+			Contains:  q.Get("contains"),
+		}
+		if limit := q.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "limit must be a number")
+				return
+			}
+			filter.Limit = n
+		}
+		for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if value := q.Get(name); value != "" {
+				t, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					writeJSONError(w, http.StatusBadRequest, name+" must be an RFC3339 timestamp")
+					return
+				}
+				*target = t
+			}
+		}
+
+		entries, err := tools.QueryAuditLog(filter)
+		if err != nil {
+			utils.Log.Error("admin", "Failed to query audit log", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"entries": entries,
+			"count":   len(entries),
+		})
+	}
+}
+
+// HandleDatabaseStats serves GET /admin/db/stats with the connection pool
+// statistics of the primary database and the read replica.
+func HandleDatabaseStats(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		writeJSON(w, http.StatusOK, tools.DatabaseStats())
+	}
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+	"github.com/nokode/nokode/internal/utils"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+)
+
+// asyncRefreshSeconds is how often the pending page reloads itself.
+const asyncRefreshSeconds = 3
+
+// wantsAsync reports whether a page should be rendered in the background.
+// Clients opt in with "Prefer: respond-async" or ?async=1; Async.Enabled
+// makes it the default, which ?async=0 turns off again.
+func wantsAsync(cfg *config.Config, r *http.Request) bool {
+	switch r.URL.Query().Get("async") {
+	case "1", "true":
+		return true
+	case "0", "false":
+		return false
+	}
+	for _, prefer := range r.Header.Values("Prefer") {
+		for _, token := range strings.Split(prefer, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "respond-async") {
+				return true
+			}
+		}
+	}
+	return cfg.Async.Enabled
+}
+
+// startAsyncRequest answers 202 at once and renders the page in a request
+// job, whose response is kept for Async.Retention. If the job cannot be
+// stored the page is rendered synchronously instead.
+func startAsyncRequest(cfg *config.Config, w http.ResponseWriter, r *http.Request, info *tools.RequestInfo, prompt string, generate bool) {
+	params, _ := json.Marshal(map[string]string{"method": r.Method, "path": r.URL.Path})
+	job := &tools.Job{Kind: tools.JobKindRequest, Tenant: info.Tenant, Total: 1, Params: params}
+	if err := tools.CreateJob(job); err != nil {
+		utils.Log.Error("jobs", "Failed to queue async request, answering synchronously", err)
+		writeWebResponse(w, respondLLM(cfg, info, prompt, generate, time.Now()))
+		return
+	}
+	utils.Log.Info("jobs", "Rendering request asynchronously", map[string]interface{}{
+		"requestId": info.RequestID,
+		"jobId":     job.ID,
+	})
+	// runRequestJob updates job as it goes, so answer with a copy
+	pending := *job
+	go runRequestJob(cfg, job, info, prompt, generate)
+	writePending(w, r, &pending)
+}
+
+// runRequestJob renders the page and stores it as the job's result.
+func runRequestJob(cfg *config.Config, job *tools.Job, info *tools.RequestInfo, prompt string, generate bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			job.Status, job.Failed, job.Error = tools.JobFailed, 1, fmt.Sprintf("panic: %v", p)
+			if err := tools.UpdateJob(job); err != nil {
+				utils.Log.Error("jobs", "Failed to save job", err)
+			}
+		}
+	}()
+
+	job.Status = tools.JobRunning
+	if err := tools.UpdateJob(job); err != nil {
+		utils.Log.Error("jobs", "Failed to save job progress", err)
+	}
+
+	webResponse := respondLLM(cfg, info, prompt, generate, time.Now())
+	job.Result, _ = json.Marshal(webResponse)
+	if webResponse.StatusCode >= http.StatusInternalServerError {
+		job.Status, job.Failed, job.Error = tools.JobFailed, 1, "the page could not be generated"
+	} else {
+		job.Status, job.Completed = tools.JobSucceeded, 1
+	}
+	if err := tools.UpdateJob(job); err != nil {
+		utils.Log.Error("jobs", "Failed to save async response", err)
+	}
+}
+
+// HandleJobResult serves GET /jobs/:id/result: the page a request job
+// rendered, sent as the original request would have received it, or the
+// pending answer while it is still being rendered.
+func HandleJobResult(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		job := lookupJob(w, info, pathvar.Vars(r)["id"])
+		if job == nil {
+			return
+		}
+		if job.Kind != tools.JobKindRequest {
+			writeJSONError(w, http.StatusNotFound, "job has no stored response")
+			return
+		}
+		if !job.Finished() {
+			writePending(w, r, job)
+			return
+		}
+
+		var webResponse tools.WebResponse
+		if len(job.Result) == 0 || json.Unmarshal(job.Result, &webResponse) != nil {
+			writeJSONError(w, http.StatusInternalServerError, job.Error)
+			return
+		}
+		writeWebResponse(w, webResponse)
+	}
+}
+
+// writePending answers 202 for a job still rendering: browsers get a page
+// that reloads the result until it is ready, other clients the job.
+func writePending(w http.ResponseWriter, r *http.Request, job *tools.Job) {
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.Header().Set("Retry-After", fmt.Sprint(asyncRefreshSeconds))
+	w.Header().Set("Cache-Control", "no-store")
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		writeJSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta http-equiv="refresh" content="%[1]d;url=/jobs/%[2]s/result">
+    <title>正在生成 - 中国古典诗歌生成器</title>
+    <style>
+        body {
+            font-family: 'Microsoft YaHei', 'PingFang SC', 'Hiragino Sans GB', 'WenQuanYi Micro Hei', sans-serif;
+            background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%);
+            min-height: 100vh;
+            margin: 0;
+            display: flex;
+            justify-content: center;
+            align-items: center;
+            color: #2c3e50;
+        }
+
+        .container {
+            background: rgba(255, 255, 255, 0.95);
+            border-radius: 20px;
+            box-shadow: 0 20px 40px rgba(0, 0, 0, 0.1);
+            padding: 40px;
+            text-align: center;
+        }
+
+        .hint {
+            color: #7f8c8d;
+            font-size: 14px;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>正在生成，请稍候……</h1>
+        <p class="hint">页面每 %[1]d 秒自动刷新，完成后显示结果。</p>
+        <p class="hint"><a href="/jobs/%[2]s/result">立即刷新</a></p>
+    </div>
+</body>
+</html>
+`, asyncRefreshSeconds, job.ID)
+}
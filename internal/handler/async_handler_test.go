@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nokode/nokode/internal/tools"
+)
+
+func TestWantsAsync(t *testing.T) {
+	enabled := *testConfig
+	enabled.Async.Enabled = true
+
+	tests := []struct {
+		name    string
+		enabled bool
+		target  string
+		prefer  []string
+		want    bool
+	}{
+		{"default off", false, "/", nil, false},
+		{"default on", true, "/", nil, true},
+		{"query opts in", false, "/?async=1", nil, true},
+		{"query true", false, "/?async=true", nil, true},
+		{"query opts out", true, "/?async=0", nil, false},
+		{"query false", true, "/?async=false", nil, false},
+		{"query beats prefer", false, "/?async=0", []string{"respond-async"}, false},
+		{"prefer", false, "/", []string{"respond-async"}, true},
+		{"prefer case", false, "/", []string{"Respond-Async"}, true},
+		{"prefer list", false, "/", []string{"wait=5, respond-async"}, true},
+		{"prefer repeated", false, "/", []string{"wait=5", "respond-async"}, true},
+		{"prefer other", false, "/", []string{"return=minimal"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig
+			if tt.enabled {
+				cfg = &enabled
+			}
+			r := httptest.NewRequest("GET", tt.target, nil)
+			for _, prefer := range tt.prefer {
+				r.Header.Add("Prefer", prefer)
+			}
+			if got := wantsAsync(cfg, r); got != tt.want {
+				t.Errorf("wantsAsync() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWritePending(t *testing.T) {
+	job := &tools.Job{ID: "abc", Kind: tools.JobKindRequest, Status: tools.JobQueued, Total: 1}
+
+	tests := []struct {
+		name        string
+		accept      string
+		contentType string
+		contains    string
+	}{
+		{"api client", "application/json", "application/json", `"id":"abc"`},
+		{"browser", "text/html,application/xhtml+xml", "text/html; charset=utf-8", `url=/jobs/abc/result`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			writePending(w, r, job)
+
+			if w.Code != http.StatusAccepted {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusAccepted)
+			}
+			for header, want := range map[string]string{
+				"Location":      "/jobs/abc",
+				"Retry-After":   "3",
+				"Cache-Control": "no-store",
+				"Content-Type":  tt.contentType,
+			} {
+				if got := w.Header().Get(header); !strings.HasPrefix(got, want) {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+			if !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("body does not contain %q: %s", tt.contains, w.Body)
+			}
+		})
+	}
+}
+
+func TestHandleJobResult(t *testing.T) {
+	page, _ := json.Marshal(tools.CreateWebResponse(http.StatusOK, "text/html; charset=utf-8", "<p>床前明月光</p>"))
+	pending := &tools.Job{Kind: tools.JobKindRequest, Total: 1}
+	done := &tools.Job{Kind: tools.JobKindRequest, Total: 1}
+	failed := &tools.Job{Kind: tools.JobKindRequest, Total: 1}
+	batch := &tools.Job{Kind: tools.JobKindBatch, Total: 1}
+	other := &tools.Job{Kind: tools.JobKindRequest, Tenant: "other", Total: 1}
+	for _, job := range []*tools.Job{pending, done, failed, batch, other} {
+		if err := tools.CreateJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done.Status, done.Completed, done.Result = tools.JobSucceeded, 1, page
+	failed.Status, failed.Failed, failed.Error = tools.JobFailed, 1, "the page could not be generated"
+	for _, job := range []*tools.Job{done, failed} {
+		if err := tools.UpdateJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		id       string
+		status   int
+		contains string
+	}{
+		{"pending", pending.ID, http.StatusAccepted, pending.ID},
+		{"done", done.ID, http.StatusOK, "床前明月光"},
+		{"no stored page", failed.ID, http.StatusInternalServerError, failed.Error},
+		{"batch job", batch.ID, http.StatusNotFound, "no stored response"},
+		{"other tenant's job", other.ID, http.StatusNotFound, ""},
+		{"unknown job", "00000000-0000-0000-0000-000000000000", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(HandleJobResult(testConfig), "GET", "/jobs/"+tt.id+"/result", "", "", map[string]string{"id": tt.id})
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("body does not contain %q: %s", tt.contains, w.Body)
+			}
+		})
+	}
+}
+
+func TestStartAsyncRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/poem", nil)
+	info, _ := newRequestInfo(testConfig, r, "test")
+	w := httptest.NewRecorder()
+	startAsyncRequest(testConfig, w, r, info, "prompt", false)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	var job tools.Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == "" || job.Kind != tools.JobKindRequest || job.Status != tools.JobQueued {
+		t.Errorf("job = %+v", job)
+	}
+
+	// Without a provider the page is an error page, which fails the job
+	// but is still kept as its response
+	finished := waitForJob(t, job.ID)
+	if finished.Status != tools.JobFailed || len(finished.Result) == 0 {
+		t.Errorf("finished job = %+v, want failed with a stored response", finished)
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+// HandlePoemCardSVG serves GET /poems/:id/card.svg, the poem as an image
+// to share.
+func HandlePoemCardSVG(cfg *config.Config) http.HandlerFunc {
+	return servePoemCard(cfg, "image/svg+xml; charset=utf-8", tools.WritePoemCardSVG)
+}
+
+// HandlePoemCardPNG serves GET /poems/:id/card.png, the SVG card drawn with
+// the configured font.
+func HandlePoemCardPNG(cfg *config.Config) http.HandlerFunc {
+	return servePoemCard(cfg, "image/png", func(w io.Writer, poem tools.Poem) error {
+		return tools.WritePoemCardPNG(w, poem, cfg.Cards.Font)
+	})
+}
+
+// servePoemCard renders a poem's card. Poems do not change once written, so
+// cards are served for conditional GET like the feeds.
+func servePoemCard(cfg *config.Config, contentType string, write func(io.Writer, tools.Poem) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		poem, err := tools.GetPoem(info, id)
+		if err != nil {
+			utils.Log.Error("card", "Failed to get poem", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if poem == nil {
+			writeJSONError(w, http.StatusNotFound, "poem not found")
+			return
+		}
+
+		var body bytes.Buffer
+		if err := write(&body, *poem); err != nil {
+			if errors.Is(err, tools.ErrNoCardFont) {
+				writeJSONError(w, http.StatusNotImplemented, err.Error())
+				return
+			}
+			utils.Log.Error("card", "Failed to render poem card", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeCached(w, r, contentType, tools.PoemTime(*poem), body.Bytes())
+	}
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+// HandleListCollections serves GET /api/collections, newest first.
+func HandleListCollections(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		collections, err := tools.ListCollections(info)
+		if err != nil {
+			utils.Log.Error("collections", "Failed to list collections", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"collections": collections,
+			"count":       len(collections),
+		})
+	}
+}
+
+// HandleCreateCollection serves POST /api/collections with a
+// {"name", "description"} body and responds with the new collection.
+// Creating requires the admin token.
+func HandleCreateCollection(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+
+		var collection tools.Collection
+		if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		if err := tools.ValidateCollection(&collection); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		created, err := tools.CreateCollection(info, collection)
+		if err == tools.ErrCollectionExists {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			utils.Log.Error("collections", "Failed to create collection", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.Log.Success("collections", fmt.Sprintf("Created collection %s", created.Name), nil)
+		writeJSON(w, http.StatusCreated, created)
+	}
+}
+
+// HandleGetCollection serves GET /api/collections/:id with the collection's
+// poems in the order they were added.
+func HandleGetCollection(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		if collection := lookupCollection(w, info, id); collection != nil {
+			writeJSON(w, http.StatusOK, collection)
+		}
+	}
+}
+
+// HandleDeleteCollection serves DELETE /api/collections/:id. The poems
+// themselves are kept. Deleting requires the admin token.
+func HandleDeleteCollection(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		deleted, err := tools.DeleteCollection(info, id)
+		if err != nil {
+			utils.Log.Error("collections", "Failed to delete collection", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !deleted {
+			writeJSONError(w, http.StatusNotFound, "collection not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleCollectionPoem serves PUT and DELETE /api/collections/:id/poems/:poem,
+// which add a poem to the end of the collection and take it out again.
+// Responds with the collection. Both require the admin token.
+func HandleCollectionPoem(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		poemID, ok := pathInt(w, r, "poem")
+		if !ok {
+			return
+		}
+		if lookupCollection(w, info, id) == nil {
+			return
+		}
+
+		var found bool
+		var err error
+		if r.Method == http.MethodDelete {
+			found, err = tools.RemovePoemFromCollection(info, id, poemID)
+		} else {
+			found, err = tools.AddPoemToCollection(info, id, poemID)
+		}
+		if err != nil {
+			utils.Log.Error("collections", "Failed to update collection", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !found {
+			writeJSONError(w, http.StatusNotFound, "poem not found")
+			return
+		}
+		if collection := lookupCollection(w, info, id); collection != nil {
+			writeJSON(w, http.StatusOK, collection)
+		}
+	}
+}
+
+// lookupCollection loads a collection, answering 404 if there is none.
+func lookupCollection(w http.ResponseWriter, info *tools.RequestInfo, id int64) *tools.Collection {
+	collection, err := tools.GetCollection(info, id)
+	if err != nil {
+		utils.Log.Error("collections", "Failed to get collection", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+	if collection == nil {
+		writeJSONError(w, http.StatusNotFound, "collection not found")
+		return nil
+	}
+	return collection
+}
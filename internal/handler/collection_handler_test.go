@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+)
+
+func TestCollectionWritesRequireAdmin(t *testing.T) {
+	disabled := *testConfig
+	disabled.Admin.Token = ""
+
+	writes := []struct {
+		name    string
+		handler func(*config.Config) http.HandlerFunc
+		method  string
+		target  string
+		body    string
+		vars    map[string]string
+	}{
+		{"create collection", HandleCreateCollection, "POST", "/api/collections", `{"name": "秋"}`, nil},
+		{"add poem", HandleCollectionPoem, "PUT", "/api/collections/1/poems/1", "", map[string]string{"id": "1", "poem": "1"}},
+		{"remove poem", HandleCollectionPoem, "DELETE", "/api/collections/1/poems/1", "", map[string]string{"id": "1", "poem": "1"}},
+		{"delete collection", HandleDeleteCollection, "DELETE", "/api/collections/1", "", map[string]string{"id": "1"}},
+		{"tag poem", HandleTagPoem, "PUT", "/api/poems/1/tags", `{"tags": ["秋"]}`, map[string]string{"id": "1"}},
+	}
+	auth := []struct {
+		name   string
+		cfg    *config.Config
+		token  string
+		status int
+	}{
+		{"admin disabled", &disabled, testAdminToken, http.StatusForbidden},
+		{"no token", testConfig, "", http.StatusUnauthorized},
+		{"wrong token", testConfig, "guess", http.StatusUnauthorized},
+	}
+	for _, write := range writes {
+		for _, tt := range auth {
+			t.Run(write.name+"/"+tt.name, func(t *testing.T) {
+				w := serve(write.handler(tt.cfg), write.method, write.target, write.body, tt.token, write.vars)
+				if w.Code != tt.status {
+					t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+				}
+			})
+		}
+	}
+}
+
+func TestCreateCollection(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"invalid body", `{"name": `, http.StatusBadRequest},
+		{"missing name", `{"description": "无名"}`, http.StatusBadRequest},
+		{"created", `{"name": "边塞", "description": "写边塞的诗"}`, http.StatusCreated},
+		{"duplicate name", `{"name": "边塞"}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(HandleCreateCollection(testConfig), "POST", "/api/collections", tt.body, testAdminToken, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusCreated {
+				return
+			}
+			var created tools.Collection
+			if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+				t.Fatal(err)
+			}
+			if created.ID == 0 || created.Name != "边塞" {
+				t.Errorf("collection = %+v", created)
+			}
+
+			// The collection exists, the poem does not
+			id := fmt.Sprint(created.ID)
+			vars := map[string]string{"id": id, "poem": "999999"}
+			w = serve(HandleCollectionPoem(testConfig), "PUT", "/api/collections/"+id+"/poems/999999", "", testAdminToken, vars)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("adding an unknown poem: status = %d, want %d: %s", w.Code, http.StatusNotFound, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+const feedTitle = "AI 中国古典诗歌"
+
+// HandleAtomFeed serves GET /feed.xml, an Atom feed of the newest poems.
+func HandleAtomFeed(cfg *config.Config) http.HandlerFunc {
+	return serveFeed(cfg, "application/atom+xml; charset=utf-8", (*tools.Feed).WriteAtom)
+}
+
+// HandleRSSFeed serves GET /rss.xml, an RSS 2.0 feed of the newest poems.
+func HandleRSSFeed(cfg *config.Config) http.HandlerFunc {
+	return serveFeed(cfg, "application/rss+xml; charset=utf-8", (*tools.Feed).WriteRSS)
+}
+
+// serveFeed renders the feed for conditional GET: the body only changes
+// when poems do, and the newest poem's creation time is Last-Modified.
+func serveFeed(cfg *config.Config, contentType string, write func(*tools.Feed, io.Writer) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+
+		feed, err := tools.LoadFeed(info, feedTitle, requestBaseURL(cfg, r))
+		if err != nil {
+			utils.Log.Error("feed", "Failed to load poems for feed", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		var body bytes.Buffer
+		if err := write(feed, &body); err != nil {
+			utils.Log.Error("feed", "Failed to write feed", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeCached(w, r, contentType, feed.Updated, body.Bytes())
+	}
+}
+
+// requestBaseURL is the scheme and host the request was made to, honouring
+// X-Forwarded-Proto from a trusted proxy terminating TLS.
+func requestBaseURL(cfg *config.Config, r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); (proto == "http" || proto == "https") && fromTrustedProxy(cfg, r) {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestBaseURL(t *testing.T) {
+	trusted := *testConfig
+	trusted.Proxies.Trusted = []string{"10.0.0.0/8"}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		tls        bool
+		proto      string
+		want       string
+	}{
+		{"plain", "10.0.0.1:1234", false, "", "http://poems.example"},
+		{"tls", "10.0.0.1:1234", true, "", "https://poems.example"},
+		{"trusted proxy", "10.0.0.1:1234", false, "https", "https://poems.example"},
+		{"trusted proxy downgrades", "10.0.0.1:1234", true, "http", "http://poems.example"},
+		{"trusted proxy, bad value", "10.0.0.1:1234", false, "ftp", "http://poems.example"},
+		{"untrusted client", "192.0.2.1:1234", false, "https", "http://poems.example"},
+		{"untrusted client over tls", "192.0.2.1:1234", true, "http", "https://poems.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://poems.example/feed.xml", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if got := requestBaseURL(&trusted, r); got != tt.want {
+				t.Errorf("requestBaseURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+	"github.com/nokode/nokode/internal/utils"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+)
+
+// maxBatchPoems bounds the poems one batch job may generate.
+const maxBatchPoems = 200
+
+// batchParams is the body of POST /jobs. The poems cycle through the
+// preferences; no preferences means the random choice.
+type batchParams struct {
+	Count       int      `json:"count"`
+	Preferences []string `json:"preferences"`
+}
+
+// batchResult is the Result of a batch job.
+type batchResult struct {
+	Poems  []batchPoem `json:"poems"`
+	Errors []string    `json:"errors,omitempty"`
+}
+
+type batchPoem struct {
+	ID         int64  `json:"id"`
+	Title      string `json:"title"`
+	Author     string `json:"author"`
+	Preference string `json:"preference"`
+}
+
+var (
+	// jobSlots bounds the generations running at once across all jobs,
+	// sized by Jobs.Concurrency on first use.
+	jobSlots     chan struct{}
+	jobSlotsOnce sync.Once
+
+	// jobCancels holds the cancel function of each running job by id.
+	jobCancels sync.Map
+)
+
+func jobSlotsFor(cfg *config.Config) chan struct{} {
+	jobSlotsOnce.Do(func() {
+		n := cfg.Jobs.Concurrency
+		if n < 1 {
+			n = 1
+		}
+		jobSlots = make(chan struct{}, n)
+	})
+	return jobSlots
+}
+
+// HandleCreateBatchJob serves POST /jobs, queueing the generation of count
+// poems for the given poet preferences. It answers 202 with the job and
+// its progress URL in Location.
+func HandleCreateBatchJob(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+
+		var params batchParams
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		if err := validateBatchParams(&params); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		encoded, _ := json.Marshal(params)
+		job := &tools.Job{Kind: tools.JobKindBatch, Tenant: info.Tenant, Total: params.Count, Params: encoded}
+		if err := tools.CreateJob(job); err != nil {
+			utils.Log.Error("jobs", "Failed to create job", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.Log.Info("jobs", fmt.Sprintf("Queued batch job for %d poems", params.Count), map[string]interface{}{
+			"jobId":       job.ID,
+			"preferences": params.Preferences,
+		})
+		// runBatchJob updates job as it goes, so answer with a copy
+		accepted := *job
+		go runBatchJob(cfg, job, params, info.ClientIP)
+
+		w.Header().Set("Location", "/jobs/"+accepted.ID)
+		writeJSON(w, http.StatusAccepted, accepted)
+	}
+}
+
+// validateBatchParams checks the count and that every preference is a
+// known poet style, defaulting to the random choice.
+func validateBatchParams(params *batchParams) error {
+	if params.Count < 1 || params.Count > maxBatchPoems {
+		return fmt.Errorf("count must be between 1 and %d", maxBatchPoems)
+	}
+	styles, err := tools.ListPoetStyles()
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{tools.RandomPoetStyle: true}
+	for _, style := range styles {
+		known[style.Name] = true
+	}
+	var preferences []string
+	for _, preference := range params.Preferences {
+		preference = strings.TrimSpace(preference)
+		if !known[preference] {
+			return fmt.Errorf("unknown poet preference %q", preference)
+		}
+		preferences = append(preferences, preference)
+	}
+	if len(preferences) == 0 {
+		preferences = []string{tools.RandomPoetStyle}
+	}
+	params.Preferences = preferences
+	return nil
+}
+
+// HandleGetJob serves GET /jobs/:id with the job's progress. Job ids are
+// random, so knowing one is enough to follow the job.
+func HandleGetJob(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		job := lookupJob(w, info, pathvar.Vars(r)["id"])
+		if job == nil {
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	}
+}
+
+// HandleCancelJob serves DELETE /jobs/:id. No new generations are started;
+// those already running finish and are saved.
+func HandleCancelJob(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		job := lookupJob(w, info, pathvar.Vars(r)["id"])
+		if job == nil {
+			return
+		}
+		cancel, ok := jobCancels.Load(job.ID)
+		if job.Finished() || !ok {
+			writeJSONError(w, http.StatusConflict, "job is not running")
+			return
+		}
+		cancel.(context.CancelFunc)()
+		writeJSON(w, http.StatusAccepted, job)
+	}
+}
+
+// lookupJob loads a job of the request's tenant, answering 404 otherwise.
+func lookupJob(w http.ResponseWriter, info *tools.RequestInfo, id string) *tools.Job {
+	job, err := tools.GetJob(id)
+	if err != nil {
+		utils.Log.Error("jobs", "Failed to get job", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+	if job == nil || job.Tenant != info.Tenant {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return nil
+	}
+	return job
+}
+
+// runBatchJob generates the job's poems, at most Jobs.Concurrency at a time
+// across all jobs, saving its progress after every poem.
+func runBatchJob(cfg *config.Config, job *tools.Job, params batchParams, clientIP string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	jobCancels.Store(job.ID, cancel)
+	defer jobCancels.Delete(job.ID)
+	defer cancel()
+
+	var mu sync.Mutex
+	var result batchResult
+	save := func() {
+		job.Result, _ = json.Marshal(result)
+		if err := tools.UpdateJob(job); err != nil {
+			utils.Log.Error("jobs", "Failed to save job progress", err)
+		}
+	}
+	job.Status = tools.JobRunning
+	save()
+
+	slots := jobSlotsFor(cfg)
+	var wg sync.WaitGroup
+	stopped := false
+	for i := 0; i < params.Count; i++ {
+		acquired := false
+		select {
+		case slots <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			if acquired {
+				<-slots
+			}
+			stopped = true
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			preference := params.Preferences[i%len(params.Preferences)]
+			poem, err := generateBatchPoem(cfg, job, i, preference, clientIP)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				job.Failed++
+				result.Errors = append(result.Errors, fmt.Sprintf("poem %d (%s): %s", i+1, preference, err))
+				utils.Log.Warn("jobs", "Batch poem failed", map[string]interface{}{"jobId": job.ID, "poem": i + 1, "error": err.Error()})
+			} else {
+				job.Completed++
+				result.Poems = append(result.Poems, batchPoem{ID: poem.ID, Title: poem.Title, Author: poem.Author, Preference: preference})
+			}
+			save()
+		}(i)
+	}
+	wg.Wait()
+
+	switch {
+	case stopped:
+		job.Status = tools.JobCancelled
+	case job.Completed == 0:
+		job.Status = tools.JobFailed
+		job.Error = "no poem could be generated"
+	default:
+		job.Status = tools.JobSucceeded
+	}
+	save()
+	utils.Log.Success("jobs", fmt.Sprintf("Batch job %s: %d generated, %d failed", job.Status, job.Completed, job.Failed), map[string]interface{}{
+		"jobId": job.ID,
+	})
+}
+
+// generateBatchPoem runs one generation through the same prompt,
+// validation and repair as POST /generate and saves the poem.
+func generateBatchPoem(cfg *config.Config, job *tools.Job, i int, preference, clientIP string) (poem *tools.Poem, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	info := &tools.RequestInfo{
+		RequestID: fmt.Sprintf("%s-%d", job.ID[:8], i+1),
+		Method:    "POST",
+		Route:     "/generate",
+		ClientIP:  clientIP,
+		Provider:  cfg.Provider,
+		Model:     currentModel(cfg),
+		Tenant:    job.Tenant,
+	}
+	formJSON, _ := json.Marshal(map[string]string{"poet_preference": preference})
+	vars := map[string]string{
+		"METHOD":    "POST",
+		"PATH":      "/generate",
+		"URL":       "/generate",
+		"QUERY":     "{}",
+		"HEADERS":   "{}",
+		"BODY":      "null",
+		"FORM":      string(formJSON),
+		"IP":        clientIP,
+		"TIMESTAMP": time.Now().Format(time.RFC3339),
+	}
+	prompt := renderPrompt(info, vars, preference)
+
+	toolsList := getTools()
+	response, err := completeLLM(cfg, info, prompt, toolsList)
+	if err != nil {
+		return nil, err
+	}
+	generated := generatePoem(cfg, info, prompt, toolsList, response)
+	if generated == nil {
+		return nil, errors.New("the model returned no valid, original poem")
+	}
+	generated.Source = tools.PoemSourceGenerated
+	return tools.CreatePoem(info, *generated)
+}
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+)
+
+func TestCreateBatchJob(t *testing.T) {
+	disabled := *testConfig
+	disabled.Admin.Token = ""
+
+	tests := []struct {
+		name   string
+		cfg    *config.Config
+		token  string
+		body   string
+		status int
+	}{
+		{"admin disabled", &disabled, testAdminToken, `{"count": 1}`, http.StatusForbidden},
+		{"no token", testConfig, "", `{"count": 1}`, http.StatusUnauthorized},
+		{"wrong token", testConfig, "guess", `{"count": 1}`, http.StatusUnauthorized},
+		{"invalid body", testConfig, testAdminToken, `{"count": `, http.StatusBadRequest},
+		{"count too low", testConfig, testAdminToken, `{"count": 0}`, http.StatusBadRequest},
+		{"count too high", testConfig, testAdminToken, `{"count": 201}`, http.StatusBadRequest},
+		{"unknown poet", testConfig, testAdminToken, `{"count": 1, "preferences": ["nobody"]}`, http.StatusBadRequest},
+		{"queued", testConfig, testAdminToken, `{"count": 2}`, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(HandleCreateBatchJob(tt.cfg), "POST", "/jobs", tt.body, tt.token, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusAccepted {
+				return
+			}
+
+			var job tools.Job
+			if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+				t.Fatal(err)
+			}
+			if job.ID == "" || job.Kind != tools.JobKindBatch || job.Total != 2 {
+				t.Errorf("job = %+v", job)
+			}
+			if location := w.Header().Get("Location"); location != "/jobs/"+job.ID {
+				t.Errorf("Location = %q, want /jobs/%s", location, job.ID)
+			}
+
+			// Without a provider every poem fails, which finishes the job
+			finished := waitForJob(t, job.ID)
+			if finished.Status != tools.JobFailed || finished.Failed != 2 {
+				t.Errorf("finished job = %+v, want failed with 2 failures", finished)
+			}
+		})
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	own := &tools.Job{Kind: tools.JobKindBatch, Total: 1}
+	other := &tools.Job{Kind: tools.JobKindBatch, Tenant: "other", Total: 1}
+	for _, job := range []*tools.Job{own, other} {
+		if err := tools.CreateJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{"own job", own.ID, http.StatusOK},
+		{"other tenant's job", other.ID, http.StatusNotFound},
+		{"unknown job", "00000000-0000-0000-0000-000000000000", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(HandleGetJob(testConfig), "GET", "/jobs/"+tt.id, "", "", map[string]string{"id": tt.id})
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var job tools.Job
+			if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+				t.Fatal(err)
+			}
+			if job.ID != own.ID || job.Status != tools.JobQueued {
+				t.Errorf("job = %+v", job)
+			}
+		})
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	running := &tools.Job{Kind: tools.JobKindBatch, Total: 1}
+	finished := &tools.Job{Kind: tools.JobKindBatch, Total: 1}
+	for _, job := range []*tools.Job{running, finished} {
+		if err := tools.CreateJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	finished.Status = tools.JobSucceeded
+	if err := tools.UpdateJob(finished); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	jobCancels.Store(running.ID, cancel)
+	defer jobCancels.Delete(running.ID)
+
+	tests := []struct {
+		name   string
+		id     string
+		token  string
+		status int
+	}{
+		{"no token", running.ID, "", http.StatusUnauthorized},
+		{"wrong token", running.ID, "guess", http.StatusUnauthorized},
+		{"unknown job", "00000000-0000-0000-0000-000000000000", testAdminToken, http.StatusNotFound},
+		{"finished job", finished.ID, testAdminToken, http.StatusConflict},
+		{"running job", running.ID, testAdminToken, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(HandleCancelJob(testConfig), "DELETE", "/jobs/"+tt.id, "", tt.token, map[string]string{"id": tt.id})
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if cancelled := ctx.Err() != nil; cancelled != (tt.status == http.StatusAccepted) {
+				t.Errorf("job cancelled = %v after a %d response", cancelled, w.Code)
+			}
+		})
+	}
+}
+
+// waitForJob polls a job until it has finished.
+func waitForJob(t *testing.T, id string) *tools.Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		job, err := tools.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job != nil && job.Finished() {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s did not finish: %+v", id, job)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
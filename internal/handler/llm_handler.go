@@ -5,14 +5,17 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,6 +34,14 @@ var (
 	minInterval     = 3 * time.Second // Minimum 3 seconds between API calls (configurable)
 )
 
+// poemRepairAttempts is how many times an invalid /generate poem is sent
+// back to the LLM for repair.
+const poemRepairAttempts = 2
+
+// poemExamples is how many top rated poems /generate shows the LLM as
+// examples of the chosen style.
+const poemExamples = 3
+
 // init initializes rate limiting settings
 func init() {
 	// Allow configuration via environment variable
@@ -221,9 +232,34 @@ func HandleLLMRequest(cfg *config.Config) http.HandlerFunc {
 		utils.Log.Request(r.Method, path, map[string]interface{}{
 			"requestId": requestID,
 			"query":     r.URL.Query(),
-			"ip":        getClientIP(r),
+			"ip":        getClientIP(cfg, r),
 		})
 
+		info, status := newRequestInfo(cfg, r, requestID)
+		if tools.TenancyEnabled() {
+			if info.Tenant == "" {
+				utils.Log.Warn("tenancy", "Rejected request without a tenant", map[string]interface{}{
+					"requestId": requestID,
+					"host":      r.Host,
+				})
+				if status == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", `Basic realm="nokode"`)
+				}
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(status)
+				fmt.Fprintf(w, `
+				<html>
+					<body>
+						<h1>Unknown Tenant</h1>
+						<p>This request could not be mapped to a tenant.</p>
+						<p><strong>Request ID:</strong> %s</p>
+					</body>
+				</html>
+			`, requestID)
+				return
+			}
+		}
+
 		// Prepare request context
 		var bodyBytes []byte
 		if r.Body != nil {
@@ -236,12 +272,6 @@ func HandleLLMRequest(cfg *config.Config) http.HandlerFunc {
 			json.Unmarshal(bodyBytes, &body)
 		}
 
-		// Load memory and prompt
-		memory := utils.LoadMemory()
-		promptTemplate := utils.LoadPrompt()
-		schema := tools.GetCachedSchema()
-		dbContext := tools.GetDatabaseContext()
-
 		// Parse form data if POST request
 		var formData map[string]interface{}
 		if r.Method == "POST" {
@@ -269,26 +299,36 @@ func HandleLLMRequest(cfg *config.Config) http.HandlerFunc {
 			"HEADERS":   string(headersJSON),
 			"BODY":      string(bodyJSON),
 			"FORM":      string(formJSON),
-			"IP":        getClientIP(r),
+			"IP":        getClientIP(cfg, r),
 			"TIMESTAMP": time.Now().Format(time.RFC3339),
-			"MEMORY":    memory + schema + dbContext,
 		}
+		preference, _ := formData["poet_preference"].(string)
+		prompt := renderPrompt(info, vars, preference)
 
-		prompt := utils.ReplaceTemplateVars(promptTemplate, vars)
+		generate := r.Method == "POST" && r.URL.Path == "/generate"
+		if wantsAsync(cfg, r) {
+			startAsyncRequest(cfg, w, r, info, prompt, generate)
+			return
+		}
+		writeWebResponse(w, respondLLM(cfg, info, prompt, generate, requestStartTime))
+	}
+}
 
-		// Define tools
-		toolsList := getTools()
+// respondLLM runs the LLM on a prepared prompt and returns the page for the
+// request: the generated poem for POST /generate, the model's webResponse,
+// or a fallback poem page when the model gave none.
+func respondLLM(cfg *config.Config, info *tools.RequestInfo, prompt string, generate bool, requestStartTime time.Time) tools.WebResponse {
+	// Define tools
+	toolsList := getTools()
 
-		// Call LLM
-		llmStartTime := time.Now()
-		response, err := callLLM(cfg, prompt, toolsList)
-		llmDuration := time.Since(llmStartTime).Milliseconds()
+	// Call LLM
+	llmStartTime := time.Now()
+	response, err := completeLLM(cfg, info, prompt, toolsList)
+	llmDuration := time.Since(llmStartTime).Milliseconds()
 
-		if err != nil {
-			utils.Log.Error("llm", "LLM call failed", err)
-			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, `
+	if err != nil {
+		utils.Log.Error("llm", "LLM call failed", err)
+		return tools.CreateWebResponse(http.StatusInternalServerError, "text/html", fmt.Sprintf(`
 				<html>
 					<body>
 						<h1>Server Error</h1>
@@ -297,142 +337,405 @@ func HandleLLMRequest(cfg *config.Config) http.HandlerFunc {
 						<pre>%s</pre>
 					</body>
 				</html>
-			`, requestID, err.Error())
-			return
-		}
+			`, info.RequestID, err.Error()))
+	}
 
-		utils.Log.Info("llm", fmt.Sprintf("LLM call completed in %dms", llmDuration), map[string]interface{}{
-			"requestId": requestID,
-			"duration":  llmDuration,
-		})
+	utils.Log.Info("llm", fmt.Sprintf("LLM call completed in %dms", llmDuration), map[string]interface{}{
+		"requestId": info.RequestID,
+		"duration":  llmDuration,
+	})
 
-		// Check if we need to process tool calls first (OpenAI/Qwen compatible)
-		// Anthropic tool calls are handled in callAnthropic
-		if cfg.Provider == "openai" || cfg.Provider == "qwen" {
-			needsToolProcessing := false
-			for _, choice := range response.Choices {
-				if choice.FinishReason == "tool_calls" {
-					needsToolProcessing = true
-					break
-				}
+	// Special handling for POST /generate requests
+	if generate {
+		if poem := generatePoem(cfg, info, prompt, toolsList, response); poem != nil {
+			poem.Source = tools.PoemSourceGenerated
+			saved, err := tools.CreatePoem(info, *poem)
+			if err == nil && saved != nil {
+				utils.Log.Success("poem", fmt.Sprintf("Saved poem to database: %s", saved.Title), nil)
+				utils.Log.Success("response", "Generated and saved poem successfully", nil)
+				// Generate beautiful HTML page
+				return tools.CreateWebResponse(http.StatusOK, "text/html; charset=utf-8", generatePoemDisplayHTML(*saved))
 			}
+			utils.Log.Error("poem", "Database save failed", err)
+		}
+	}
 
-			// If tool calls are needed, process them recursively
-			if needsToolProcessing {
-				finalResponse, err := processToolCallsRecursive(cfg, prompt, toolsList, response)
-				if err != nil {
-					utils.Log.Error("llm", "Failed to process tool calls", err)
-				} else {
-					response = finalResponse
-				}
+	// Extract webResponse from final response
+	totalDuration := time.Since(requestStartTime).Milliseconds()
+	if webResponse := extractWebResponse(response); webResponse != nil {
+		utils.Log.Success("response", fmt.Sprintf("Sent webResponse (%d) in %dms", webResponse.StatusCode, totalDuration), nil)
+		return *webResponse
+	}
+
+	// Fallback: try to get a random poem from database
+	utils.Log.Warn("response", "No webResponse found, showing fallback poem page", nil)
+	return tools.CreateWebResponse(http.StatusOK, "text/html; charset=utf-8", generateFallbackPoemPage(cfg, info))
+}
+
+// writeWebResponse sends a page rendered by respondLLM.
+func writeWebResponse(w http.ResponseWriter, webResponse tools.WebResponse) {
+	for key, value := range webResponse.Headers {
+		w.Header().Set(key, value)
+	}
+	w.WriteHeader(webResponse.StatusCode)
+	w.Write([]byte(webResponse.Body))
+}
+
+// renderPrompt fills the prompt template for the request described by
+// vars, adding the memory, the database schema and the poet styles. For
+// POST /generate it also shows the top rated poems of the chosen poet.
+func renderPrompt(info *tools.RequestInfo, vars map[string]string, preference string) string {
+	memory := utils.LoadMemory()
+	promptTemplate := utils.LoadPrompt()
+	schema := tools.GetSchema(info)
+	dbContext := tools.GetDatabaseContext()
+	vars["MEMORY"] = memory + schema + dbContext
+	vars["DIALECT"] = tools.CurrentDialect().Name()
+
+	styles, err := tools.ListPoetStyles()
+	if err != nil {
+		utils.Log.Warn("styles", "Failed to load poet styles", map[string]interface{}{"error": err.Error()})
+	}
+	vars["POET_CHOICES"] = poetChoicesHTML(styles)
+	vars["POET_STYLE_GUIDE"] = tools.PoetStyleGuide(styles, preference)
+	vars["POEM_EXAMPLES"] = ""
+	if vars["METHOD"] == "POST" && vars["PATH"] == "/generate" {
+		examples, err := tools.TopRatedPoems(info, preference, poemExamples)
+		if err != nil {
+			utils.Log.Warn("ratings", "Failed to load top rated poems", map[string]interface{}{"error": err.Error()})
+		}
+		vars["POEM_EXAMPLES"] = tools.PoemExamplesPrompt(examples)
+	}
+	vars["FORM_CHOICES"] = formChoicesHTML()
+	vars["FORM_GUIDE"] = ""
+	if vars["METHOD"] == "POST" && vars["PATH"] == "/generate" {
+		var form map[string]interface{}
+		json.Unmarshal([]byte(vars["FORM"]), &form)
+		choice, _ := form["poem_form"].(string)
+		vars["FORM_GUIDE"] = tools.PoemFormGuide(choice)
+	}
+	vars["POEM_TAGS"] = poemTagsPrompt(info, vars)
+	return utils.ReplaceTemplateVars(promptTemplate, vars)
+}
+
+// formChoicesHTML renders the form select of the /generate form, shi
+// first and then the ci tunes.
+func formChoicesHTML() string {
+	var b strings.Builder
+	b.WriteString(`<div class="form-choice">
+                <label for="poem_form">诗体</label>
+                <select name="poem_form" id="poem_form">
+                    <option value="random" selected>随机</option>
+`)
+	groups := map[string]string{tools.FormShi: "诗", tools.FormCi: "词牌"}
+	kind := ""
+	for _, form := range tools.PoemForms() {
+		if form.Kind != kind {
+			if kind != "" {
+				b.WriteString("                    </optgroup>\n")
 			}
+			kind = form.Kind
+			fmt.Fprintf(&b, "                    <optgroup label=\"%s\">\n", groups[kind])
 		}
+		fmt.Fprintf(&b, "                    <option value=\"%[1]s\">%[1]s（%[2]d字）</option>\n", html.EscapeString(form.Name), form.Characters())
+	}
+	b.WriteString(`                    </optgroup>
+                </select>
+            </div>`)
+	return b.String()
+}
 
-		// Special handling for POST /generate requests
-		if r.Method == "POST" && r.URL.Path == "/generate" {
-			if response.Choices != nil && len(response.Choices) > 0 {
-				contentInterface := response.Choices[0].Message.Content
-				content, ok := contentInterface.(string)
-				if !ok {
-					utils.Log.Error("response", "Content is not a string", nil)
-				} else {
-					// Log the raw content for debugging
-					utils.Log.Info("poem", fmt.Sprintf("AI response content: %s", content), nil)
-
-					// Try to parse as JSON poem data
-					var poemData map[string]interface{}
-					if err := json.Unmarshal([]byte(content), &poemData); err == nil {
-						// Validate required fields
-						requiredFields := []string{"title", "author", "dynasty", "content", "user_preference"}
-						valid := true
-						for _, field := range requiredFields {
-							if poemData[field] == nil {
-								utils.Log.Error("poem", fmt.Sprintf("Missing required field: %s", field), nil)
-								valid = false
-								break
-							}
-						}
+// poemTagsPrompt describes the stored tags to the model on GET /poems and
+// GET /poems/{id}, honouring a ?tag= filter on the list.
+func poemTagsPrompt(info *tools.RequestInfo, vars map[string]string) string {
+	if vars["METHOD"] != "GET" {
+		return ""
+	}
+	var tag string
+	var poemID int64
+	switch {
+	case vars["PATH"] == "/poems":
+		if u, err := url.Parse(vars["URL"]); err == nil {
+			tag = strings.TrimSpace(u.Query().Get("tag"))
+		}
+	case strings.HasPrefix(vars["PATH"], "/poems/"):
+		id, err := strconv.ParseInt(strings.TrimPrefix(vars["PATH"], "/poems/"), 10, 64)
+		if err != nil {
+			return ""
+		}
+		poemID = id
+	default:
+		return ""
+	}
+	prompt, err := tools.PoemTagsPrompt(info, tag, poemID)
+	if err != nil {
+		utils.Log.Warn("tags", "Failed to load poem tags", map[string]interface{}{"error": err.Error()})
+	}
+	return prompt
+}
 
-						if valid {
-							// Save to database
-							query := "INSERT INTO poems (title, author, dynasty, content, user_preference) VALUES (?, ?, ?, ?, ?)"
-							params := []interface{}{
-								poemData["title"],
-								poemData["author"],
-								poemData["dynasty"],
-								poemData["content"],
-								poemData["user_preference"],
-							}
-
-							result := tools.ExecuteDatabaseQuery(query, params, "insert")
-							if result.Success {
-								utils.Log.Success("poem", fmt.Sprintf("Saved poem to database: %v", poemData["title"]), nil)
-								// Generate beautiful HTML page
-								html := generatePoemDisplayHTML(poemData)
-								w.Header().Set("Content-Type", "text/html; charset=utf-8")
-								w.WriteHeader(http.StatusOK)
-								w.Write([]byte(html))
-								utils.Log.Success("response", "Generated and saved poem successfully", nil)
-								return
-							} else {
-								utils.Log.Error("poem", fmt.Sprintf("Database save failed: %s", result.Error), nil)
-							}
-						} else {
-							utils.Log.Error("poem", "JSON validation failed - missing required fields", nil)
-						}
-					} else {
-						utils.Log.Error("poem", fmt.Sprintf("JSON parse failed: %v, content: %s", err, content), nil)
-					}
-				}
+// completeLLM calls the LLM and, for OpenAI compatible providers, runs the
+// tool calls it asks for until it gives a final answer. Anthropic tool calls
+// are handled in callAnthropic.
+func completeLLM(cfg *config.Config, info *tools.RequestInfo, prompt string, toolsList []Tool) (*LLMResponse, error) {
+	response, err := callLLM(cfg, info, prompt, toolsList)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Provider == "openai" || cfg.Provider == "qwen" {
+		needsToolProcessing := false
+		for _, choice := range response.Choices {
+			if choice.FinishReason == "tool_calls" {
+				needsToolProcessing = true
+				break
 			}
 		}
 
-		// Extract webResponse from final response
-		webResponse := extractWebResponse(response)
+		// If tool calls are needed, process them recursively
+		if needsToolProcessing {
+			finalResponse, err := processToolCallsRecursive(cfg, info, prompt, toolsList, response)
+			if err != nil {
+				utils.Log.Error("llm", "Failed to process tool calls", err)
+			} else {
+				response = finalResponse
+			}
+		}
+	}
+	return response, nil
+}
 
-		// Send response
-		totalDuration := time.Since(requestStartTime).Milliseconds()
-		if webResponse != nil {
-			// Set status code
-			w.WriteHeader(webResponse.StatusCode)
+// generatePoem validates the poem JSON the LLM returned for /generate. An
+// invalid poem, a near-duplicate of a saved or famous poem, or one scoring
+// below Prosody.MinScore is sent back with the problems found, up to
+// poemRepairAttempts times. When the attempts run out the best-scoring
+// valid, original poem is kept; nil means there was none.
+func generatePoem(cfg *config.Config, info *tools.RequestInfo, prompt string, toolsList []Tool, response *LLMResponse) *tools.Poem {
+	var best *tools.Poem
+	bestScore := -1
+	for attempt := 0; ; attempt++ {
+		var content string
+		if len(response.Choices) > 0 {
+			content, _ = response.Choices[0].Message.Content.(string)
+		}
+		utils.Log.Info("poem", fmt.Sprintf("AI response content: %s", content), nil)
 
-			// Set headers
-			for key, value := range webResponse.Headers {
-				w.Header().Set(key, value)
+		poem, err := tools.ParseGeneratedPoem(content)
+		if err == nil {
+			err = checkPoemOriginal(cfg, info, poem)
+		}
+		if err == nil {
+			score := tools.ScorePoem(*poem)
+			if cfg.Prosody.MinScore <= 0 || score == nil || score.Score >= cfg.Prosody.MinScore {
+				return poem
+			}
+			if score.Score > bestScore {
+				best, bestScore = poem, score.Score
 			}
+			err = &tools.PoemValidationError{Problems: append([]string{
+				fmt.Sprintf("the %s scores %d for prosody, at least %d is required", score.Form, score.Score, cfg.Prosody.MinScore),
+			}, score.Notes...)}
+		}
+		utils.Log.Warn("poem", "Generated poem failed validation", map[string]interface{}{
+			"requestId": info.RequestID,
+			"attempt":   attempt + 1,
+			"problems":  err.Error(),
+		})
+		if attempt == poemRepairAttempts {
+			return best
+		}
+
+		response, err = completeLLM(cfg, info, poemRepairPrompt(prompt, content, err), toolsList)
+		if err != nil {
+			utils.Log.Error("llm", "Poem repair call failed", err)
+			return nil
+		}
+	}
+}
+
+// checkPoemOriginal compares a generated poem with the saved poems and the
+// famous poem corpus, keeping the closest match on the poem so it is
+// recorded when the poem is saved. Near-duplicates are returned as a
+// *tools.PoemValidationError so they are re-generated like invalid poems.
+func checkPoemOriginal(cfg *config.Config, info *tools.RequestInfo, poem *tools.Poem) error {
+	match, err := tools.CheckDuplicate(info, poem.Content)
+	if err != nil {
+		utils.Log.Warn("similarity", "Failed to compare poem with existing poems", map[string]interface{}{
+			"requestId": info.RequestID,
+			"error":     err.Error(),
+		})
+	}
+	poem.Similarity = match
+	if match == nil || match.Score < cfg.Duplicates.Threshold {
+		return nil
+	}
+
+	source := "a poem already in the collection"
+	if match.Source == "corpus" {
+		source = "a famous classical poem"
+	}
+	return &tools.PoemValidationError{Problems: []string{
+		fmt.Sprintf("the poem is %d%% the same as 《%s》 by %s, %s; write an original poem instead of repeating or adapting it", match.Score, match.Title, match.Author, source),
+	}}
+}
+
+// poemRepairPrompt repeats the request with the rejected answer and what
+// was wrong with it.
+func poemRepairPrompt(prompt, content string, err error) string {
+	var b strings.Builder
+	b.WriteString(prompt)
+	b.WriteString("\n\n## Repair\n\nYour previous answer to this request was rejected:\n")
+	if v, ok := err.(*tools.PoemValidationError); ok {
+		for _, problem := range v.Problems {
+			b.WriteString("- " + problem + "\n")
+		}
+	} else {
+		b.WriteString("- " + err.Error() + "\n")
+	}
+	b.WriteString("\nPrevious answer:\n" + content + "\n\n")
+	b.WriteString("Write a corrected poem and return ONLY the JSON object, without markdown fences or any other text.")
+	return b.String()
+}
+
+// currentModel returns the model name configured for the active provider
+func currentModel(cfg *config.Config) string {
+	switch cfg.Provider {
+	case "qwen":
+		return cfg.Qwen.Model
+	case "openai":
+		return cfg.OpenAI.Model
+	case "anthropic":
+		return cfg.Anthropic.Model
+	case "baidu":
+		return cfg.Baidu.Model
+	case "spark":
+		return cfg.Spark.Model
+	}
+	return ""
+}
+
+// newRequestInfo describes the request for the database layer. When
+// tenancy is enabled but no tenant matches, Tenant is left empty and the
+// returned status is the one to reject the request with.
+func newRequestInfo(cfg *config.Config, r *http.Request, requestID string) (*tools.RequestInfo, int) {
+	info := &tools.RequestInfo{
+		RequestID: requestID,
+		Method:    r.Method,
+		Route:     r.URL.Path,
+		ClientIP:  getClientIP(cfg, r),
+		Provider:  cfg.Provider,
+		Model:     currentModel(cfg),
+	}
+	status := 0
+	if tools.TenancyEnabled() {
+		info.Tenant, status = getTenant(cfg, r)
+	}
+	return info, status
+}
 
-			// Send body
-			w.Write([]byte(webResponse.Body))
-			utils.Log.Success("response", fmt.Sprintf("Sent webResponse (%d) in %dms", webResponse.StatusCode, totalDuration), nil)
+// getTenant maps the request to one of the configured Tenancy.Tenants by
+// its host name or, with Source "auth", by its identity. The identity
+// header is only taken from trusted proxies, and the Basic auth user only
+// once its password matches Tenancy.Credentials. Requests that match no
+// tenant fall back to Tenancy.Default; without one, getTenant returns the
+// status to reject them with.
+func getTenant(cfg *config.Config, r *http.Request) (string, int) {
+	var key string
+	rejected := http.StatusForbidden
+	if cfg.Tenancy.Source == "auth" {
+		if cfg.Tenancy.Header != "" {
+			key = r.Header.Get(cfg.Tenancy.Header)
+			if key != "" && !fromTrustedProxy(cfg, r) {
+				utils.Log.Warn("tenancy", "Ignored identity header from an untrusted address", map[string]interface{}{
+					"ip": r.RemoteAddr,
+				})
+				return "", http.StatusForbidden
+			}
 		} else {
-			// Fallback: try to get a random poem from database
-			fallbackHTML := generateFallbackPoemPage(cfg)
-			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(fallbackHTML))
-			utils.Log.Warn("response", "No webResponse found, showing fallback poem page", nil)
+			rejected = http.StatusUnauthorized
+			if user, password, ok := r.BasicAuth(); ok {
+				expected, known := cfg.Tenancy.Credentials[user]
+				if !known || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+					return "", http.StatusUnauthorized
+				}
+				key = user
+			}
 		}
+	} else {
+		key = strings.ToLower(r.Host)
+		if host, _, err := net.SplitHostPort(r.Host); err == nil {
+			key = strings.ToLower(host)
+		}
+	}
+
+	if tenant, ok := cfg.Tenancy.Tenants[key]; ok && key != "" {
+		return tools.TenantID(tenant), 0
 	}
+	if tenant := tools.TenantID(cfg.Tenancy.Default); tenant != "" {
+		return tenant, 0
+	}
+	return "", rejected
 }
 
-func getClientIP(r *http.Request) string {
-	// Try X-Forwarded-For header first
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+// fromTrustedProxy reports whether the request's peer address is one of
+// Proxies.Trusted.
+func fromTrustedProxy(cfg *config.Config, r *http.Request) bool {
+	return isTrustedProxy(cfg, remoteIP(r))
+}
+
+// isTrustedProxy reports whether ip is one of Proxies.Trusted, given as IPs
+// or CIDRs.
+func isTrustedProxy(cfg *config.Config, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range cfg.Proxies.Trusted {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if trusted := net.ParseIP(proxy); trusted != nil && trusted.Equal(addr) {
+			return true
 		}
 	}
+	return false
+}
 
-	// Try X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+// remoteIP returns the IP of the request's peer.
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
 	}
+	return r.RemoteAddr
+}
 
-	// Fall back to RemoteAddr
-	ip := r.RemoteAddr
-	if idx := strings.LastIndex(ip, ":"); idx != -1 {
-		ip = ip[:idx]
+// getClientIP returns the client's IP. X-Forwarded-For and X-Real-IP can be
+// set by anyone, so they are only honoured on requests from a trusted proxy:
+// the client is then the last forwarded address that is not a trusted proxy
+// itself.
+func getClientIP(cfg *config.Config, r *http.Request) string {
+	ip := remoteIP(r)
+	if !isTrustedProxy(cfg, ip) {
+		return ip
+	}
+
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			ip = hop
+			if !isTrustedProxy(cfg, hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+		return xri
 	}
 	return ip
 }
@@ -502,7 +805,7 @@ func getTools() []Tool {
 			Type: "function",
 			Function: ToolFunction{
 				Name:        "database",
-				Description: "Execute SQL queries on the MySQL database. You can create tables, insert data, query, update, delete - any SQL operation.",
+				Description: fmt.Sprintf("Execute SQL queries on the %s database. You can create tables, insert data, query, update, delete - any SQL operation. %s", tools.CurrentDialect().Name(), tools.CurrentDialect().Hint()),
 				Parameters: map[string]interface{}{
 					"type": "object",
 					"properties": map[string]interface{}{
@@ -525,6 +828,86 @@ func getTools() []Tool {
 				},
 			},
 		},
+		{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        "databaseBatch",
+				Description: "Execute an ordered list of SQL statements in one transaction and get a result for each statement (rows, affected counts, insert IDs, errors). If any statement fails, the whole batch is rolled back and the remaining statements are skipped. Use this instead of several database calls for multi-step writes.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"statements": map[string]interface{}{
+							"type":        "array",
+							"description": "Statements to execute in order",
+							"items": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"query": map[string]interface{}{
+										"type":        "string",
+										"description": "A single SQL statement",
+									},
+									"params": map[string]interface{}{
+										"type":        "array",
+										"description": "Optional parameters for the prepared statement",
+									},
+								},
+								"required": []string{"query"},
+							},
+						},
+					},
+					"required": []string{"statements"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        "searchPoems",
+				Description: "Full-text search over stored poems by title, content and author. Returns ranked results with HTML snippets where matches are wrapped in <mark>. Prefer this over writing LIKE queries.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"query": map[string]interface{}{
+							"type":        "string",
+							"description": "Search terms, separated by spaces",
+						},
+						"limit": map[string]interface{}{
+							"type":        "number",
+							"description": "Maximum number of results (default 20, max 100)",
+						},
+					},
+					"required": []string{"query"},
+				},
+			},
+		},
+		{
+			Type: "function",
+			Function: ToolFunction{
+				Name:        "tagPoem",
+				Description: "Tag a stored poem with short themes such as 月, 酒, 思乡 or a season. Tags are kept by the server, not in the poems table; poems have at most 8 tags of at most 20 characters.",
+				Parameters: map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"poemId": map[string]interface{}{
+							"type":        "number",
+							"description": "The id of the poem",
+						},
+						"tags": map[string]interface{}{
+							"type":        "array",
+							"items":       map[string]interface{}{"type": "string"},
+							"description": "Tags to give the poem",
+						},
+						"mode": map[string]interface{}{
+							"type":        "string",
+							"enum":        []string{"add", "replace"},
+							"default":     "add",
+							"description": "Whether to add to the poem's tags or replace them",
+						},
+					},
+					"required": []string{"poemId", "tags"},
+				},
+			},
+		},
 		{
 			Type: "function",
 			Function: ToolFunction{
@@ -575,7 +958,7 @@ func getTools() []Tool {
 	}
 }
 
-func executeToolCall(tcMap map[string]interface{}) interface{} {
+func executeToolCall(info *tools.RequestInfo, tcMap map[string]interface{}) interface{} {
 	toolName, _ := tcMap["name"].(string)
 	argsRaw, _ := tcMap["arguments"]
 
@@ -601,9 +984,60 @@ func executeToolCall(tcMap map[string]interface{}) interface{} {
 			params = p
 		}
 
-		result := tools.ExecuteDatabaseQuery(query, params, mode)
+		result := tools.ExecuteModelQuery(info, query, params, mode)
 		return result
 
+	case "databaseBatch":
+		var statements []tools.BatchStatement
+		items, _ := args["statements"].([]interface{})
+		for _, item := range items {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			statement := tools.BatchStatement{}
+			statement.Query, _ = itemMap["query"].(string)
+			statement.Params, _ = itemMap["params"].([]interface{})
+			statements = append(statements, statement)
+		}
+
+		result := tools.ExecuteDatabaseBatch(info, statements)
+		return result
+
+	case "searchPoems":
+		query, _ := args["query"].(string)
+		limit := 0
+		if l, ok := args["limit"].(float64); ok {
+			limit = int(l)
+		}
+
+		results, err := tools.SearchPoems(info, query, limit)
+		if err != nil {
+			return map[string]interface{}{"success": false, "error": err.Error()}
+		}
+		return map[string]interface{}{"success": true, "results": results, "count": len(results)}
+
+	case "tagPoem":
+		poemID, _ := args["poemId"].(float64)
+		var tags []string
+		if items, ok := args["tags"].([]interface{}); ok {
+			for _, item := range items {
+				if tag, ok := item.(string); ok {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		mode, _ := args["mode"].(string)
+
+		result, err := tools.TagPoem(info, int64(poemID), tags, mode == "replace")
+		if err != nil {
+			return map[string]interface{}{"success": false, "error": err.Error()}
+		}
+		if result == nil {
+			return map[string]interface{}{"success": false, "error": "poem not found"}
+		}
+		return map[string]interface{}{"success": true, "poemId": int64(poemID), "tags": result}
+
 	case "webResponse":
 		statusCode := 200
 		if sc, ok := args["statusCode"].(float64); ok {
@@ -655,15 +1089,15 @@ func extractWebResponse(response *LLMResponse) *tools.WebResponse {
 	return nil
 }
 
-func processToolCallsRecursive(cfg *config.Config, initialPrompt string, toolsList []Tool, initialResp *LLMResponse) (*LLMResponse, error) {
+func processToolCallsRecursive(cfg *config.Config, info *tools.RequestInfo, initialPrompt string, toolsList []Tool, initialResp *LLMResponse) (*LLMResponse, error) {
 	if cfg.Provider == "qwen" {
-		return processToolCallsQwen(cfg, initialPrompt, toolsList, initialResp)
+		return processToolCallsQwen(cfg, info, initialPrompt, toolsList, initialResp)
 	} else if cfg.Provider == "openai" {
-		return processToolCallsOpenAI(cfg, initialPrompt, toolsList, initialResp)
+		return processToolCallsOpenAI(cfg, info, initialPrompt, toolsList, initialResp)
 	} else if cfg.Provider == "baidu" {
-		return processToolCallsBaidu(cfg, initialPrompt, toolsList, initialResp)
+		return processToolCallsBaidu(cfg, info, initialPrompt, toolsList, initialResp)
 	} else if cfg.Provider == "spark" {
-		return processToolCallsSpark(cfg, initialPrompt, toolsList, initialResp)
+		return processToolCallsSpark(cfg, info, initialPrompt, toolsList, initialResp)
 	} else {
 		// Anthropic tool calls are handled in callAnthropic function
 		// This should not be called for Anthropic as tool calls are handled there
@@ -671,17 +1105,17 @@ func processToolCallsRecursive(cfg *config.Config, initialPrompt string, toolsLi
 	}
 }
 
-func callLLM(cfg *config.Config, prompt string, toolsList []Tool) (*LLMResponse, error) {
+func callLLM(cfg *config.Config, info *tools.RequestInfo, prompt string, toolsList []Tool) (*LLMResponse, error) {
 	if cfg.Provider == "qwen" {
-		return callQwen(cfg, prompt, toolsList)
+		return callQwen(cfg, info, prompt, toolsList)
 	} else if cfg.Provider == "openai" {
-		return callOpenAI(cfg, prompt, toolsList)
+		return callOpenAI(cfg, info, prompt, toolsList)
 	} else if cfg.Provider == "anthropic" {
-		return callAnthropic(cfg, prompt, toolsList)
+		return callAnthropic(cfg, info, prompt, toolsList)
 	} else if cfg.Provider == "baidu" {
-		return callBaidu(cfg, prompt, toolsList)
+		return callBaidu(cfg, info, prompt, toolsList)
 	} else if cfg.Provider == "spark" {
-		return callSpark(cfg, prompt, toolsList)
+		return callSpark(cfg, info, prompt, toolsList)
 	}
 	return nil, fmt.Errorf("unsupported provider: %s", cfg.Provider)
 }
@@ -813,7 +1247,7 @@ func generateSparkAuthURL(appID, apiKey, apiSecret string) (string, error) {
 	return gptURL + "?" + params.Encode(), nil
 }
 
-func callSpark(cfg *config.Config, prompt string, toolsList []Tool) (*LLMResponse, error) {
+func callSpark(cfg *config.Config, info *tools.RequestInfo, prompt string, toolsList []Tool) (*LLMResponse, error) {
 	// Rate limiting: ensure minimum interval between API calls
 	apiCallMutex.Lock()
 	timeSinceLastCall := time.Since(lastAPICallTime)
@@ -978,18 +1412,77 @@ func callSpark(cfg *config.Config, prompt string, toolsList []Tool) (*LLMRespons
 }
 
 // generatePoemDisplayHTML generates a beautiful HTML page to display the generated poem
-func generatePoemDisplayHTML(poemData map[string]interface{}) string {
-	title := poemData["title"].(string)
-	author := poemData["author"].(string)
-	dynasty := poemData["dynasty"].(string)
-	content := poemData["content"].(string)
-	userPreference := poemData["user_preference"].(string)
+func generatePoemDisplayHTML(poem tools.Poem) string {
+	title := html.EscapeString(poem.Title)
+	author := html.EscapeString(poem.Author)
+	userPreference := html.EscapeString(poem.UserPreference)
+
+	// One block per stanza, so ci show the break between their stanzas
+	var content strings.Builder
+	for _, stanza := range tools.PoemStanzas(poem.Content) {
+		content.WriteString("<div>" + html.EscapeString(strings.Join(stanza, "\n")) + "</div>")
+	}
 
 	// Convert dynasty to display text
 	dynastyText := "唐代"
-	if dynasty == "song" {
+	if poem.Dynasty == "song" {
 		dynastyText = "宋代"
 	}
+	if poem.Form != "" {
+		dynastyText += " · " + html.EscapeString(poem.Form)
+	}
+
+	prosody := ""
+	if p := poem.Prosody; p != nil {
+		prosody = fmt.Sprintf(`<div class="prosody">%s · 格律 %d 分<br>句式 %d · 平仄 %d · 押韵 %d`,
+			p.Form, p.Score, p.Length, p.Tone, p.Rhyme)
+		if p.RhymeGroup != "" {
+			prosody += "（" + p.RhymeGroup + "）"
+		}
+		prosody += "</div>"
+	}
+
+	tags := ""
+	if len(poem.Tags) > 0 {
+		var links []string
+		for _, tag := range poem.Tags {
+			links = append(links, fmt.Sprintf(`<a href="/poems?tag=%s">#%s</a>`, url.QueryEscape(tag), html.EscapeString(tag)))
+		}
+		tags = `<div class="tags">` + strings.Join(links, " ") + `</div>`
+	}
+
+	// Star rating and favorite buttons, posted to the poems API
+	var stars strings.Builder
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(&stars, `<button type="button" class="star" data-rating="%d">★</button>`, i)
+	}
+	feedback := fmt.Sprintf(`<div class="feedback">
+                评分 %s
+                <button type="button" class="favorite">♡ 收藏</button>
+                <a class="share" href="/poems/%[2]d/card.svg" target="_blank">分享诗卡</a>
+                <div class="feedback-status"></div>
+            </div>
+            <script>
+            (function () {
+                var base = '/api/poems/%[2]d/', status = document.querySelector('.feedback-status'), favorite = false;
+                function send(path, method, body) {
+                    fetch(base + path, {method: method, headers: {'Content-Type': 'application/json'}, body: body && JSON.stringify(body)})
+                        .then(function (r) { return r.json(); })
+                        .then(function (s) { status.textContent = s.error || ('平均 ' + s.average + ' 星 · ' + s.count + ' 人评分 · ' + s.favorites + ' 人收藏'); });
+                }
+                document.querySelectorAll('.star').forEach(function (b) {
+                    b.onclick = function () {
+                        document.querySelectorAll('.star').forEach(function (s) { s.classList.toggle('on', s.dataset.rating <= b.dataset.rating); });
+                        send('rating', 'PUT', {rating: Number(b.dataset.rating)});
+                    };
+                });
+                document.querySelector('.favorite').onclick = function () {
+                    favorite = !favorite;
+                    this.textContent = favorite ? '♥ 已收藏' : '♡ 收藏';
+                    send('favorite', favorite ? 'PUT' : 'DELETE');
+                };
+            })();
+            </script>`, stars.String(), poem.ID)
 
 	return fmt.Sprintf(`<!DOCTYPE html>
 <html lang="zh-CN">
@@ -1006,7 +1499,7 @@ func generatePoemDisplayHTML(poemData map[string]interface{}) string {
 
         body {
             font-family: 'Microsoft YaHei', 'PingFang SC', 'Hiragino Sans GB', 'WenQuanYi Micro Hei', sans-serif;
-            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            background: linear-gradient(135deg, #667eea 0%%, #764ba2 100%%);
             min-height: 100vh;
             display: flex;
             justify-content: center;
@@ -1022,7 +1515,7 @@ func generatePoemDisplayHTML(poemData map[string]interface{}) string {
             box-shadow: 0 20px 40px rgba(0, 0, 0, 0.1);
             padding: 40px;
             max-width: 800px;
-            width: 100%;
+            width: 100%%;
             position: relative;
             overflow: hidden;
         }
@@ -1058,8 +1551,8 @@ func generatePoemDisplayHTML(poemData map[string]interface{}) string {
             content: '';
             position: absolute;
             bottom: -5px;
-            left: 50%;
-            transform: translateX(-50%);
+            left: 50%%;
+            transform: translateX(-50%%);
             width: 60px;
             height: 2px;
             background: linear-gradient(90deg, #667eea, #764ba2);
@@ -1105,6 +1598,61 @@ func generatePoemDisplayHTML(poemData map[string]interface{}) string {
             margin: 15px 0;
         }
 
+        .prosody {
+            text-align: center;
+            color: #7f8c8d;
+            font-size: 13px;
+            line-height: 1.8;
+            margin-bottom: 10px;
+        }
+
+        .tags {
+            text-align: center;
+            font-size: 14px;
+            margin-bottom: 10px;
+        }
+
+        .tags a {
+            color: #667eea;
+            text-decoration: none;
+            margin: 0 4px;
+        }
+
+        .feedback {
+            text-align: center;
+            color: #7f8c8d;
+            font-size: 14px;
+        }
+
+        .feedback button {
+            background: none;
+            border: none;
+            cursor: pointer;
+            font-size: 20px;
+            color: #ccc;
+        }
+
+        .feedback .star.on {
+            color: #f5a623;
+        }
+
+        .feedback .share {
+            color: #667eea;
+            margin-left: 8px;
+            text-decoration: none;
+        }
+
+        .feedback .favorite {
+            font-size: 14px;
+            color: #f5576c;
+            margin-left: 10px;
+        }
+
+        .feedback-status {
+            font-size: 12px;
+            min-height: 18px;
+        }
+
         .preference-badge {
             display: inline-block;
             background: linear-gradient(135deg, #f093fb, #f5576c);
@@ -1195,7 +1743,7 @@ func generatePoemDisplayHTML(poemData map[string]interface{}) string {
             }
 
             .btn {
-                width: 100%;
+                width: 100%%;
                 max-width: 200px;
             }
         }
@@ -1217,6 +1765,12 @@ func generatePoemDisplayHTML(poemData map[string]interface{}) string {
 
             <div class="poem-content">%s</div>
 
+            %s
+
+            %s
+
+            %s
+
             <div style="text-align: center;">
                 <span class="preference-badge">根据喜好 "%s" 生成</span>
             </div>
@@ -1228,7 +1782,7 @@ func generatePoemDisplayHTML(poemData map[string]interface{}) string {
         </div>
     </div>
 </body>
-</html>`, title, title, author, dynastyText, content, userPreference)
+</html>`, title, title, author, dynastyText, content.String(), tags, prosody, feedback, userPreference)
 }
 
 // parseToolCalls parses tool calls from Spark API response
@@ -1275,11 +1829,11 @@ func parseUsage(response map[string]interface{}) Usage {
 }
 
 // generateFallbackPoemPage generates a fallback HTML page with a random poem from database
-func generateFallbackPoemPage(cfg *config.Config) string {
+func generateFallbackPoemPage(cfg *config.Config, info *tools.RequestInfo) string {
 	// Try to query a random poem from database
-	query := "SELECT title, author, dynasty, content FROM poems ORDER BY RAND() LIMIT 1"
+	query := "SELECT title, author, dynasty, content FROM poems ORDER BY " + tools.CurrentDialect().RandomFunc() + " LIMIT 1"
 
-	result := tools.ExecuteDatabaseQuery(query, []interface{}{}, "select")
+	result := tools.ExecuteDatabaseQuery(info, query, []interface{}{}, "select")
 
 	// If we got results, format them
 	if result.Success && len(result.Rows) > 0 {
@@ -1361,7 +1915,7 @@ func generateFallbackPoemPage(cfg *config.Config) string {
 </html>`
 }
 
-func callQwen(cfg *config.Config, prompt string, toolsList []Tool) (*LLMResponse, error) {
+func callQwen(cfg *config.Config, info *tools.RequestInfo, prompt string, toolsList []Tool) (*LLMResponse, error) {
 	// Rate limiting: ensure minimum interval between API calls
 	apiCallMutex.Lock()
 	timeSinceLastCall := time.Since(lastAPICallTime)
@@ -1484,14 +2038,14 @@ func callQwen(cfg *config.Config, prompt string, toolsList []Tool) (*LLMResponse
 		choice := &llmResp.Choices[i]
 		if choice.FinishReason == "tool_calls" {
 			// Process tool calls and make another request
-			return processToolCallsQwen(cfg, prompt, toolsList, llmResp)
+			return processToolCallsQwen(cfg, info, prompt, toolsList, llmResp)
 		}
 	}
 
 	return llmResp, nil
 }
 
-func processToolCallsQwen(cfg *config.Config, initialPrompt string, toolsList []Tool, initialResp *LLMResponse) (*LLMResponse, error) {
+func processToolCallsQwen(cfg *config.Config, info *tools.RequestInfo, initialPrompt string, toolsList []Tool, initialResp *LLMResponse) (*LLMResponse, error) {
 	// 直接使用 HTTP 请求调用千问（兼容 OpenAI 格式），避免 SDK 序列化问题
 	url := "https://dashscope.aliyuncs.com/compatible-mode/v1/chat/completions"
 
@@ -1618,14 +2172,14 @@ func processToolCallsQwen(cfg *config.Config, initialPrompt string, toolsList []
 	// Check if more tool calls are needed
 	for _, choice := range llmResp.Choices {
 		if choice.FinishReason == "tool_calls" {
-			return processToolCallsQwen(cfg, initialPrompt, toolsList, llmResp)
+			return processToolCallsQwen(cfg, info, initialPrompt, toolsList, llmResp)
 		}
 	}
 
 	return llmResp, nil
 }
 
-func callOpenAI(cfg *config.Config, prompt string, toolsList []Tool) (*LLMResponse, error) {
+func callOpenAI(cfg *config.Config, info *tools.RequestInfo, prompt string, toolsList []Tool) (*LLMResponse, error) {
 	// Rate limiting: ensure minimum interval between API calls
 	apiCallMutex.Lock()
 	timeSinceLastCall := time.Since(lastAPICallTime)
@@ -1700,14 +2254,14 @@ func callOpenAI(cfg *config.Config, prompt string, toolsList []Tool) (*LLMRespon
 		choice := &llmResp.Choices[i]
 		if choice.FinishReason == "tool_calls" {
 			// Process tool calls and make another request
-			return processToolCallsOpenAI(cfg, prompt, toolsList, &llmResp)
+			return processToolCallsOpenAI(cfg, info, prompt, toolsList, &llmResp)
 		}
 	}
 
 	return &llmResp, nil
 }
 
-func processToolCallsOpenAI(cfg *config.Config, initialPrompt string, toolsList []Tool, initialResp *LLMResponse) (*LLMResponse, error) {
+func processToolCallsOpenAI(cfg *config.Config, info *tools.RequestInfo, initialPrompt string, toolsList []Tool, initialResp *LLMResponse) (*LLMResponse, error) {
 	messages := []Message{
 		{
 			Role:    "user",
@@ -1785,14 +2339,14 @@ func processToolCallsOpenAI(cfg *config.Config, initialPrompt string, toolsList
 	// Check if more tool calls are needed
 	for _, choice := range llmResp.Choices {
 		if choice.FinishReason == "tool_calls" {
-			return processToolCallsOpenAI(cfg, initialPrompt, toolsList, &llmResp)
+			return processToolCallsOpenAI(cfg, info, initialPrompt, toolsList, &llmResp)
 		}
 	}
 
 	return &llmResp, nil
 }
 
-func callAnthropic(cfg *config.Config, prompt string, toolsList []Tool) (*LLMResponse, error) {
+func callAnthropic(cfg *config.Config, info *tools.RequestInfo, prompt string, toolsList []Tool) (*LLMResponse, error) {
 	// Rate limiting: ensure minimum interval between API calls
 	apiCallMutex.Lock()
 	timeSinceLastCall := time.Since(lastAPICallTime)
@@ -1891,7 +2445,7 @@ func callAnthropic(cfg *config.Config, prompt string, toolsList []Tool) (*LLMRes
 
 		if hasToolUse {
 			// Process tool calls
-			return processToolCallsAnthropic(cfg, prompt, toolsList, anthropicResp)
+			return processToolCallsAnthropic(cfg, info, prompt, toolsList, anthropicResp)
 		}
 
 		// Regular text response
@@ -1917,7 +2471,7 @@ func callAnthropic(cfg *config.Config, prompt string, toolsList []Tool) (*LLMRes
 	return llmResp, nil
 }
 
-func processToolCallsAnthropic(cfg *config.Config, initialPrompt string, toolsList []Tool, initialResp map[string]interface{}) (*LLMResponse, error) {
+func processToolCallsAnthropic(cfg *config.Config, info *tools.RequestInfo, initialPrompt string, toolsList []Tool, initialResp map[string]interface{}) (*LLMResponse, error) {
 	messages := []map[string]interface{}{
 		{
 			"role":    "user",
@@ -1942,7 +2496,7 @@ func processToolCallsAnthropic(cfg *config.Config, initialPrompt string, toolsLi
 			if itemMap, ok := item.(map[string]interface{}); ok {
 				if itemType, ok := itemMap["type"].(string); ok && itemType == "tool_use" {
 					toolID, _ := itemMap["id"].(string)
-					result := executeToolCall(itemMap)
+					result := executeToolCall(info, itemMap)
 
 					// Check if this is a webResponse
 					if wr, ok := result.(*tools.WebResponse); ok {
@@ -2047,7 +2601,7 @@ func processToolCallsAnthropic(cfg *config.Config, initialPrompt string, toolsLi
 			if itemMap, ok := item.(map[string]interface{}); ok {
 				if itemType, ok := itemMap["type"].(string); ok && itemType == "tool_use" {
 					// Recursive call for more tool uses
-					return processToolCallsAnthropic(cfg, initialPrompt, toolsList, anthropicResp)
+					return processToolCallsAnthropic(cfg, info, initialPrompt, toolsList, anthropicResp)
 				}
 			}
 		}
@@ -2119,7 +2673,7 @@ func getBaiduAccessToken(apiKey, secretKey string) (string, error) {
 	return "", fmt.Errorf("access_token not found in response")
 }
 
-func callBaidu(cfg *config.Config, prompt string, toolsList []Tool) (*LLMResponse, error) {
+func callBaidu(cfg *config.Config, info *tools.RequestInfo, prompt string, toolsList []Tool) (*LLMResponse, error) {
 	// Rate limiting: ensure minimum interval between API calls
 	apiCallMutex.Lock()
 	timeSinceLastCall := time.Since(lastAPICallTime)
@@ -2268,20 +2822,20 @@ func callBaidu(cfg *config.Config, prompt string, toolsList []Tool) (*LLMRespons
 		choice := &llmResp.Choices[i]
 		if choice.FinishReason == "function_call" {
 			// Process function calls and make another request
-			return processToolCallsBaidu(cfg, prompt, toolsList, llmResp)
+			return processToolCallsBaidu(cfg, info, prompt, toolsList, llmResp)
 		}
 	}
 
 	return llmResp, nil
 }
 
-func processToolCallsBaidu(cfg *config.Config, initialPrompt string, toolsList []Tool, initialResp *LLMResponse) (*LLMResponse, error) {
+func processToolCallsBaidu(cfg *config.Config, info *tools.RequestInfo, initialPrompt string, toolsList []Tool, initialResp *LLMResponse) (*LLMResponse, error) {
 	// 简化实现：目前百度文心一言的函数调用支持可能有限
 	// 这里返回原始响应，实际实现需要根据百度API文档调整
 	return initialResp, nil
 }
 
-func processToolCallsSpark(cfg *config.Config, initialPrompt string, toolsList []Tool, initialResp *LLMResponse) (*LLMResponse, error) {
+func processToolCallsSpark(cfg *config.Config, info *tools.RequestInfo, initialPrompt string, toolsList []Tool, initialResp *LLMResponse) (*LLMResponse, error) {
 	// For Spark, tool calls are handled directly in callSpark function
 	// This function is kept for compatibility with the recursive processing framework
 	return initialResp, nil
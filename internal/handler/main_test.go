@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+)
+
+// testAdminToken is the admin token of testConfig.
+const testAdminToken = "secret"
+
+// testConfig serves the handler tests from a SQLite file in a temporary
+// directory. It names no LLM provider, so generations fail at once instead
+// of calling out.
+var testConfig *config.Config
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "nokode-handler")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	code, err := runTests(m, dir)
+	os.RemoveAll(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(code)
+}
+
+func runTests(m *testing.M, dir string) (int, error) {
+	cfg := &config.Config{}
+	cfg.Database.Driver = "sqlite"
+	cfg.Database.Path = filepath.Join(dir, "nokode.db")
+	cfg.Audit.Sink = "off"
+	cfg.Admin.Token = testAdminToken
+	cfg.Jobs.Concurrency = 2
+	for _, init := range []func(*config.Config) error{tools.InitDatabase, tools.InitAudit, tools.InitJobs, tools.InitPoetStyles} {
+		if err := init(cfg); err != nil {
+			return 0, err
+		}
+	}
+	testConfig = cfg
+	return m.Run(), nil
+}
+
+// serve runs handler on a request with the given path variables and, when
+// token is set, the admin token.
+func serve(handler http.HandlerFunc, method, target, body, token string, vars map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if vars != nil {
+		r = pathvar.WithVars(r, vars)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
@@ -0,0 +1,411 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+	"github.com/nokode/nokode/internal/utils"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+)
+
+// apiRequestInfo builds the RequestInfo for a JSON API request, writing a
+// 401 or 403 and returning nil if tenancy is enabled and no tenant matches.
+func apiRequestInfo(cfg *config.Config, w http.ResponseWriter, r *http.Request) *tools.RequestInfo {
+	info, status := newRequestInfo(cfg, r, uuid.New().String()[:9])
+	if tools.TenancyEnabled() && info.Tenant == "" {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Basic realm="nokode"`)
+		}
+		writeJSONError(w, status, "request could not be mapped to a tenant")
+		return nil
+	}
+	return info
+}
+
+// HandleSearchPoems serves GET /api/poems/search?q=&limit= with ranked
+// full-text matches and highlighted snippets.
+func HandleSearchPoems(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			writeJSONError(w, http.StatusBadRequest, "q is required")
+			return
+		}
+		limit := 0
+		if l := r.URL.Query().Get("limit"); l != "" {
+			n, err := strconv.Atoi(l)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "limit must be a number")
+				return
+			}
+			limit = n
+		}
+
+		results, err := tools.SearchPoems(info, q, limit)
+		if err != nil {
+			utils.Log.Error("search", "Poem search failed", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"query":   q,
+			"results": results,
+			"count":   len(results),
+		})
+	}
+}
+
+// pathID parses the :id path parameter, writing a 400 if it is invalid.
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	return pathInt(w, r, "id")
+}
+
+// pathInt parses the named path parameter as an id, writing a 400 if it is
+// invalid.
+func pathInt(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(pathvar.Vars(r)[name], 10, 64)
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, name+" must be a positive integer")
+		return 0, false
+	}
+	return id, true
+}
+
+// HandleListPoems serves GET /api/poems, newest first. Supported query
+// parameters: page, page_size, dynasty, form, author, user_preference and
+// tag.
+func HandleListPoems(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+
+		q := r.URL.Query()
+		filter := tools.PoemFilter{
+			Dynasty:        q.Get("dynasty"),
+			Form:           strings.TrimSpace(q.Get("form")),
+			Author:         q.Get("author"),
+			UserPreference: q.Get("user_preference"),
+			Tag:            strings.TrimSpace(q.Get("tag")),
+		}
+		if form, ok := tools.LookupPoemForm(filter.Form); ok {
+			filter.Form = form.Name
+		}
+		for name, target := range map[string]*int{"page": &filter.Page, "page_size": &filter.PageSize} {
+			if value := q.Get(name); value != "" {
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					writeJSONError(w, http.StatusBadRequest, name+" must be a number")
+					return
+				}
+				*target = n
+			}
+		}
+
+		page, err := tools.ListPoems(info, filter)
+		if err != nil {
+			utils.Log.Error("api", "Failed to list poems", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// HandleGetPoem serves GET /api/poems/:id.
+func HandleGetPoem(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		poem, err := tools.GetPoem(info, id)
+		if err != nil {
+			utils.Log.Error("api", "Failed to get poem", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if poem == nil {
+			writeJSONError(w, http.StatusNotFound, "poem not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, poem)
+	}
+}
+
+// HandleCreatePoem serves POST /api/poems with a JSON poem body and
+// responds with the stored poem. It requires the admin token.
+func HandleCreatePoem(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+
+		var poem tools.Poem
+		if err := json.NewDecoder(r.Body).Decode(&poem); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		if err := tools.ValidatePoem(&poem); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		poem.Source = tools.PoemSourceAdmin
+		created, err := tools.CreatePoem(info, poem)
+		if err != nil {
+			utils.Log.Error("api", "Failed to create poem", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, created)
+	}
+}
+
+// HandleDeletePoem serves DELETE /api/poems/:id. Deleting requires the
+// admin token.
+func HandleDeletePoem(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		deleted, err := tools.DeletePoem(info, id)
+		if err != nil {
+			utils.Log.Error("api", "Failed to delete poem", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !deleted {
+			writeJSONError(w, http.StatusNotFound, "poem not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleRatePoem serves PUT /api/poems/:id/rating with a {"rating": 1-5}
+// body. Each client IP has one rating per poem, which a later request
+// replaces. Responds with the poem's rating summary.
+func HandleRatePoem(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Rating int `json:"rating"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		if body.Rating < 1 || body.Rating > 5 {
+			writeJSONError(w, http.StatusBadRequest, "rating must be between 1 and 5")
+			return
+		}
+
+		summary, err := tools.RatePoem(info, id, info.ClientIP, body.Rating)
+		writeFeedback(w, summary, err)
+	}
+}
+
+// HandleFavoritePoem serves PUT and DELETE /api/poems/:id/favorite, which
+// add the poem to and remove it from the client's favorites. Responds with
+// the poem's rating summary.
+func HandleFavoritePoem(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		summary, err := tools.FavoritePoem(info, id, info.ClientIP, r.Method != http.MethodDelete)
+		writeFeedback(w, summary, err)
+	}
+}
+
+// HandleTagPoem serves PUT /api/poems/:id/tags with a {"tags": [...]}
+// body, replacing the poem's tags. Responds with the poem's tags. Tagging
+// requires the admin token.
+func HandleTagPoem(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+
+		tags, err := tools.TagPoem(info, id, body.Tags, true)
+		var invalid *tools.PoemValidationError
+		if errors.As(err, &invalid) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			utils.Log.Error("tags", "Failed to tag poem", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if tags == nil {
+			writeJSONError(w, http.StatusNotFound, "poem not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "tags": tags})
+	}
+}
+
+// HandleListTags serves GET /api/tags with every tag in use and how many
+// poems carry it, most used first.
+func HandleListTags(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+		tags, err := tools.ListTags(info)
+		if err != nil {
+			utils.Log.Error("tags", "Failed to list tags", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"tags": tags, "count": len(tags)})
+	}
+}
+
+// HandleListForms serves GET /api/forms with the supported forms and their
+// templates, shi first.
+func HandleListForms(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		forms := tools.PoemForms()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"forms": forms, "count": len(forms)})
+	}
+}
+
+func writeFeedback(w http.ResponseWriter, summary *tools.RatingSummary, err error) {
+	if err != nil {
+		utils.Log.Error("ratings", "Failed to store poem feedback", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if summary == nil {
+		writeJSONError(w, http.StatusNotFound, "poem not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// HandleExportPoems serves GET /api/poems/export as a file download.
+// Supported query parameters: format (markdown, jsonl, csv or epub; default
+// markdown), author, and since and until as RFC3339 timestamps or
+// YYYY-MM-DD dates.
+func HandleExportPoems(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := apiRequestInfo(cfg, w, r)
+		if info == nil {
+			return
+		}
+
+		q := r.URL.Query()
+		name := q.Get("format")
+		if name == "" {
+			name = "markdown"
+		}
+		format, ok := tools.ExportFormats[name]
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, "format must be one of "+strings.Join(tools.ExportFormatNames(), ", "))
+			return
+		}
+		filter := tools.ExportFilter{Author: q.Get("author")}
+		for param, bound := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if value := q.Get(param); value != "" {
+				t, err := tools.ParseExportTime(value, param == "until")
+				if err != nil {
+					writeJSONError(w, http.StatusBadRequest, param+": "+err.Error())
+					return
+				}
+				*bound = t
+			}
+		}
+
+		poems, err := tools.ExportPoems(info, filter)
+		if err != nil {
+			utils.Log.Error("export", "Failed to export poems", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(poems) == 0 {
+			writeJSONError(w, http.StatusNotFound, tools.ErrNoPoems.Error())
+			return
+		}
+
+		// Render into memory first so a failure can still become an error response
+		var body bytes.Buffer
+		if err := format.Write(&body, filter.Title(), poems); err != nil {
+			utils.Log.Error("export", "Failed to write export", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", format.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="poems-%s.%s"`, time.Now().Format("20060102"), format.Extension))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body.Bytes())
+		utils.Log.Success("export", fmt.Sprintf("Exported %d poems as %s", len(poems), name), nil)
+	}
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/tools"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+// HandleListPoetStyles serves GET /admin/poet-styles.
+func HandleListPoetStyles(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		styles, err := tools.ListPoetStyles()
+		if err != nil {
+			utils.Log.Error("styles", "Failed to list poet styles", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"styles": styles,
+			"count":  len(styles),
+		})
+	}
+}
+
+// HandleCreatePoetStyle serves POST /admin/poet-styles with a JSON style
+// body and responds with the stored style.
+func HandleCreatePoetStyle(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		style, ok := decodePoetStyle(w, r)
+		if !ok {
+			return
+		}
+
+		created, err := tools.CreatePoetStyle(style)
+		if err == tools.ErrPoetStyleExists {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			utils.Log.Error("styles", "Failed to create poet style", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		utils.Log.Success("styles", fmt.Sprintf("Added poet style %s", created.Name), nil)
+		writeJSON(w, http.StatusCreated, created)
+	}
+}
+
+// HandleUpdatePoetStyle serves PUT /admin/poet-styles/:id, replacing the
+// whole style.
+func HandleUpdatePoetStyle(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		style, ok := decodePoetStyle(w, r)
+		if !ok {
+			return
+		}
+
+		updated, err := tools.UpdatePoetStyle(id, style)
+		if err == tools.ErrPoetStyleExists {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			utils.Log.Error("styles", "Failed to update poet style", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if updated == nil {
+			writeJSONError(w, http.StatusNotFound, "poet style not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, updated)
+	}
+}
+
+// HandleDeletePoetStyle serves DELETE /admin/poet-styles/:id. Poems
+// already written in the style are kept.
+func HandleDeletePoetStyle(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		deleted, err := tools.DeletePoetStyle(id)
+		if err != nil {
+			utils.Log.Error("styles", "Failed to delete poet style", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !deleted {
+			writeJSONError(w, http.StatusNotFound, "poet style not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// decodePoetStyle reads and validates a JSON style body, writing a 400 if
+// it is invalid.
+func decodePoetStyle(w http.ResponseWriter, r *http.Request) (tools.PoetStyle, bool) {
+	var style tools.PoetStyle
+	if err := json.NewDecoder(r.Body).Decode(&style); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return style, false
+	}
+	if err := tools.ValidatePoetStyle(&style); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return style, false
+	}
+	return style, true
+}
+
+// poetChoicesHTML renders the poet cards of the /generate form: the random
+// choice first, then one card per style.
+func poetChoicesHTML(styles []tools.PoetStyle) string {
+	var b strings.Builder
+	b.WriteString(`<input type="radio" name="poet_preference" value="random" class="poet-radio" id="random" checked>
+                <label for="random" class="poet-card">
+                    <div class="poet-name">随机诗人</div>
+                    <div class="poet-era">惊喜选择</div>
+                    <div class="poet-desc">让AI随机选择一位古典诗人，为您创作诗歌</div>
+                </label>
+`)
+	eras := map[string]string{"tang": "唐代", "song": "宋代"}
+	for _, style := range styles {
+		desc := style.Description
+		if i := strings.Index(desc, "。"); i >= 0 {
+			desc = desc[:i]
+		}
+		fmt.Fprintf(&b, `
+                <input type="radio" name="poet_preference" value="%s" class="poet-radio" id="poet-%d">
+                <label for="poet-%d" class="poet-card">
+                    <div class="poet-name">%s</div>
+                    <div class="poet-era">%s</div>
+                    <div class="poet-desc">%s</div>
+                </label>
+`, html.EscapeString(style.Name), style.ID, style.ID, html.EscapeString(style.Name), eras[style.Dynasty], html.EscapeString(desc))
+	}
+	return b.String()
+}
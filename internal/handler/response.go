@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// writeJSON sends v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError sends a {"error": message} JSON response.
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"error": message})
+}
+
+// writeCached sends a rendered body with a hash of it as the ETag and lets
+// http.ServeContent answer conditional requests against it and modified.
+func writeCached(w http.ResponseWriter, r *http.Request, contentType string, modified time.Time, body []byte) {
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body))
+}
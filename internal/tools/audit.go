@@ -0,0 +1,272 @@
+package tools
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+const auditTable = "nokode_audit_log"
+
+var (
+	auditSink  = "table"
+	auditFile  = "logs/audit.jsonl"
+	auditMutex sync.Mutex
+)
+
+// AuditEntry is one statement issued through the database tool.
+type AuditEntry struct {
+	ID           int64     `json:"id,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	RequestID    string    `json:"requestId"`
+	Method       string    `json:"method"`
+	Route        string    `json:"route"`
+	ClientIP     string    `json:"clientIp"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	Mode         string    `json:"mode"`
+	Statement    string    `json:"statement"`
+	Params       string    `json:"params"`
+	RowsAffected int64     `json:"rowsAffected"`
+	RowCount     int       `json:"rowCount"`
+	DurationMs   int64     `json:"durationMs"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// AuditFilter narrows down QueryAuditLog results. Zero values match all.
+type AuditFilter struct {
+	RequestID string
+	Route     string
+	ClientIP  string
+	Contains  string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// InitAudit prepares the configured audit sink: the nokode_audit_log table
+// or an append-only JSON Lines file.
+func InitAudit(cfg *config.Config) error {
+	if cfg.Audit.Sink != "" {
+		auditSink = cfg.Audit.Sink
+	}
+	if cfg.Audit.File != "" {
+		auditFile = cfg.Audit.File
+	}
+
+	switch auditSink {
+	case "table":
+		_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id %s,
+			created_at TIMESTAMP NOT NULL,
+			request_id VARCHAR(64),
+			method VARCHAR(16),
+			route VARCHAR(255),
+			client_ip VARCHAR(64),
+			provider VARCHAR(32),
+			model VARCHAR(128),
+			mode VARCHAR(16),
+			statement TEXT NOT NULL,
+			params TEXT,
+			rows_affected BIGINT,
+			row_count INT,
+			duration_ms BIGINT,
+			error TEXT
+		)`, auditTable, dialect.AutoIncrementKey()))
+		if err != nil {
+			utils.Log.Error("audit", "Failed to create audit table", err)
+			return err
+		}
+	case "file":
+		if err := os.MkdirAll(filepath.Dir(auditFile), 0755); err != nil {
+			utils.Log.Error("audit", "Failed to create audit log directory", err)
+			return err
+		}
+	case "off":
+	default:
+		return fmt.Errorf("unsupported audit sink: %s", auditSink)
+	}
+
+	utils.Log.Success("audit", fmt.Sprintf("SQL audit log enabled (%s)", auditSink), nil)
+	return nil
+}
+
+// recordAudit appends a statement to the audit trail. Failures are logged
+// but never fail the statement itself.
+func recordAudit(info *RequestInfo, query string, params []interface{}, mode string, result DatabaseResult) {
+	if info == nil || auditSink == "off" {
+		return
+	}
+
+	if params == nil {
+		params = []interface{}{}
+	}
+	paramsJSON, _ := json.Marshal(params)
+	entry := AuditEntry{
+		CreatedAt:    time.Now().UTC(),
+		RequestID:    info.RequestID,
+		Method:       info.Method,
+		Route:        info.Route,
+		ClientIP:     info.ClientIP,
+		Provider:     info.Provider,
+		Model:        info.Model,
+		Mode:         mode,
+		Statement:    query,
+		Params:       string(paramsJSON),
+		RowsAffected: result.Changes,
+		RowCount:     result.Count,
+		DurationMs:   result.Duration,
+		Error:        result.Error,
+	}
+
+	var err error
+	if auditSink == "file" {
+		err = appendAuditFile(entry)
+	} else {
+		_, err = db.Exec(dialect.Rebind(`INSERT INTO `+auditTable+` (created_at, request_id, method, route, client_ip, provider, model, mode, statement, params, rows_affected, row_count, duration_ms, error)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			entry.CreatedAt, entry.RequestID, entry.Method, entry.Route, entry.ClientIP, entry.Provider, entry.Model,
+			entry.Mode, entry.Statement, entry.Params, entry.RowsAffected, entry.RowCount, entry.DurationMs, entry.Error)
+	}
+	if err != nil {
+		utils.Log.Error("audit", "Failed to record audit entry", err)
+	}
+}
+
+func appendAuditFile(entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	f, err := os.OpenFile(auditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// QueryAuditLog returns the most recent audit entries matching the filter,
+// newest first.
+func QueryAuditLog(filter AuditFilter) ([]AuditEntry, error) {
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+
+	switch auditSink {
+	case "table":
+		return queryAuditTable(filter)
+	case "file":
+		return queryAuditFile(filter)
+	default:
+		return nil, fmt.Errorf("audit log is disabled")
+	}
+}
+
+func queryAuditTable(filter AuditFilter) ([]AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.RequestID != "" {
+		conditions = append(conditions, "request_id = ?")
+		args = append(args, filter.RequestID)
+	}
+	if filter.Route != "" {
+		conditions = append(conditions, "route = ?")
+		args = append(args, filter.Route)
+	}
+	if filter.ClientIP != "" {
+		conditions = append(conditions, "client_ip = ?")
+		args = append(args, filter.ClientIP)
+	}
+	if filter.Contains != "" {
+		conditions = append(conditions, "statement LIKE ?")
+		args = append(args, "%"+filter.Contains+"%")
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.Until.UTC())
+	}
+
+	query := `SELECT id, created_at, request_id, method, route, client_ip, provider, model, mode, statement, params, rows_affected, row_count, duration_ms, error FROM ` + auditTable
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", filter.Limit)
+
+	rows, err := db.Query(dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.RequestID, &e.Method, &e.Route, &e.ClientIP, &e.Provider, &e.Model,
+			&e.Mode, &e.Statement, &e.Params, &e.RowsAffected, &e.RowCount, &e.DurationMs, &e.Error); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func queryAuditFile(filter AuditFilter) ([]AuditEntry, error) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	f, err := os.Open(auditFile)
+	if os.IsNotExist(err) {
+		return []AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var matched []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if filter.RequestID != "" && e.RequestID != filter.RequestID ||
+			filter.Route != "" && e.Route != filter.Route ||
+			filter.ClientIP != "" && e.ClientIP != filter.ClientIP ||
+			filter.Contains != "" && !strings.Contains(e.Statement, filter.Contains) ||
+			!filter.Since.IsZero() && e.CreatedAt.Before(filter.Since) ||
+			!filter.Until.IsZero() && e.CreatedAt.After(filter.Until) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := []AuditEntry{}
+	for i := len(matched) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		entries = append(entries, matched[i])
+	}
+	return entries, nil
+}
@@ -0,0 +1,151 @@
+package tools
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nokode/nokode/internal/utils"
+)
+
+// BatchStatement is one statement of a databaseBatch call.
+type BatchStatement struct {
+	Query  string        `json:"query"`
+	Params []interface{} `json:"params,omitempty"`
+}
+
+// BatchResult holds the per-statement results of a batch. When a statement
+// fails the transaction is rolled back and the remaining statements are
+// skipped.
+type BatchResult struct {
+	Success    bool             `json:"success"`
+	RolledBack bool             `json:"rolledBack,omitempty"`
+	Results    []DatabaseResult `json:"results"`
+	Error      string           `json:"error,omitempty"`
+	Duration   int64            `json:"duration,omitempty"`
+}
+
+// ExecuteDatabaseBatch runs the statements in order in a single transaction
+// on the primary (or the request's tenant database). When info is set, as
+// for batches the model sends, SELECTs must first pass the query cost gate.
+func ExecuteDatabaseBatch(info *RequestInfo, statements []BatchStatement) BatchResult {
+	startTime := time.Now()
+	batch := BatchResult{Results: make([]DatabaseResult, len(statements))}
+
+	if len(statements) == 0 {
+		batch.Error = "batch contains no statements"
+		return batch
+	}
+
+	fail := func(index int, message string) BatchResult {
+		batch.Error = fmt.Sprintf("statement %d: %s", index+1, message)
+		for i := range batch.Results {
+			if i > index {
+				batch.Results[i] = DatabaseResult{Error: "skipped"}
+			}
+		}
+		batch.Duration = time.Since(startTime).Milliseconds()
+		return batch
+	}
+
+	destructive := ""
+	for i, statement := range statements {
+		if info != nil && referencesInternalTable(statement.Query) {
+			batch.Results[i] = DatabaseResult{Error: "access to nokode internal tables is not allowed"}
+			recordAudit(info, statement.Query, statement.Params, "batch", batch.Results[i])
+			return fail(i, batch.Results[i].Error)
+		}
+		if destructive == "" {
+			destructive = destructiveKind(statement.Query)
+		}
+		if info != nil && !isReadOnly(statement.Query) {
+			info.wrote = true
+		}
+	}
+
+	primary, release, err := tenantPool(info)
+	if err != nil {
+		batch.Error = "tenant database unavailable: " + err.Error()
+		return batch
+	}
+	defer release()
+
+	// EXPLAIN needs a connection of its own, so the cost gate runs before
+	// the transaction starts
+	if info != nil {
+		for i, statement := range statements {
+			if rejected := checkQueryCost(primary, statement.Query, statement.Params); rejected != nil {
+				batch.Results[i] = *rejected
+				recordAudit(info, statement.Query, statement.Params, "batch", *rejected)
+				return fail(i, rejected.Error)
+			}
+		}
+	}
+
+	if info != nil && destructive != "" {
+		if _, err := takeSnapshot(primary, info.Tenant, "pre-"+destructive); err != nil {
+			utils.Log.Error("snapshot", "Refusing destructive batch without a snapshot", err)
+			batch.Error = "snapshot before destructive statement failed: " + err.Error()
+			return batch
+		}
+	}
+
+	utils.Log.Database(fmt.Sprintf("Executing batch of %d statements", len(statements)), nil)
+
+	tx, err := primary.Begin()
+	if err != nil {
+		batch.Error = err.Error()
+		return batch
+	}
+
+	// Audit entries are written after the transaction ends: with SQLite the
+	// transaction holds the only connection.
+	executed := 0
+	failed := -1
+	for i, statement := range statements {
+		batch.Results[i] = executeQuery(tx, statement.Query, statement.Params, "query")
+		executed++
+		if !batch.Results[i].Success {
+			failed = i
+			break
+		}
+	}
+
+	if failed < 0 {
+		if err := tx.Commit(); err != nil {
+			failed = executed - 1
+			batch.Results[failed].Success = false
+			batch.Results[failed].Error = "commit failed: " + err.Error()
+		}
+	} else {
+		tx.Rollback()
+	}
+
+	for i := 0; i < executed; i++ {
+		recordAudit(info, statements[i].Query, statements[i].Params, "batch", batch.Results[i])
+	}
+
+	if failed >= 0 {
+		batch.RolledBack = true
+		utils.Log.Warn("database", fmt.Sprintf("Batch rolled back at statement %d", failed+1), batch.Results[failed].Error)
+		return fail(failed, batch.Results[failed].Error)
+	}
+
+	schemaChanged := false
+	for _, statement := range statements {
+		if info != nil && isDDL(statement.Query) {
+			captureMigration(info, statement.Query)
+			schemaChanged = true
+		}
+	}
+	if schemaChanged {
+		if info.Tenant == "" {
+			applyManagedMigrations()
+		}
+		reloadSchema(info)
+	}
+
+	batch.Success = true
+	batch.Duration = time.Since(startTime).Milliseconds()
+	utils.Log.Success("database", fmt.Sprintf("Batch of %d statements committed in %dms", len(statements), batch.Duration), nil)
+	return batch
+}
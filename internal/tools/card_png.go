@@ -0,0 +1,269 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// cardPNGScale renders PNG cards at twice the SVG size so text stays crisp
+// on high density screens.
+const cardPNGScale = 2.0
+
+// ErrNoCardFont is returned when no font with Chinese glyphs is available
+// to render PNG cards.
+var ErrNoCardFont = errors.New("no font with Chinese glyphs found; set CARD_FONT to a TrueType or OpenType font file")
+
+// cardFontPaths are common locations of fonts with Chinese glyphs, tried in
+// order when no font is configured.
+var cardFontPaths = []string{
+	"/usr/share/fonts/opentype/noto/NotoSerifCJK-Regular.ttc",
+	"/usr/share/fonts/noto-cjk/NotoSerifCJK-Regular.ttc",
+	"/usr/share/fonts/google-noto-serif-cjk-fonts/NotoSerifCJK-Regular.ttc",
+	"/usr/share/fonts/opentype/noto/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/noto-cjk/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/google-noto-cjk/NotoSansCJK-Regular.ttc",
+	"/usr/share/fonts/truetype/arphic/ukai.ttc",
+	"/usr/share/fonts/truetype/wqy/wqy-zenhei.ttc",
+	"/usr/share/fonts/truetype/wqy/wqy-microhei.ttc",
+	"/usr/share/fonts/wqy-zenhei/wqy-zenhei.ttc",
+	"/usr/share/fonts/wqy-microhei/wqy-microhei.ttc",
+	"/System/Library/Fonts/Supplemental/Songti.ttc",
+	"/System/Library/Fonts/STHeiti Medium.ttc",
+	`C:\Windows\Fonts\simkai.ttf`,
+	`C:\Windows\Fonts\simsun.ttc`,
+	`C:\Windows\Fonts\msyh.ttc`,
+}
+
+// cardFonts caches the parsed font for each configured path, "" standing
+// for the first of cardFontPaths found.
+var cardFonts sync.Map
+
+type cardFontResult struct {
+	once sync.Once
+	font *sfnt.Font
+	err  error
+}
+
+// loadCardFont returns the font PNG cards are drawn with: the font at path,
+// or the first known system font with Chinese glyphs when path is empty.
+func loadCardFont(path string) (*sfnt.Font, error) {
+	v, _ := cardFonts.LoadOrStore(path, &cardFontResult{})
+	result := v.(*cardFontResult)
+	result.once.Do(func() {
+		if path != "" {
+			result.font, result.err = parseCardFont(path)
+			return
+		}
+		result.err = ErrNoCardFont
+		for _, candidate := range cardFontPaths {
+			if _, err := os.Stat(candidate); err != nil {
+				continue
+			}
+			if f, err := parseCardFont(candidate); err == nil {
+				result.font, result.err = f, nil
+				return
+			}
+		}
+	})
+	return result.font, result.err
+}
+
+// parseCardFont parses a font file or collection, picking the first face
+// that has Chinese glyphs.
+func parseCardFont(path string) (*sfnt.Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := sfnt.ParseCollection(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var buf sfnt.Buffer
+	for i := 0; i < collection.NumFonts(); i++ {
+		f, err := collection.Font(i)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if idx, err := f.GlyphIndex(&buf, '诗'); err == nil && idx != 0 {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", path, ErrNoCardFont)
+}
+
+// WritePoemCardPNG writes the poem as a PNG card, the same as
+// WritePoemCardSVG but drawn with the font at fontPath or a system font.
+func WritePoemCardPNG(w io.Writer, poem Poem, fontPath string) error {
+	f, err := loadCardFont(fontPath)
+	if err != nil {
+		return err
+	}
+	card := layoutPoemCard(poem)
+	dst := image.NewRGBA(image.Rect(0, 0, int(card.Width*cardPNGScale), int(card.Height*cardPNGScale)))
+
+	for _, s := range card.Shapes {
+		fillRoundedRect(dst, s, cardPNGScale)
+	}
+	var buf sfnt.Buffer
+	for _, g := range card.Glyphs {
+		if err := drawGlyph(dst, f, &buf, g, cardPNGScale); err != nil {
+			return err
+		}
+	}
+	return png.Encode(w, dst)
+}
+
+// fillRoundedRect rasterizes s into dst, only over the shape's own bounds.
+func fillRoundedRect(dst *image.RGBA, s cardShape, scale float64) {
+	x0, y0 := math.Floor(s.X*scale), math.Floor(s.Y*scale)
+	x1, y1 := math.Ceil((s.X+s.W)*scale), math.Ceil((s.Y+s.H)*scale)
+	bounds := image.Rect(int(x0), int(y0), int(x1), int(y1)).Intersect(dst.Bounds())
+	if bounds.Empty() {
+		return
+	}
+
+	z := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	ox, oy := float64(bounds.Min.X), float64(bounds.Min.Y)
+	l, t := float32(s.X*scale-ox), float32(s.Y*scale-oy)
+	r, b := float32((s.X+s.W)*scale-ox), float32((s.Y+s.H)*scale-oy)
+	rad := float32(math.Min(s.R*scale, math.Min(s.W, s.H)*scale/2))
+	z.MoveTo(l+rad, t)
+	z.LineTo(r-rad, t)
+	z.QuadTo(r, t, r, t+rad)
+	z.LineTo(r, b-rad)
+	z.QuadTo(r, b, r-rad, b)
+	z.LineTo(l+rad, b)
+	z.QuadTo(l, b, l, b-rad)
+	z.LineTo(l, t+rad)
+	z.QuadTo(l, t, l+rad, t)
+	z.ClosePath()
+	z.Draw(dst, bounds, cardPaintImage(s, scale), bounds.Min)
+}
+
+// drawGlyph draws g, centered in its cell, and skips characters the font
+// has no glyph for.
+func drawGlyph(dst *image.RGBA, f *sfnt.Font, buf *sfnt.Buffer, g cardGlyph, scale float64) error {
+	idx, err := f.GlyphIndex(buf, g.Rune)
+	if err != nil || idx == 0 {
+		return err
+	}
+	ppem := fixed.Int26_6(g.Size * scale * 64)
+	advance, err := f.GlyphAdvance(buf, idx, ppem, font.HintingNone)
+	if err != nil {
+		return err
+	}
+	originX := g.X*scale - float64(advance)/128
+	originY := glyphBaseline(g) * scale
+
+	// The cell with room to spare for glyphs overhanging it
+	pad := g.Size * scale
+	bounds := image.Rect(int(originX-pad/2), int(originY-pad*1.5), int(originX+pad*1.5), int(originY+pad/2)).Intersect(dst.Bounds())
+	if bounds.Empty() {
+		return nil
+	}
+	segments, err := f.LoadGlyph(buf, idx, ppem, nil)
+	if err != nil {
+		return err
+	}
+
+	src := image.NewUniform(parseHexColor(g.Color, 1))
+	passes := []float64{0}
+	if g.Bold {
+		// sfnt has no bold variant to hand, so embolden by overprinting
+		passes = append(passes, g.Size*scale*0.03)
+	}
+	z := vector.NewRasterizer(bounds.Dx(), bounds.Dy())
+	for _, shift := range passes {
+		z.Reset(bounds.Dx(), bounds.Dy())
+		dx := float32(originX + shift - float64(bounds.Min.X))
+		dy := float32(originY - float64(bounds.Min.Y))
+		pt := func(p fixed.Point26_6) (float32, float32) {
+			return dx + float32(p.X)/64, dy + float32(p.Y)/64
+		}
+		for _, seg := range segments {
+			switch seg.Op {
+			case sfnt.SegmentOpMoveTo:
+				z.MoveTo(pt(seg.Args[0]))
+			case sfnt.SegmentOpLineTo:
+				z.LineTo(pt(seg.Args[0]))
+			case sfnt.SegmentOpQuadTo:
+				x1, y1 := pt(seg.Args[0])
+				x2, y2 := pt(seg.Args[1])
+				z.QuadTo(x1, y1, x2, y2)
+			case sfnt.SegmentOpCubeTo:
+				x1, y1 := pt(seg.Args[0])
+				x2, y2 := pt(seg.Args[1])
+				x3, y3 := pt(seg.Args[2])
+				z.CubeTo(x1, y1, x2, y2, x3, y3)
+			}
+		}
+		z.ClosePath()
+		z.Draw(dst, bounds, src, image.Point{})
+	}
+	return nil
+}
+
+// cardPaintImage is the source image filling shape s.
+func cardPaintImage(s cardShape, scale float64) image.Image {
+	opacity := s.Paint.Opacity
+	if opacity == 0 {
+		opacity = 1
+	}
+	if len(s.Paint.Gradient) == 0 {
+		return image.NewUniform(parseHexColor(s.Paint.Color, opacity))
+	}
+	g := &linearGradient{x: s.X * scale, y: s.Y * scale, dx: s.Paint.DX * s.W * scale, dy: s.Paint.DY * s.H * scale}
+	for _, c := range s.Paint.Gradient {
+		g.stops = append(g.stops, parseHexColor(c, opacity))
+	}
+	return g
+}
+
+// linearGradient is an image of evenly spaced color stops from (x, y) to
+// (x+dx, y+dy), padded with the end colors beyond.
+type linearGradient struct {
+	x, y, dx, dy float64
+	stops        []color.RGBA
+}
+
+func (g *linearGradient) ColorModel() color.Model { return color.RGBAModel }
+
+func (g *linearGradient) Bounds() image.Rectangle {
+	return image.Rect(-1e9, -1e9, 1e9, 1e9)
+}
+
+func (g *linearGradient) At(x, y int) color.Color {
+	// Project the pixel center onto the gradient vector
+	t := ((float64(x)+0.5-g.x)*g.dx + (float64(y)+0.5-g.y)*g.dy) / (g.dx*g.dx + g.dy*g.dy)
+	t = math.Max(0, math.Min(1, t)) * float64(len(g.stops)-1)
+	i := int(t)
+	if i >= len(g.stops)-1 {
+		return g.stops[len(g.stops)-1]
+	}
+	a, b, f := g.stops[i], g.stops[i+1], t-float64(i)
+	mix := func(p, q uint8) uint8 { return uint8(float64(p) + (float64(q)-float64(p))*f + 0.5) }
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+}
+
+// parseHexColor parses #rrggbb into a premultiplied color with the given
+// opacity.
+func parseHexColor(hex string, opacity float64) color.RGBA {
+	v, _ := strconv.ParseUint(hex[1:], 16, 32)
+	alpha := opacity * 255
+	premultiply := func(c uint64) uint8 { return uint8(float64(c&0xff)*opacity + 0.5) }
+	return color.RGBA{premultiply(v >> 16), premultiply(v >> 8), premultiply(v), uint8(alpha + 0.5)}
+}
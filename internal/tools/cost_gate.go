@@ -0,0 +1,95 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nokode/nokode/internal/utils"
+)
+
+// maxEstimatedRows rejects SELECTs whose EXPLAIN estimate exceeds it.
+// Zero disables the check.
+var maxEstimatedRows int64
+
+var limitPattern = regexp.MustCompile(`(?i)\bLIMIT\s+(\d+)(?:\s*(?:,|OFFSET)\s*(\d+))?\s*;?\s*$`)
+
+const costGateHint = "Add a WHERE clause on an indexed column, create an index on the columns used for filtering and joins, or add a LIMIT."
+
+// checkQueryCost runs EXPLAIN for a SELECT and returns a rejection result
+// with the plan when the estimated row count is above maxEstimatedRows. It
+// returns nil when the query may run, including when EXPLAIN itself fails.
+func checkQueryCost(pool *sql.DB, query string, params []interface{}) *DatabaseResult {
+	if maxEstimatedRows <= 0 || !strings.HasPrefix(strings.TrimSpace(strings.ToUpper(query)), "SELECT") {
+		return nil
+	}
+
+	estimate, plan, err := dialect.Explain(pool, strings.TrimRight(strings.TrimSpace(query), ";"), params)
+	if err != nil {
+		utils.Log.Debug("database", "EXPLAIN failed, skipping cost check", err.Error())
+		return nil
+	}
+
+	// A trailing LIMIT caps what the query can return, which the MySQL and
+	// SQLite estimates do not account for.
+	if m := limitPattern.FindStringSubmatch(query); m != nil {
+		limit, _ := strconv.ParseInt(m[1], 10, 64)
+		if m[2] != "" {
+			// LIMIT offset, count puts the count second
+			n, _ := strconv.ParseInt(m[2], 10, 64)
+			if strings.Contains(m[0], ",") {
+				limit = n
+			}
+		}
+		if limit < estimate {
+			estimate = limit
+		}
+	}
+
+	if estimate <= maxEstimatedRows {
+		return nil
+	}
+
+	utils.Log.Warn("database", fmt.Sprintf("Rejected query estimated at %d rows", estimate), map[string]interface{}{
+		"limit": maxEstimatedRows,
+	})
+	return &DatabaseResult{
+		Error: fmt.Sprintf("query rejected: EXPLAIN estimates %d rows, above the limit of %d", estimate, maxEstimatedRows),
+		Plan:  plan,
+		Hint:  costGateHint,
+	}
+}
+
+// queryMaps runs a query and decodes every row into a column map.
+func queryMaps(pool *sql.DB, query string, params ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := pool.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columnTypes))
+		valuePtrs := make([]interface{}, len(columnTypes))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{})
+		for i, ct := range columnTypes {
+			row[ct.Name()] = decodeColumn(values[i], ct.DatabaseTypeName())
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
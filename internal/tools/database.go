@@ -4,29 +4,39 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/nokode/nokode/internal/config"
 	"github.com/nokode/nokode/internal/utils"
 )
 
 var db *sql.DB
+var replica *sql.DB
 var cachedSchema string
 
+// internalTables are maintained by nokode itself. They are hidden from the
+// schema shown to the model and model-issued SQL may not touch them.
+var internalTables = []string{auditTable, migrationsTable, prosodyTable, similarityTable, poetStylesTable, ratingsTable, jobsTable,
+	tagsTable, collectionsTable, collectionItemsTable, sourcesTable}
+
 func InitDatabase(cfg *config.Config) error {
-	// Build MySQL DSN
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-		cfg.Database.User,
-		cfg.Database.Password,
-		cfg.Database.Host,
-		cfg.Database.Port,
-		cfg.Database.Database,
-	)
-
-	var err error
-	db, err = sql.Open("mysql", dsn)
+	d, err := lookupDialect(cfg.Database.Driver)
+	if err != nil {
+		utils.Log.Error("database", "Unknown database driver", err)
+		return err
+	}
+	dialect = d
+
+	dsn, err := dialect.DSN(cfg)
+	if err != nil {
+		utils.Log.Error("database", "Failed to build database DSN", err)
+		return err
+	}
+
+	db, err = sql.Open(dialect.DriverName(), dsn)
 	if err != nil {
 		utils.Log.Error("database", "Failed to open database", err)
 		return err
@@ -38,55 +48,77 @@ func InitDatabase(cfg *config.Config) error {
 		return err
 	}
 
+	maxEstimatedRows = cfg.Database.MaxEstimatedRows
+
 	// Set connection pool settings
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
+	if err = configurePool(db, cfg); err != nil {
+		utils.Log.Error("database", "Invalid connection pool settings", err)
+		return err
+	}
+
+	if cfg.Database.ReplicaDSN != "" {
+		replica, err = sql.Open(dialect.DriverName(), cfg.Database.ReplicaDSN)
+		if err != nil {
+			utils.Log.Error("database", "Failed to open read replica", err)
+			return err
+		}
+		if err = replica.Ping(); err != nil {
+			utils.Log.Error("database", "Failed to ping read replica", err)
+			return err
+		}
+		if err = configurePool(replica, cfg); err != nil {
+			utils.Log.Error("database", "Invalid connection pool settings", err)
+			return err
+		}
+	}
 
 	// Load schema on startup
 	loadDatabaseSchema()
 
-	utils.Log.Success("database", "MySQL database connected successfully", map[string]interface{}{
+	details := map[string]interface{}{
 		"host":     cfg.Database.Host,
 		"port":     cfg.Database.Port,
 		"database": cfg.Database.Database,
-	})
+		"replica":  replica != nil,
+	}
+	if _, ok := dialect.(sqliteDialect); ok {
+		details = map[string]interface{}{
+			"path": cfg.Database.Path,
+		}
+	}
+	utils.Log.Success("database", fmt.Sprintf("%s database connected successfully", dialect.Name()), details)
 
 	return nil
 }
 
 func loadDatabaseSchema() {
+	cachedSchema = describeSchema(db)
+}
+
+// describeSchema renders the CREATE TABLE statements of the application
+// tables in pool for the prompt.
+func describeSchema(pool *sql.DB) string {
+	emptySchema := fmt.Sprintf("\n## DATABASE SCHEMA\n\nDialect: %s\n\nNo tables found. The AI can create tables as needed.\n\n", dialect.Hint())
+
 	// First, get list of tables
-	tableRows, err := db.Query("SHOW TABLES")
+	tables, err := applicationTables(pool)
 	if err != nil {
 		utils.Log.Error("database", "Failed to get table list", err)
-		cachedSchema = "\n## DATABASE SCHEMA\n\nNo tables found. The AI can create tables as needed.\n\n"
-		return
-	}
-	defer tableRows.Close()
-
-	var tables []string
-	for tableRows.Next() {
-		var tableName string
-		if err := tableRows.Scan(&tableName); err != nil {
-			continue
-		}
-		tables = append(tables, tableName)
+		return emptySchema
 	}
 
 	if len(tables) == 0 {
-		cachedSchema = "\n## DATABASE SCHEMA\n\nNo tables found. The AI can create tables as needed.\n\n"
 		utils.Log.Success("startup", "Database schema cached (no tables)", nil)
-		return
+		return emptySchema
 	}
 
 	// Get CREATE TABLE statement for each table
 	var schema strings.Builder
 	schema.WriteString("\n## DATABASE SCHEMA (Use these exact column names!)\n\n")
+	schema.WriteString("Dialect: " + dialect.Hint() + "\n\n")
 
 	for _, tableName := range tables {
-		var createStmt string
-		var unused string
-		err := db.QueryRow("SHOW CREATE TABLE "+tableName).Scan(&unused, &createStmt)
+		createStmt, err := dialect.CreateTable(pool, tableName)
 		if err != nil {
 			utils.Log.Debug("database", fmt.Sprintf("Failed to get CREATE TABLE for %s", tableName), err)
 			continue
@@ -95,8 +127,44 @@ func loadDatabaseSchema() {
 		schema.WriteString(";\n\n")
 	}
 
-	cachedSchema = schema.String()
 	utils.Log.Success("startup", fmt.Sprintf("Database schema cached for %d table(s)", len(tables)), nil)
+	return schema.String()
+}
+
+func isInternalTable(name string) bool {
+	for _, table := range internalTables {
+		if strings.EqualFold(name, table) {
+			return true
+		}
+	}
+	return false
+}
+
+func referencesInternalTable(query string) bool {
+	lower := strings.ToLower(query)
+	for _, table := range internalTables {
+		if strings.Contains(lower, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// applicationTables lists the tables owned by the app, hiding the
+// bookkeeping tables nokode maintains for itself.
+func applicationTables(pool *sql.DB) ([]string, error) {
+	tables, err := dialect.Tables(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, table := range tables {
+		if !isInternalTable(table) {
+			result = append(result, table)
+		}
+	}
+	return result, nil
 }
 
 func GetCachedSchema() string {
@@ -111,10 +179,105 @@ type DatabaseResult struct {
 	LastInsertRowID int64                    `json:"lastInsertId,omitempty"`
 	Message         string                   `json:"message,omitempty"`
 	Error           string                   `json:"error,omitempty"`
+	Plan            interface{}              `json:"plan,omitempty"`
+	Hint            string                   `json:"hint,omitempty"`
 	Duration        int64                    `json:"duration,omitempty"`
 }
 
-func ExecuteDatabaseQuery(query string, params []interface{}, mode string) DatabaseResult {
+// ExecuteDatabaseQuery runs a statement on behalf of a request and records
+// it in the audit log. info may be nil for statements nokode issues itself.
+func ExecuteDatabaseQuery(info *RequestInfo, query string, params []interface{}, mode string) DatabaseResult {
+	return executeDatabaseQuery(info, query, params, mode, false)
+}
+
+// ExecuteModelQuery runs a statement the model wrote with the database tool.
+// Unlike ExecuteDatabaseQuery, it may not touch nokode's internal tables, and
+// SELECTs must first pass the query cost gate.
+func ExecuteModelQuery(info *RequestInfo, query string, params []interface{}, mode string) DatabaseResult {
+	return executeDatabaseQuery(info, query, params, mode, true)
+}
+
+func executeDatabaseQuery(info *RequestInfo, query string, params []interface{}, mode string, fromModel bool) DatabaseResult {
+	if fromModel && referencesInternalTable(query) {
+		result := DatabaseResult{Error: "access to nokode internal tables is not allowed"}
+		recordAudit(info, query, params, mode, result)
+		return result
+	}
+
+	primary, release, err := tenantPool(info)
+	if err != nil {
+		utils.Log.Error("tenancy", "Failed to open tenant database", err)
+		result := DatabaseResult{Error: "tenant database unavailable: " + err.Error()}
+		recordAudit(info, query, params, mode, result)
+		return result
+	}
+	defer release()
+
+	if info != nil {
+		if kind := destructiveKind(query); kind != "" {
+			if _, err := takeSnapshot(primary, info.Tenant, "pre-"+kind); err != nil {
+				utils.Log.Error("snapshot", "Refusing destructive statement without a snapshot", err)
+				result := DatabaseResult{Error: "snapshot before destructive statement failed: " + err.Error()}
+				recordAudit(info, query, params, mode, result)
+				return result
+			}
+		}
+	}
+
+	pool := primary
+	if isReadOnly(query) {
+		// Replicas only serve the shared schema
+		if replica != nil && primary == db && (info == nil || !info.wrote) {
+			pool = replica
+			utils.Log.Debug("database", "Routing read to replica", nil)
+		}
+	} else if info != nil {
+		info.wrote = true
+	}
+
+	if fromModel {
+		if rejected := checkQueryCost(pool, query, params); rejected != nil {
+			recordAudit(info, query, params, mode, *rejected)
+			return *rejected
+		}
+	}
+
+	result := executeQuery(pool, query, params, mode)
+	recordAudit(info, query, params, mode, result)
+	if info != nil && result.Success && isDDL(query) {
+		captureMigration(info, query)
+		if info.Tenant == "" {
+			applyManagedMigrations()
+		}
+		reloadSchema(info)
+	}
+	return result
+}
+
+// isReadOnly reports whether a statement can be served by the read replica.
+// Multi-statement scripts always go to the primary.
+func isReadOnly(query string) bool {
+	if len(splitStatements(query)) != 1 {
+		return false
+	}
+	queryUpper := strings.TrimSpace(strings.ToUpper(query))
+	if strings.Contains(queryUpper, " RETURNING ") ||
+		strings.Contains(queryUpper, " FOR UPDATE") ||
+		strings.Contains(queryUpper, " FOR SHARE") {
+		return false
+	}
+	return strings.HasPrefix(queryUpper, "SELECT") ||
+		strings.HasPrefix(queryUpper, "SHOW") ||
+		strings.HasPrefix(queryUpper, "EXPLAIN")
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func executeQuery(pool queryer, query string, params []interface{}, mode string) DatabaseResult {
 	startTime := time.Now()
 
 	queryPreview := query
@@ -141,12 +304,14 @@ func ExecuteDatabaseQuery(query string, params []interface{}, mode string) Datab
 		strings.HasPrefix(queryUpper, "SHOW") ||
 		strings.HasPrefix(queryUpper, "DESCRIBE") ||
 		strings.HasPrefix(queryUpper, "DESC") ||
-		strings.HasPrefix(queryUpper, "EXPLAIN")
+		strings.HasPrefix(queryUpper, "EXPLAIN") ||
+		strings.HasPrefix(queryUpper, "PRAGMA") ||
+		strings.Contains(queryUpper, " RETURNING ")
 
 	if mode == "exec" && len(params) == 0 {
 		// Exec mode for DDL or multiple statements without parameters
 		utils.Log.Debug("database", "Using exec mode (DDL/multiple statements)", nil)
-		_, err := db.Exec(query)
+		_, err := pool.Exec(query)
 		duration := time.Since(startTime).Milliseconds()
 
 		if err != nil {
@@ -165,10 +330,11 @@ func ExecuteDatabaseQuery(query string, params []interface{}, mode string) Datab
 
 	// Prepared statement mode
 	utils.Log.Debug("database", "Using prepared statement mode", nil)
+	query = dialect.Rebind(query)
 
 	if isSelect {
 		// SELECT query
-		rows, err := db.Query(query, params...)
+		rows, err := pool.Query(query, params...)
 		if err != nil {
 			duration := time.Since(startTime).Milliseconds()
 			utils.Log.Error("database", fmt.Sprintf("Query failed after %dms", duration), err)
@@ -178,13 +344,17 @@ func ExecuteDatabaseQuery(query string, params []interface{}, mode string) Datab
 		}
 		defer rows.Close()
 
-		columns, err := rows.Columns()
+		columnTypes, err := rows.ColumnTypes()
 		if err != nil {
 			duration := time.Since(startTime).Milliseconds()
 			result.Error = err.Error()
 			result.Duration = duration
 			return result
 		}
+		columns := make([]string, len(columnTypes))
+		for i, ct := range columnTypes {
+			columns[i] = ct.Name()
+		}
 
 		var allRows []map[string]interface{}
 		for rows.Next() {
@@ -200,17 +370,7 @@ func ExecuteDatabaseQuery(query string, params []interface{}, mode string) Datab
 
 			row := make(map[string]interface{})
 			for i, col := range columns {
-				val := values[i]
-				if b, ok := val.([]byte); ok {
-					// Try to unmarshal JSON
-					var jsonVal interface{}
-					if err := json.Unmarshal(b, &jsonVal); err == nil {
-						val = jsonVal
-					} else {
-						val = string(b)
-					}
-				}
-				row[col] = val
+				row[col] = decodeColumn(values[i], columnTypes[i].DatabaseTypeName())
 			}
 			allRows = append(allRows, row)
 		}
@@ -225,7 +385,7 @@ func ExecuteDatabaseQuery(query string, params []interface{}, mode string) Datab
 		return result
 	} else {
 		// INSERT, UPDATE, DELETE
-		res, err := db.Exec(query, params...)
+		res, err := pool.Exec(query, params...)
 		if err != nil {
 			duration := time.Since(startTime).Milliseconds()
 			utils.Log.Error("database", fmt.Sprintf("Query failed after %dms", duration), err)
@@ -252,6 +412,58 @@ func ExecuteDatabaseQuery(query string, params []interface{}, mode string) Datab
 	}
 }
 
+// decodeColumn converts a scanned value according to the column's declared
+// type. Only JSON columns are parsed as JSON; DECIMAL/NUMERIC stay strings so
+// no precision is lost, and times are formatted as RFC3339.
+func decodeColumn(value interface{}, typeName string) interface{} {
+	typeName = strings.ToUpper(typeName)
+
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return v
+	}
+
+	switch {
+	case typeName == "JSON" || typeName == "JSONB":
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(text), &parsed); err == nil {
+			return parsed
+		}
+	case typeName == "DECIMAL" || typeName == "NUMERIC":
+		return text
+	case typeName == "BOOL" || typeName == "BOOLEAN":
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	case strings.Contains(typeName, "INT") && typeName != "INTERVAL" && !strings.Contains(typeName, "POINT"):
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(text, 10, 64); err == nil {
+			return n
+		}
+	case strings.Contains(typeName, "FLOAT") || strings.Contains(typeName, "DOUBLE") || typeName == "REAL":
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	case strings.Contains(typeName, "BLOB") || strings.Contains(typeName, "BINARY") || typeName == "BYTEA":
+		if b, ok := value.([]byte); ok && !utf8.Valid(b) {
+			// Marshalled as base64
+			return b
+		}
+	}
+	return text
+}
+
 func GetDatabaseContext() string {
 	var count int
 	err := db.QueryRow("SELECT COUNT(*) FROM contacts").Scan(&count)
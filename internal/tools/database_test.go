@@ -0,0 +1,43 @@
+package tools
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeColumn(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		typeName string
+		want     interface{}
+	}{
+		{"null", nil, "TEXT", nil},
+		{"time", time.Date(2026, 10, 18, 14, 23, 29, 0, time.UTC), "TIMESTAMP", "2026-10-18T14:23:29Z"},
+		{"native int", int64(7), "INTEGER", int64(7)},
+		{"text bytes", []byte("床前明月光"), "VARCHAR", "床前明月光"},
+		{"json object", []byte(`{"a":1}`), "JSON", map[string]interface{}{"a": float64(1)}},
+		{"jsonb lowercase type", `["x"]`, "jsonb", []interface{}{"x"}},
+		{"invalid json stays text", "{oops", "JSON", "{oops"},
+		{"json-looking text", `{"a":1}`, "TEXT", `{"a":1}`},
+		{"decimal keeps precision", []byte("12345678901234567890.12"), "DECIMAL", "12345678901234567890.12"},
+		{"bool", []byte("true"), "BOOLEAN", true},
+		{"bool from digit", "1", "BOOL", true},
+		{"int from bytes", []byte("42"), "BIGINT", int64(42)},
+		{"unsigned above int64", []byte("18446744073709551615"), "BIGINT UNSIGNED", uint64(18446744073709551615)},
+		{"interval is not an int", "1 day", "INTERVAL", "1 day"},
+		{"point is not an int", "(1,2)", "POINT", "(1,2)"},
+		{"float", []byte("4.5"), "DOUBLE", 4.5},
+		{"real", "0.25", "REAL", 0.25},
+		{"binary blob", []byte{0xff, 0x00, 0xfe}, "BLOB", []byte{0xff, 0x00, 0xfe}},
+		{"text blob", []byte("plain"), "BLOB", "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeColumn(tt.value, tt.typeName); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeColumn(%v, %q) = %#v, want %#v", tt.value, tt.typeName, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/nokode/nokode/internal/config"
+)
+
+// Dialect describes the backend-specific parts of the database layer:
+// how to connect, how to introspect the schema and how to describe the
+// SQL flavour to the model.
+type Dialect interface {
+	// Name is the human readable backend name, e.g. "MySQL".
+	Name() string
+	// DriverName is the database/sql driver registered for the backend.
+	DriverName() string
+	// DSN builds the data source name from the database configuration.
+	DSN(cfg *config.Config) (string, error)
+	// TenantDSN creates the tenant's schema if it does not exist yet and
+	// returns a DSN connected to it.
+	TenantDSN(db *sql.DB, cfg *config.Config, tenant string) (string, error)
+	// ConfigurePool applies backend appropriate default pool settings;
+	// configured pool sizes and lifetimes are applied on top.
+	ConfigurePool(db *sql.DB)
+	// Tables lists the application tables in the connected database.
+	Tables(db *sql.DB) ([]string, error)
+	// CreateTable returns the DDL statement describing a table.
+	CreateTable(db *sql.DB, table string) (string, error)
+	// Hint tells the model which SQL features to use for this backend.
+	Hint() string
+	// RandomFunc is the SQL function used for ORDER BY random.
+	RandomFunc() string
+	// Rebind rewrites ? placeholders into the backend's bind syntax.
+	Rebind(query string) string
+	// Explain estimates how many rows a SELECT will examine and returns the
+	// plan as reported by the backend.
+	Explain(db *sql.DB, query string, params []interface{}) (int64, interface{}, error)
+	// FullTextIndex returns the DDL for a full-text index over the columns,
+	// or "" if the backend has no suitable index for Chinese text.
+	FullTextIndex(table, index string, columns []string) string
+	// FullTextScore returns an expression scoring the columns against one ?
+	// placeholder, or "" to fall back to LIKE matching.
+	FullTextScore(columns []string) string
+	// AutoIncrementKey is the column definition of a generated integer
+	// primary key, used by the tables nokode creates for itself.
+	AutoIncrementKey() string
+	// LongText is the column type for text that may exceed 64KB.
+	LongText() string
+	// Upsert returns an INSERT of the columns, with ? placeholders, that
+	// updates the update columns of the existing row instead when a row
+	// with the same key already exists.
+	Upsert(table string, columns, key, update []string) string
+}
+
+var dialects = map[string]Dialect{
+	"mysql":    mysqlDialect{},
+	"sqlite":   sqliteDialect{},
+	"postgres": postgresDialect{},
+}
+
+var dialect Dialect = mysqlDialect{}
+
+func lookupDialect(driver string) (Dialect, error) {
+	name := strings.ToLower(strings.TrimSpace(driver))
+	if name == "" {
+		name = "mysql"
+	}
+	d, ok := dialects[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+	return d, nil
+}
+
+// CurrentDialect returns the dialect of the connected database.
+func CurrentDialect() Dialect {
+	return dialect
+}
+
+// insertStatement returns an INSERT of the columns with ? placeholders.
+func insertStatement(table string, columns []string) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders)
+}
+
+// upsertOnConflict is the ON CONFLICT upsert shared by PostgreSQL and
+// SQLite.
+func upsertOnConflict(table string, columns, key, update []string) string {
+	set := make([]string, len(update))
+	for i, column := range update {
+		set[i] = column + " = excluded." + column
+	}
+	return insertStatement(table, columns) + " ON CONFLICT (" + strings.Join(key, ", ") + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
@@ -0,0 +1,195 @@
+package tools
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// epubNamespace derives the book identifier from the poems it contains, so
+// exporting the same poems twice yields the same identifier.
+var epubNamespace = uuid.MustParse("6f1d0f3e-7c2a-4d5b-9a8e-2b4c6d8e0f1a")
+
+// writeEPUB writes an EPUB 3 book with one chapter per dynasty and author.
+// It also carries an NCX table of contents for EPUB 2 readers.
+func writeEPUB(w io.Writer, title string, poems []Poem) error {
+	if len(poems) == 0 {
+		// An EPUB needs at least one document in its spine
+		return ErrNoPoems
+	}
+	groups := groupPoems(poems)
+	ids := make([]string, len(poems))
+	for i, poem := range poems {
+		ids[i] = fmt.Sprint(poem.ID)
+	}
+	bookID := "urn:uuid:" + uuid.NewSHA1(epubNamespace, []byte(title+"\n"+strings.Join(ids, ","))).String()
+
+	zw := zip.NewWriter(w)
+	// The mimetype must come first, uncompressed and without a data
+	// descriptor, so it is written raw.
+	mimetype := []byte("application/epub+zip")
+	fw, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(mimetype),
+		CompressedSize64:   uint64(len(mimetype)),
+		UncompressedSize64: uint64(len(mimetype)),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(mimetype); err != nil {
+		return err
+	}
+
+	files := []struct{ name, body string }{
+		{"META-INF/container.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`},
+		{"OEBPS/content.opf", epubPackage(title, bookID, groups)},
+		{"OEBPS/nav.xhtml", epubNav(title, groups)},
+		{"OEBPS/toc.ncx", epubNCX(title, bookID, groups)},
+		{"OEBPS/style.css", epubStyle},
+	}
+	for i, group := range groups {
+		files = append(files, struct{ name, body string }{"OEBPS/" + epubChapterFile(i), epubChapter(group)})
+	}
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.body); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+const epubStyle = `body { font-family: "KaiTi", "楷体", serif; line-height: 2; }
+h1, h2 { text-align: center; }
+h3 { text-align: center; margin-top: 2em; }
+p.poem { text-align: center; }
+`
+
+func epubChapterFile(i int) string {
+	return fmt.Sprintf("chapter-%03d.xhtml", i+1)
+}
+
+func epubGroupTitle(group poemGroup) string {
+	return DynastyName(group.dynasty) + " · " + group.author
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func epubPackage(title, bookID string, groups []poemGroup) string {
+	var manifest, spine strings.Builder
+	for i := range groups {
+		fmt.Fprintf(&manifest, "    <item id=\"chapter-%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, epubChapterFile(i))
+		fmt.Fprintf(&spine, "    <itemref idref=\"chapter-%d\"/>\n", i+1)
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id" xml:lang="zh">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">%s</dc:identifier>
+    <dc:title>%s</dc:title>
+    <dc:language>zh</dc:language>
+    <dc:creator>nokode</dc:creator>
+    <meta property="dcterms:modified">%s</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+%s  </manifest>
+  <spine toc="ncx">
+%s  </spine>
+</package>
+`, bookID, xmlEscape(title), time.Now().UTC().Format("2006-01-02T15:04:05Z"), manifest.String(), spine.String())
+}
+
+func epubNav(title string, groups []poemGroup) string {
+	var items strings.Builder
+	for i, group := range groups {
+		fmt.Fprintf(&items, "      <li><a href=\"%s\">%s</a></li>\n", epubChapterFile(i), xmlEscape(epubGroupTitle(group)))
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="zh" lang="zh">
+<head>
+  <title>%s</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>%s</h1>
+    <ol>
+%s    </ol>
+  </nav>
+</body>
+</html>
+`, xmlEscape(title), xmlEscape(title), items.String())
+}
+
+func epubNCX(title, bookID string, groups []poemGroup) string {
+	var points strings.Builder
+	for i, group := range groups {
+		fmt.Fprintf(&points, `    <navPoint id="chapter-%d" playOrder="%d">
+      <navLabel><text>%s</text></navLabel>
+      <content src="%s"/>
+    </navPoint>
+`, i+1, i+1, xmlEscape(epubGroupTitle(group)), epubChapterFile(i))
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="%s"/>
+  </head>
+  <docTitle><text>%s</text></docTitle>
+  <navMap>
+%s  </navMap>
+</ncx>
+`, bookID, xmlEscape(title), points.String())
+}
+
+func epubChapter(group poemGroup) string {
+	var body strings.Builder
+	for _, poem := range group.poems {
+		fmt.Fprintf(&body, "  <h3>%s</h3>\n", xmlEscape(poem.Title))
+		for _, stanza := range PoemStanzas(poem.Content) {
+			for i, line := range stanza {
+				stanza[i] = xmlEscape(line)
+			}
+			fmt.Fprintf(&body, "  <p class=\"poem\">%s</p>\n", strings.Join(stanza, "<br/>"))
+		}
+	}
+	heading := xmlEscape(epubGroupTitle(group))
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="zh" lang="zh">
+<head>
+  <title>%s</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <h2>%s</h2>
+%s</body>
+</html>
+`, heading, heading, body.String())
+}
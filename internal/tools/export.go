@@ -0,0 +1,222 @@
+package tools
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxExportPoems bounds a single export so a runaway table cannot exhaust
+// memory; the EPUB writer needs all poems at once.
+const maxExportPoems = 10000
+
+// ErrNoPoems is returned when an export matches no poems.
+var ErrNoPoems = errors.New("no poems match the export filter")
+
+// ExportFilter selects the poems to export. Zero values match all poems;
+// Since and Until bound created_at inclusively.
+type ExportFilter struct {
+	Author string
+	Since  time.Time
+	Until  time.Time
+}
+
+// Title names the anthology after the author it is limited to.
+func (f ExportFilter) Title() string {
+	if f.Author != "" {
+		return f.Author + "诗集"
+	}
+	return "诗集"
+}
+
+// ExportFormat is a file format poems can be exported to.
+type ExportFormat struct {
+	Extension   string
+	ContentType string
+	write       func(w io.Writer, title string, poems []Poem) error
+}
+
+// ExportFormats are the supported export formats by name.
+var ExportFormats = map[string]ExportFormat{
+	"markdown": {"md", "text/markdown; charset=utf-8", writeMarkdown},
+	"jsonl":    {"jsonl", "application/x-ndjson; charset=utf-8", writeJSONLines},
+	"csv":      {"csv", "text/csv; charset=utf-8", writeCSV},
+	"epub":     {"epub", "application/epub+zip", writeEPUB},
+}
+
+// ExportFormatNames lists the format names for usage and error messages.
+func ExportFormatNames() []string {
+	names := make([]string, 0, len(ExportFormats))
+	for name := range ExportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Write writes the poems, already grouped by ExportPoems, as an anthology
+// with the given title.
+func (f ExportFormat) Write(w io.Writer, title string, poems []Poem) error {
+	return f.write(w, title, poems)
+}
+
+// ExportPoems returns the poems matching the filter grouped for an
+// anthology: Tang before Song, then by author, then oldest first.
+func ExportPoems(info *RequestInfo, filter ExportFilter) ([]Poem, error) {
+	exists, err := poemsTableExists(info)
+	if err != nil || !exists {
+		return []Poem{}, err
+	}
+
+	var conditions []string
+	var params []interface{}
+	if filter.Author != "" {
+		conditions = append(conditions, "author = ?")
+		params = append(params, filter.Author)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		params = append(params, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		params = append(params, filter.Until.UTC())
+	}
+	query := "SELECT " + poemColumns + " FROM poems"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY created_at, id LIMIT %d", maxExportPoems)
+
+	result := ExecuteDatabaseQuery(info, query, params, "query")
+	if !result.Success {
+		return nil, fmt.Errorf("%s", result.Error)
+	}
+	poems := make([]Poem, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		poems = append(poems, poemFromRow(row))
+	}
+	sort.SliceStable(poems, func(i, j int) bool {
+		if a, b := dynastyOrder(poems[i].Dynasty), dynastyOrder(poems[j].Dynasty); a != b {
+			return a < b
+		}
+		return poems[i].Author < poems[j].Author
+	})
+	return poems, nil
+}
+
+// ParseExportTime parses a date range bound given as an RFC3339 timestamp
+// or a YYYY-MM-DD date. A date used as the upper bound covers the whole day.
+func ParseExportTime(value string, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC3339 timestamp nor a YYYY-MM-DD date", value)
+	}
+	if upper {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+func dynastyOrder(dynasty string) int {
+	switch dynasty {
+	case "tang":
+		return 0
+	case "song":
+		return 1
+	}
+	return 2
+}
+
+// DynastyName is the Chinese name of a dynasty code, as shown on pages.
+func DynastyName(dynasty string) string {
+	switch dynasty {
+	case "tang":
+		return "唐代"
+	case "song":
+		return "宋代"
+	}
+	return dynasty
+}
+
+// poemGroup is the poems of one author in one dynasty.
+type poemGroup struct {
+	dynasty, author string
+	poems           []Poem
+}
+
+// groupPoems splits poems sorted by ExportPoems into runs of the same
+// dynasty and author.
+func groupPoems(poems []Poem) []poemGroup {
+	var groups []poemGroup
+	for _, poem := range poems {
+		if n := len(groups); n > 0 && groups[n-1].dynasty == poem.Dynasty && groups[n-1].author == poem.Author {
+			groups[n-1].poems = append(groups[n-1].poems, poem)
+			continue
+		}
+		groups = append(groups, poemGroup{dynasty: poem.Dynasty, author: poem.Author, poems: []Poem{poem}})
+	}
+	return groups
+}
+
+func writeMarkdown(w io.Writer, title string, poems []Poem) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", title)
+	dynasty := "\x00"
+	for _, group := range groupPoems(poems) {
+		if group.dynasty != dynasty {
+			dynasty = group.dynasty
+			fmt.Fprintf(&b, "\n## %s\n", DynastyName(dynasty))
+		}
+		fmt.Fprintf(&b, "\n### %s\n", group.author)
+		for _, poem := range group.poems {
+			fmt.Fprintf(&b, "\n#### %s\n", poem.Title)
+			if poem.Form != "" {
+				fmt.Fprintf(&b, "\n*%s*\n", poem.Form)
+			}
+			for _, stanza := range PoemStanzas(poem.Content) {
+				b.WriteString("\n")
+				for _, line := range stanza {
+					// Two trailing spaces keep the line breaks in rendered Markdown
+					b.WriteString(line + "  \n")
+				}
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeJSONLines(w io.Writer, title string, poems []Poem) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, poem := range poems {
+		if err := enc.Encode(poem); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, title string, poems []Poem) error {
+	// A byte order mark lets spreadsheet programs detect UTF-8
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "title", "author", "dynasty", "form", "content", "user_preference", "created_at"})
+	for _, poem := range poems {
+		cw.Write([]string{strconv.FormatInt(poem.ID, 10), poem.Title, poem.Author, poem.Dynasty, poem.Form, poem.Content, poem.UserPreference, poem.CreatedAt})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,82 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExportTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		upper   bool
+		want    time.Time
+		wantErr bool
+	}{
+		{"date as lower bound", "2026-10-18", false, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC), false},
+		{"date as upper bound covers the day", "2026-10-18", true, time.Date(2026, 10, 18, 23, 59, 59, 0, time.UTC), false},
+		{"rfc3339", "2026-10-18T14:23:29Z", false, time.Date(2026, 10, 18, 14, 23, 29, 0, time.UTC), false},
+		{"rfc3339 upper bound is exact", "2026-10-18T14:23:29Z", true, time.Date(2026, 10, 18, 14, 23, 29, 0, time.UTC), false},
+		{"rfc3339 with offset", "2026-10-18T22:23:29+08:00", false, time.Date(2026, 10, 18, 14, 23, 29, 0, time.UTC), false},
+		{"leap day", "2028-02-29", true, time.Date(2028, 2, 29, 23, 59, 59, 0, time.UTC), false},
+		{"sql timestamp", "2026-10-18 14:23:29", false, time.Time{}, true},
+		{"impossible date", "2026-02-30", false, time.Time{}, true},
+		{"slashes", "2026/10/18", false, time.Time{}, true},
+		{"empty", "", false, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExportTime(tt.value, tt.upper)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExportTime(%q, %v) error = %v, wantErr %v", tt.value, tt.upper, err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseExportTime(%q, %v) = %v, want %v", tt.value, tt.upper, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	poems := []Poem{
+		{Title: "静夜思", Author: "李白", Dynasty: "tang", Form: "五言绝句", Content: jingYeSi},
+		{Title: "早发白帝城", Author: "李白", Dynasty: "tang", Content: "朝辞白帝彩云间，千里江陵一日还。"},
+		{Title: "卜算子", Author: "苏轼", Dynasty: "song", Content: "缺月挂疏桐\n\n拣尽寒枝不肯栖"},
+	}
+	var b bytes.Buffer
+	if err := writeMarkdown(&b, "诗集", poems); err != nil {
+		t.Fatal(err)
+	}
+	want := "# 诗集\n" +
+		"\n## 唐代\n" +
+		"\n### 李白\n" +
+		"\n#### 静夜思\n\n*五言绝句*\n\n床前明月光，疑是地上霜。  \n举头望明月，低头思故乡。  \n" +
+		"\n#### 早发白帝城\n\n朝辞白帝彩云间，千里江陵一日还。  \n" +
+		"\n## 宋代\n" +
+		"\n### 苏轼\n" +
+		"\n#### 卜算子\n\n缺月挂疏桐  \n\n拣尽寒枝不肯栖  \n"
+	if got := b.String(); got != want {
+		t.Errorf("writeMarkdown() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	poems := []Poem{{ID: 7, Title: "静夜思", Author: "李白", Dynasty: "tang", Content: jingYeSi, CreatedAt: "2026-10-18T14:23:29Z"}}
+	if err := writeCSV(&b, "诗集", poems); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(b.String(), "\ufeff") {
+		t.Fatal("writeCSV() does not start with a byte order mark")
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(b.String(), "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][0] != "7" || records[1][5] != jingYeSi || records[1][7] != "2026-10-18T14:23:29Z" {
+		t.Errorf("writeCSV() records = %q", records)
+	}
+}
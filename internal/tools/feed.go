@@ -0,0 +1,240 @@
+package tools
+
+import (
+	"encoding/xml"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// feedSize is how many of the newest poems the feeds carry.
+const feedSize = 50
+
+// feedNamespace derives the feed and entry ids, so they stay the same
+// whatever host name the feed is fetched under.
+var feedNamespace = uuid.MustParse("0b6c8a52-3f1e-4e7d-8c29-5d4a1f9e7b63")
+
+// Feed is a page of the newest poems ready to be written as Atom or RSS.
+type Feed struct {
+	Title   string
+	BaseURL string // scheme and host the links point to, e.g. https://example.com
+	Tenant  string
+	Updated time.Time // creation time of the newest poem
+	Poems   []Poem
+}
+
+// LoadFeed returns the newest poems, newest first. Updated is the Unix
+// epoch when there are none so the feed stays the same until a poem is
+// added.
+func LoadFeed(info *RequestInfo, title, baseURL string) (*Feed, error) {
+	page, err := ListPoems(info, PoemFilter{PageSize: feedSize})
+	if err != nil {
+		return nil, err
+	}
+	feed := &Feed{Title: title, BaseURL: strings.TrimSuffix(baseURL, "/"), Poems: page.Poems, Updated: time.Unix(0, 0).UTC()}
+	if info != nil {
+		feed.Tenant = info.Tenant
+	}
+	for _, poem := range page.Poems {
+		if t := PoemTime(poem); t.After(feed.Updated) {
+			feed.Updated = t
+		}
+	}
+	return feed, nil
+}
+
+// PoemTime parses a poem's created_at, which the drivers return either as
+// RFC3339 or as a plain SQL timestamp. It returns the zero time if neither
+// fits.
+func PoemTime(poem Poem) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, poem.CreatedAt); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// feedID is the stable identifier of the feed or, with a poem, of one of
+// its entries. It includes the creation time so a poem id reused after a
+// delete does not repeat an old entry.
+func (f *Feed) feedID(poem *Poem) string {
+	name := "feed/" + f.Tenant
+	if poem != nil {
+		name += "/poem/" + strconv.FormatInt(poem.ID, 10) + "/" + poem.CreatedAt
+	}
+	return "urn:uuid:" + uuid.NewSHA1(feedNamespace, []byte(name)).String()
+}
+
+func (f *Feed) poemURL(poem Poem) string {
+	return f.BaseURL + "/poems/" + strconv.FormatInt(poem.ID, 10)
+}
+
+// poemHTML renders the poem body for feed readers, a paragraph per stanza
+// and one line per row.
+func poemHTML(poem Poem) string {
+	var b strings.Builder
+	for _, stanza := range PoemStanzas(poem.Content) {
+		for i, line := range stanza {
+			stanza[i] = html.EscapeString(line)
+		}
+		b.WriteString("<p>" + strings.Join(stanza, "<br/>") + "</p>")
+	}
+	meta := html.EscapeString(poem.Author) + " · " + DynastyName(poem.Dynasty)
+	if poem.Form != "" {
+		meta += " · " + html.EscapeString(poem.Form)
+	}
+	return b.String() + "<p>" + meta + "</p>"
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Lang    string      `xml:"xml:lang,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Published string       `xml:"published"`
+	Updated   string       `xml:"updated"`
+	Link      atomLink     `xml:"link"`
+	Author    atomPerson   `xml:"author"`
+	Category  atomCategory `xml:"category"`
+	Content   atomContent  `xml:"content"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// WriteAtom writes the feed as an Atom 1.0 document.
+func (f *Feed) WriteAtom(w io.Writer) error {
+	doc := atomFeed{
+		Lang:    "zh-CN",
+		ID:      f.feedID(nil),
+		Title:   f.Title,
+		Updated: f.Updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: f.BaseURL + "/feed.xml"},
+			{Rel: "alternate", Type: "text/html", Href: f.BaseURL + "/poems"},
+		},
+		Author: atomPerson{Name: "nokode"},
+	}
+	for i := range f.Poems {
+		poem := f.Poems[i]
+		created := PoemTime(poem).Format(time.RFC3339)
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:        f.feedID(&poem),
+			Title:     poem.Title,
+			Published: created,
+			Updated:   created,
+			Link:      atomLink{Rel: "alternate", Type: "text/html", Href: f.poemURL(poem)},
+			Author:    atomPerson{Name: poem.Author},
+			Category:  atomCategory{Term: poem.Dynasty, Label: DynastyName(poem.Dynasty)},
+			Content:   atomContent{Type: "html", Body: poemHTML(poem)},
+		})
+	}
+	return writeXML(w, doc)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Self          atomLink  `xml:"atom:link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Creator     string  `xml:"dc:creator"`
+	Category    string  `xml:"category"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// WriteRSS writes the feed as an RSS 2.0 document.
+func (f *Feed) WriteRSS(w io.Writer) error {
+	doc := rssFeed{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.BaseURL + "/poems",
+			Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: f.BaseURL + "/rss.xml"},
+			Description:   f.Title,
+			Language:      "zh-cn",
+			LastBuildDate: f.Updated.Format(time.RFC1123Z),
+		},
+	}
+	for i := range f.Poems {
+		poem := f.Poems[i]
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       poem.Title,
+			Link:        f.poemURL(poem),
+			GUID:        rssGUID{IsPermaLink: "false", Value: f.feedID(&poem)},
+			PubDate:     PoemTime(poem).Format(time.RFC1123Z),
+			Creator:     poem.Author,
+			Category:    DynastyName(poem.Dynasty),
+			Description: poemHTML(poem),
+		})
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
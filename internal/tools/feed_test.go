@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoemTime(t *testing.T) {
+	stamp := time.Date(2026, 10, 18, 14, 23, 29, 0, time.UTC)
+	tests := []struct {
+		createdAt string
+		want      time.Time
+	}{
+		{"2026-10-18T14:23:29Z", stamp},
+		{"2026-10-18T22:23:29+08:00", stamp},
+		{"2026-10-18T14:23:29.5Z", stamp.Add(500 * time.Millisecond)},
+		{"2026-10-18 14:23:29", stamp},
+		{"2026-10-18 14:23:29.123456", stamp.Add(123456 * time.Microsecond)},
+		{"2026-10-18", time.Time{}},
+		{"yesterday", time.Time{}},
+		{"", time.Time{}},
+	}
+	for _, tt := range tests {
+		got := PoemTime(Poem{CreatedAt: tt.createdAt})
+		if !got.Equal(tt.want) {
+			t.Errorf("PoemTime(%q) = %v, want %v", tt.createdAt, got, tt.want)
+		}
+		if !got.IsZero() && got.Location() != time.UTC {
+			t.Errorf("PoemTime(%q) is in %v, want UTC", tt.createdAt, got.Location())
+		}
+	}
+}
+
+func TestFeedIDStable(t *testing.T) {
+	poem := Poem{ID: 7, Title: "静夜思", CreatedAt: "2026-10-18T14:23:29Z"}
+	feed := &Feed{Tenant: "tea", BaseURL: "https://example.com"}
+	id := feed.feedID(&poem)
+	if !strings.HasPrefix(id, "urn:uuid:") {
+		t.Fatalf("feedID() = %q, want a urn:uuid", id)
+	}
+
+	tests := []struct {
+		name string
+		feed *Feed
+		poem Poem
+		same bool
+	}{
+		{"same poem", &Feed{Tenant: "tea", BaseURL: "https://example.com"}, poem, true},
+		{"other host", &Feed{Tenant: "tea", BaseURL: "http://localhost:3001"}, poem, true},
+		{"edited title", &Feed{Tenant: "tea"}, Poem{ID: 7, Title: "夜思", CreatedAt: poem.CreatedAt}, true},
+		{"other tenant", &Feed{Tenant: "coffee"}, poem, false},
+		{"other id", &Feed{Tenant: "tea"}, Poem{ID: 8, CreatedAt: poem.CreatedAt}, false},
+		{"id reused after a delete", &Feed{Tenant: "tea"}, Poem{ID: 7, CreatedAt: "2026-10-19T09:00:00Z"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.feed.feedID(&tt.poem) == id; got != tt.same {
+			t.Errorf("%s: same id = %v, want %v", tt.name, got, tt.same)
+		}
+	}
+
+	if feed.feedID(nil) != (&Feed{Tenant: "tea"}).feedID(nil) {
+		t.Error("feed id depends on more than the tenant")
+	}
+	if feed.feedID(nil) == (&Feed{}).feedID(nil) {
+		t.Error("feed id of a tenant matches the shared feed")
+	}
+}
+
+func TestWriteFeeds(t *testing.T) {
+	feed := &Feed{
+		Title:   "诗集",
+		BaseURL: "https://example.com",
+		Updated: time.Date(2026, 10, 18, 14, 23, 29, 0, time.UTC),
+		Poems:   []Poem{{ID: 7, Title: "静夜思", Author: "李白 & 杜甫", Dynasty: "tang", Content: jingYeSi, CreatedAt: "2026-10-18 14:23:29"}},
+	}
+	guid := feed.feedID(&feed.Poems[0])
+
+	var atom bytes.Buffer
+	if err := feed.WriteAtom(&atom); err != nil {
+		t.Fatal(err)
+	}
+	var gotAtom struct {
+		ID      string `xml:"id"`
+		Updated string `xml:"updated"`
+		Entries []struct {
+			ID        string `xml:"id"`
+			Published string `xml:"published"`
+			Author    string `xml:"author>name"`
+			Content   string `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(atom.Bytes(), &gotAtom); err != nil {
+		t.Fatalf("WriteAtom() is not valid XML: %v", err)
+	}
+	if gotAtom.ID != feed.feedID(nil) || gotAtom.Updated != "2026-10-18T14:23:29Z" || len(gotAtom.Entries) != 1 {
+		t.Fatalf("WriteAtom() = %+v", gotAtom)
+	}
+	entry := gotAtom.Entries[0]
+	if entry.ID != guid || entry.Published != "2026-10-18T14:23:29Z" || entry.Author != "李白 & 杜甫" {
+		t.Errorf("atom entry = %+v", entry)
+	}
+	if want := "<p>床前明月光，疑是地上霜。<br/>举头望明月，低头思故乡。</p><p>李白 &amp; 杜甫 · 唐代</p>"; entry.Content != want {
+		t.Errorf("atom content = %q, want %q", entry.Content, want)
+	}
+
+	var rss bytes.Buffer
+	if err := feed.WriteRSS(&rss); err != nil {
+		t.Fatal(err)
+	}
+	var gotRSS struct {
+		Items []struct {
+			GUID    string `xml:"guid"`
+			PubDate string `xml:"pubDate"`
+			Link    string `xml:"link"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.Unmarshal(rss.Bytes(), &gotRSS); err != nil {
+		t.Fatalf("WriteRSS() is not valid XML: %v", err)
+	}
+	if len(gotRSS.Items) != 1 {
+		t.Fatalf("WriteRSS() items = %+v", gotRSS.Items)
+	}
+	item := gotRSS.Items[0]
+	if item.GUID != guid || item.PubDate != "Sun, 18 Oct 2026 14:23:29 +0000" || item.Link != "https://example.com/poems/7" {
+		t.Errorf("rss item = %+v", item)
+	}
+}
@@ -0,0 +1,178 @@
+package tools
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+const jobsTable = "nokode_jobs"
+
+// Job kinds.
+const (
+	JobKindBatch   = "batch"   // generates poems like POST /generate
+	JobKindRequest = "request" // renders one page asynchronously
+)
+
+// defaultJobRetention is how long the responses of request jobs are kept
+// when Async.Retention is not set.
+const defaultJobRetention = time.Hour
+
+// Job statuses. A job is finished once it leaves queued and running.
+const (
+	JobQueued      = "queued"
+	JobRunning     = "running"
+	JobSucceeded   = "succeeded"
+	JobFailed      = "failed"
+	JobCancelled   = "cancelled"
+	JobInterrupted = "interrupted" // the server stopped while it ran
+)
+
+// Job is background work whose progress is kept in the jobs table. Params
+// and Result hold kind specific JSON.
+type Job struct {
+	ID         string          `json:"id"`
+	Kind       string          `json:"kind"`
+	Tenant     string          `json:"tenant,omitempty"`
+	Status     string          `json:"status"`
+	Total      int             `json:"total"`
+	Completed  int             `json:"completed"`
+	Failed     int             `json:"failed"`
+	Params     json.RawMessage `json:"params,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// Finished reports whether the job will make no more progress.
+func (j *Job) Finished() bool {
+	return j.Status != JobQueued && j.Status != JobRunning
+}
+
+// InitJobs creates the jobs table in the primary database. Jobs of all
+// tenants share it. Jobs left queued or running by a previous process are
+// marked interrupted, as nothing is working on them any more. Finished
+// request jobs are deleted once they are older than Async.Retention.
+func InitJobs(cfg *config.Config) error {
+	retention := defaultJobRetention
+	if cfg.Async.Retention != "" {
+		d, err := time.ParseDuration(cfg.Async.Retention)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid async retention %q", cfg.Async.Retention)
+		}
+		retention = d
+	}
+
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id VARCHAR(36) NOT NULL PRIMARY KEY,
+		kind VARCHAR(20) NOT NULL,
+		tenant VARCHAR(100) NOT NULL,
+		status VARCHAR(20) NOT NULL,
+		total INT NOT NULL,
+		completed INT NOT NULL,
+		failed INT NOT NULL,
+		params TEXT,
+		result %s,
+		error TEXT,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP NULL
+	)`, jobsTable, dialect.LongText()))
+	if err != nil {
+		utils.Log.Error("jobs", "Failed to create jobs table", err)
+		return err
+	}
+
+	now := time.Now().UTC()
+	res, err := db.Exec(dialect.Rebind(`UPDATE `+jobsTable+` SET status = ?, error = ?, updated_at = ?, finished_at = ? WHERE status IN (?, ?)`),
+		JobInterrupted, "the server stopped before the job finished", now, now, JobQueued, JobRunning)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		utils.Log.Warn("jobs", fmt.Sprintf("Marked %d unfinished jobs as interrupted", n), nil)
+	}
+
+	pruneJobs(retention)
+	go func() {
+		ticker := time.NewTicker(min(retention, time.Minute))
+		defer ticker.Stop()
+		for range ticker.C {
+			pruneJobs(retention)
+		}
+	}()
+	return nil
+}
+
+// pruneJobs deletes request jobs that finished longer than retention ago,
+// along with the responses they hold.
+func pruneJobs(retention time.Duration) {
+	res, err := db.Exec(dialect.Rebind(`DELETE FROM `+jobsTable+` WHERE kind = ? AND finished_at IS NOT NULL AND finished_at < ?`),
+		JobKindRequest, time.Now().UTC().Add(-retention))
+	if err != nil {
+		utils.Log.Error("jobs", "Failed to delete expired request jobs", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		utils.Log.Debug("jobs", fmt.Sprintf("Deleted %d expired request jobs", n), nil)
+	}
+}
+
+// CreateJob stores a new queued job, filling in its id and timestamps.
+func CreateJob(job *Job) error {
+	now := time.Now().UTC()
+	job.ID = uuid.New().String()
+	job.Status = JobQueued
+	job.CreatedAt, job.UpdatedAt = now, now
+	_, err := db.Exec(dialect.Rebind(`INSERT INTO `+jobsTable+` (id, kind, tenant, status, total, completed, failed, params, result, error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		job.ID, job.Kind, job.Tenant, job.Status, job.Total, job.Completed, job.Failed, string(job.Params), string(job.Result), job.Error, now, now)
+	return err
+}
+
+// UpdateJob saves the job's progress, setting FinishedAt once it has
+// finished.
+func UpdateJob(job *Job) error {
+	now := time.Now().UTC()
+	job.UpdatedAt = now
+	if job.Finished() && job.FinishedAt == nil {
+		job.FinishedAt = &now
+	}
+	_, err := db.Exec(dialect.Rebind(`UPDATE `+jobsTable+` SET status = ?, completed = ?, failed = ?, result = ?, error = ?, updated_at = ?, finished_at = ? WHERE id = ?`),
+		job.Status, job.Completed, job.Failed, string(job.Result), job.Error, now, job.FinishedAt, job.ID)
+	return err
+}
+
+// GetJob returns the job with the given id, or nil if there is none.
+func GetJob(id string) (*Job, error) {
+	var job Job
+	var params, result, jobError sql.NullString
+	var finished sql.NullTime
+	err := db.QueryRow(dialect.Rebind(`SELECT id, kind, tenant, status, total, completed, failed, params, result, error, created_at, updated_at, finished_at
+		FROM `+jobsTable+` WHERE id = ?`), id).Scan(&job.ID, &job.Kind, &job.Tenant, &job.Status, &job.Total, &job.Completed, &job.Failed,
+		&params, &result, &jobError, &job.CreatedAt, &job.UpdatedAt, &finished)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if params.String != "" {
+		job.Params = json.RawMessage(params.String)
+	}
+	if result.String != "" {
+		job.Result = json.RawMessage(result.String)
+	}
+	job.Error = jobError.String
+	if finished.Valid {
+		job.FinishedAt = &finished.Time
+	}
+	return &job, nil
+}
@@ -0,0 +1,490 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+const migrationsTable = "schema_migrations"
+
+var (
+	migrationsDir     = "migrations"
+	captureMigrations = true
+	migrationMutex    sync.Mutex
+)
+
+var (
+	migrationFilePattern = regexp.MustCompile(`^(\d+)_[a-z0-9_]+\.sql$`)
+	ddlPattern           = regexp.MustCompile(`(?i)^\s*(CREATE|ALTER|DROP|RENAME)\s+(?:(?:UNIQUE|FULLTEXT|TEMPORARY|OR\s+REPLACE)\s+)*(TABLE|INDEX|VIEW|TRIGGER|SEQUENCE|TYPE)?\s*(?:IF\s+(?:NOT\s+)?EXISTS\s+)?([` + "`" + `"\w.]+)?`)
+	slugPattern          = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// managedMigration is a schema change nokode ships itself. It is applied to
+// the shared database once the table it depends on exists, since the app
+// tables are created by the model on first use.
+type managedMigration struct {
+	Version string
+	Table   string
+	SQL     func() string // "" when there is nothing to apply
+}
+
+var managedMigrations = []managedMigration{
+	{
+		Version: "managed_poems_fulltext",
+		Table:   "poems",
+		SQL: func() string {
+			return dialect.FullTextIndex("poems", "ft_poems", poemSearchColumns)
+		},
+	},
+	{
+		// Poems tables created before poems had a form
+		Version: "managed_poems_form",
+		Table:   "poems",
+		SQL: func() string {
+			if columns, err := tableColumns(db, "poems"); err != nil || containsFold(columns, "form") {
+				return ""
+			}
+			return "ALTER TABLE poems ADD COLUMN form VARCHAR(50)"
+		},
+	},
+}
+
+// managedInfo attributes managed migrations in the audit log.
+var managedInfo = &RequestInfo{RequestID: "nokode", Method: "MIGRATE", Route: "managed"}
+
+// Migration is a numbered schema change tracked in schema_migrations.
+type Migration struct {
+	Version   string     `json:"version"`
+	File      string     `json:"file"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// InitMigrations creates the schema_migrations table and configures where
+// DDL issued by the model is captured.
+func InitMigrations(cfg *config.Config) error {
+	if cfg.Migrations.Dir != "" {
+		migrationsDir = cfg.Migrations.Dir
+	}
+	captureMigrations = !cfg.Migrations.DisableCapture
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (
+		version VARCHAR(255) NOT NULL PRIMARY KEY,
+		checksum VARCHAR(64) NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		utils.Log.Error("migrate", "Failed to create schema_migrations table", err)
+		return err
+	}
+
+	applyManagedMigrations()
+	return nil
+}
+
+// applyManagedMigrations applies the built-in migrations whose table exists
+// and that have not been applied yet. Failures are logged and retried on
+// the next schema change.
+func applyManagedMigrations() {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	applied, err := appliedMigrations()
+	if err != nil {
+		utils.Log.Error("migrate", "Failed to read applied migrations", err)
+		return
+	}
+	tables, err := applicationTables(db)
+	if err != nil {
+		utils.Log.Error("migrate", "Failed to list tables", err)
+		return
+	}
+
+	for _, m := range managedMigrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if !containsFold(tables, m.Table) {
+			continue
+		}
+		statement := m.SQL()
+		if statement == "" {
+			continue
+		}
+		result := executeQuery(db, statement, nil, "exec")
+		recordAudit(managedInfo, statement, nil, "exec", result)
+		if !result.Success {
+			utils.Log.Error("migrate", fmt.Sprintf("Managed migration %s failed", m.Version), fmt.Errorf("%s", result.Error))
+			continue
+		}
+		if err := markMigrationApplied(m.Version, migrationChecksum(statement)); err != nil {
+			utils.Log.Error("migrate", "Failed to record managed migration", err)
+			continue
+		}
+		utils.Log.Success("migrate", fmt.Sprintf("Applied managed migration %s", m.Version), nil)
+	}
+}
+
+// forgetManagedMigrations unrecords the managed migrations of tables that
+// were recreated from a snapshot taken before the migrations were applied,
+// so that applyManagedMigrations applies them again.
+func forgetManagedMigrations(tables []string, takenAt time.Time) error {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range managedMigrations {
+		appliedAt, ok := applied[m.Version]
+		if !ok || !containsFold(tables, m.Table) || appliedAt.Before(takenAt) {
+			continue
+		}
+		if _, err := db.Exec(dialect.Rebind("DELETE FROM "+migrationsTable+" WHERE version = ?"), m.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableColumns returns the column names of a table.
+func tableColumns(pool *sql.DB, table string) ([]string, error) {
+	rows, err := pool.Query("SELECT * FROM " + table + " WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+func containsFold(values []string, target string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDDL reports whether any statement of a query changes the schema.
+func isDDL(query string) bool {
+	for _, statement := range splitStatements(query) {
+		if ddlPattern.MatchString(statement) {
+			return true
+		}
+	}
+	return false
+}
+
+// captureMigration records a successfully executed DDL statement as the next
+// numbered migration file and marks it as applied. Statements that were
+// captured before are skipped, so a model that re-issues
+// CREATE TABLE IF NOT EXISTS on every request does not pile up migrations.
+// Only the DDL of a multi-statement script is kept. Tenant schemas are not
+// captured: schema_migrations and the migration files describe the shared
+// database only, and one tenant's schema must not be replayed onto it.
+func captureMigration(info *RequestInfo, query string) {
+	if !captureMigrations {
+		return
+	}
+	if tenancyMode != "off" && info != nil && info.Tenant != "" {
+		utils.Log.Debug("migrate", "Not capturing DDL issued in a tenant schema", map[string]interface{}{"tenant": info.Tenant})
+		return
+	}
+
+	statement := migrationStatement(query)
+	if statement == "" {
+		return
+	}
+	checksum := migrationChecksum(statement)
+
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	var existing int
+	err := db.QueryRow(dialect.Rebind("SELECT COUNT(*) FROM "+migrationsTable+" WHERE checksum = ?"), checksum).Scan(&existing)
+	if err != nil {
+		utils.Log.Error("migrate", "Failed to check captured migrations", err)
+		return
+	}
+	if existing > 0 {
+		return
+	}
+
+	if err := os.MkdirAll(migrationsDir, 0755); err != nil {
+		utils.Log.Error("migrate", "Failed to create migrations directory", err)
+		return
+	}
+
+	next, err := nextMigrationNumber()
+	if err != nil {
+		utils.Log.Error("migrate", "Failed to number migration", err)
+		return
+	}
+	version := fmt.Sprintf("%04d_%s", next, migrationSlug(statement))
+	file := filepath.Join(migrationsDir, version+".sql")
+
+	header := fmt.Sprintf("-- Captured from request %s (%s %s) at %s\n",
+		info.RequestID, info.Method, info.Route, time.Now().UTC().Format(time.RFC3339))
+	if err := os.WriteFile(file, []byte(header+statement+";\n"), 0644); err != nil {
+		utils.Log.Error("migrate", "Failed to write migration file", err)
+		return
+	}
+
+	if err := markMigrationApplied(version, checksum); err != nil {
+		utils.Log.Error("migrate", "Failed to record captured migration", err)
+		return
+	}
+
+	utils.Log.Success("migrate", fmt.Sprintf("Captured migration %s", file), nil)
+}
+
+// migrationStatement keeps the DDL statements of a script, without their
+// trailing semicolon, or returns "" if there are none.
+func migrationStatement(query string) string {
+	var ddl []string
+	for _, statement := range splitStatements(query) {
+		if isDDL(statement) {
+			ddl = append(ddl, statement)
+		}
+	}
+	return strings.Join(ddl, ";\n")
+}
+
+func migrationChecksum(statement string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(statement), " "))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// migrationSlug names a migration after its statement, e.g.
+// "create_table_poems" for CREATE TABLE IF NOT EXISTS poems (...).
+func migrationSlug(statement string) string {
+	m := ddlPattern.FindStringSubmatch(statement)
+	if m == nil {
+		return "schema_change"
+	}
+	parts := []string{m[1], m[2], strings.Trim(m[3], "`\"")}
+	slug := slugPattern.ReplaceAllString(strings.ToLower(strings.Join(parts, "_")), "_")
+	slug = strings.Trim(slug, "_")
+	if len(slug) > 60 {
+		slug = slug[:60]
+	}
+	return slug
+}
+
+func nextMigrationNumber() (int, error) {
+	files, err := migrationFiles()
+	if err != nil {
+		return 0, err
+	}
+	highest := 0
+	for _, name := range files {
+		n, _ := strconv.Atoi(migrationFilePattern.FindStringSubmatch(name)[1])
+		if n > highest {
+			highest = n
+		}
+	}
+	return highest + 1, nil
+}
+
+// migrationFiles lists the migration files in order.
+func migrationFiles() ([]string, error) {
+	entries, err := os.ReadDir(migrationsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && migrationFilePattern.MatchString(entry.Name()) {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		a, _ := strconv.Atoi(migrationFilePattern.FindStringSubmatch(files[i])[1])
+		b, _ := strconv.Atoi(migrationFilePattern.FindStringSubmatch(files[j])[1])
+		return a < b
+	})
+	return files, nil
+}
+
+func markMigrationApplied(version, checksum string) error {
+	_, err := db.Exec(dialect.Rebind("INSERT INTO "+migrationsTable+" (version, checksum, applied_at) VALUES (?, ?, ?)"),
+		version, checksum, time.Now().UTC())
+	return err
+}
+
+func appliedMigrations() (map[string]time.Time, error) {
+	rows, err := db.Query("SELECT version, applied_at FROM " + migrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]time.Time)
+	for rows.Next() {
+		var version string
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus lists the migration files and when each was applied.
+func MigrationStatus() ([]Migration, error) {
+	files, err := migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, name := range files {
+		version := strings.TrimSuffix(name, ".sql")
+		m := Migration{Version: version, File: filepath.Join(migrationsDir, name)}
+		if t, ok := applied[version]; ok {
+			m.AppliedAt = &t
+		}
+		migrations = append(migrations, m)
+	}
+	for _, managed := range managedMigrations {
+		m := Migration{Version: managed.Version, File: "built-in"}
+		if t, ok := applied[managed.Version]; ok {
+			m.AppliedAt = &t
+		}
+		migrations = append(migrations, m)
+	}
+	return migrations, nil
+}
+
+// MigrateUp applies every migration file that has not been applied yet, in
+// order, and returns the versions it applied. Each statement is recorded in
+// the audit log on behalf of info.
+func MigrateUp(info *RequestInfo) ([]string, error) {
+	done, err := migrateFiles(info)
+	if len(done) > 0 {
+		applyManagedMigrations()
+		loadDatabaseSchema()
+	}
+	return done, err
+}
+
+func migrateFiles(info *RequestInfo) ([]string, error) {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	files, err := migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []string
+	for _, name := range files {
+		version := strings.TrimSuffix(name, ".sql")
+		if _, ok := applied[version]; ok {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(migrationsDir, name))
+		if err != nil {
+			return done, err
+		}
+		statements := splitStatements(string(content))
+		for _, statement := range statements {
+			result := executeQuery(db, statement, nil, "exec")
+			recordAudit(info, statement, nil, "exec", result)
+			if !result.Success {
+				return done, fmt.Errorf("migration %s failed: %s", version, result.Error)
+			}
+		}
+		checksum := migrationChecksum(strings.Join(statements, ";\n"))
+		if err := markMigrationApplied(version, checksum); err != nil {
+			return done, err
+		}
+
+		utils.Log.Success("migrate", fmt.Sprintf("Applied migration %s", version), nil)
+		done = append(done, version)
+	}
+
+	return done, nil
+}
+
+// splitStatements splits a SQL script on top-level semicolons, skipping
+// comments and semicolons inside quotes. Quotes may be escaped by doubling
+// them or, as MySQL allows, with a backslash.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(script) && script[end] != c {
+				if script[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			if end >= len(script) {
+				end = len(script) - 1
+			}
+			current.WriteString(script[i : end+1])
+			i = end
+		case c == '-' && i+1 < len(script) && script[i+1] == '-':
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			i += end - 1
+		case c == '/' && i+1 < len(script) && script[i+1] == '*':
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				end = len(script) - i - 4
+			}
+			current.WriteByte(' ')
+			i += end + 3
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return statements
+}
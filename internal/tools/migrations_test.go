@@ -0,0 +1,99 @@
+package tools
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"single", "SELECT 1", []string{"SELECT 1"}},
+		{"trailing semicolon", "SELECT 1;", []string{"SELECT 1"}},
+		{"several", "CREATE TABLE a (id INT);\nINSERT INTO a VALUES (1);", []string{"CREATE TABLE a (id INT)", "INSERT INTO a VALUES (1)"}},
+		{"empty statements", ";;SELECT 1;;", []string{"SELECT 1"}},
+		{"semicolon in single quotes", "INSERT INTO a VALUES ('x;y'); SELECT 1", []string{"INSERT INTO a VALUES ('x;y')", "SELECT 1"}},
+		{"semicolon in double quotes", `SELECT "a;b" FROM t`, []string{`SELECT "a;b" FROM t`}},
+		{"semicolon in backticks", "SELECT `a;b` FROM t", []string{"SELECT `a;b` FROM t"}},
+		{"line comment", "-- drop it; really\nDROP TABLE a;", []string{"DROP TABLE a"}},
+		{"comment at end", "SELECT 1; -- done;", []string{"SELECT 1"}},
+		{"only comments", "-- nothing here\n", nil},
+		{"unterminated quote", "SELECT 'a;b", []string{"SELECT 'a;b"}},
+		{"doubled quote", "INSERT INTO a VALUES ('it''s;'); SELECT 1", []string{"INSERT INTO a VALUES ('it''s;')", "SELECT 1"}},
+		{"backslash-escaped quote", `INSERT INTO a VALUES ('it\'s;'); SELECT 1`, []string{`INSERT INTO a VALUES ('it\'s;')`, "SELECT 1"}},
+		{"escaped backslash", `INSERT INTO a VALUES ('a\\'); SELECT 1`, []string{`INSERT INTO a VALUES ('a\\')`, "SELECT 1"}},
+		{"block comment", "/* drop it; really */ DROP TABLE a;", []string{"DROP TABLE a"}},
+		{"block comment between words", "SELECT/*;*/1", []string{"SELECT 1"}},
+		{"multi-line block comment", "SELECT 1; /* one;\ntwo; */ SELECT 2", []string{"SELECT 1", "SELECT 2"}},
+		{"unterminated block comment", "SELECT 1; /* ;", []string{"SELECT 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", tt.script, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDDL(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{"CREATE TABLE a (id INT)", true},
+		{"SELECT * FROM a", false},
+		{"INSERT INTO a VALUES (1); ALTER TABLE a ADD COLUMN b INT", true},
+		{"INSERT INTO a VALUES ('ALTER TABLE a'); SELECT 1", false},
+		{"-- add a column\nALTER TABLE a ADD COLUMN b INT", true},
+		{"/* ALTER TABLE a */ SELECT 1", false},
+	}
+	for _, tt := range tests {
+		if got := isDDL(tt.query); got != tt.want {
+			t.Errorf("isDDL(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMigrationStatement(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"ddl only", "CREATE TABLE a (id INT);", "CREATE TABLE a (id INT)"},
+		{"no ddl", "INSERT INTO a VALUES (1); SELECT * FROM a", ""},
+		{"mixed", "CREATE TABLE a (id INT); INSERT INTO a VALUES (1); CREATE INDEX idx_a ON a (id)", "CREATE TABLE a (id INT);\nCREATE INDEX idx_a ON a (id)"},
+		{"alter and drop", "ALTER TABLE a ADD COLUMN b INT; DROP TABLE c", "ALTER TABLE a ADD COLUMN b INT;\nDROP TABLE c"},
+		{"ddl after dml", "INSERT INTO a VALUES (1); ALTER TABLE a ADD COLUMN b INT", "ALTER TABLE a ADD COLUMN b INT"},
+		{"quoted semicolon", "CREATE TABLE a (b VARCHAR(10) DEFAULT 'x;y'); UPDATE a SET b = 'z'", "CREATE TABLE a (b VARCHAR(10) DEFAULT 'x;y')"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := migrationStatement(tt.query); got != tt.want {
+				t.Errorf("migrationStatement(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationSlug(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      string
+	}{
+		{"CREATE TABLE IF NOT EXISTS poems (id INT)", "create_table_poems"},
+		{"create unique index idx_title on poems (title)", "create_index_idx_title"},
+		{"ALTER TABLE `poems` ADD COLUMN form VARCHAR(50)", "alter_table_poems"},
+		{`DROP TABLE "tea"`, "drop_table_tea"},
+		{"INSERT INTO poems VALUES (1)", "schema_change"},
+	}
+	for _, tt := range tests {
+		if got := migrationSlug(tt.statement); got != tt.want {
+			t.Errorf("migrationSlug(%q) = %q, want %q", tt.statement, got, tt.want)
+		}
+	}
+}
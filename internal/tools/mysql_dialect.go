@@ -0,0 +1,133 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/nokode/nokode/internal/config"
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string               { return "MySQL" }
+func (mysqlDialect) DriverName() string         { return "mysql" }
+func (mysqlDialect) RandomFunc() string         { return "RAND()" }
+func (mysqlDialect) AutoIncrementKey() string   { return "BIGINT AUTO_INCREMENT PRIMARY KEY" }
+func (mysqlDialect) LongText() string           { return "LONGTEXT" }
+func (mysqlDialect) Rebind(query string) string { return query }
+
+func (mysqlDialect) Upsert(table string, columns, key, update []string) string {
+	set := make([]string, len(update))
+	for i, column := range update {
+		set[i] = column + " = VALUES(" + column + ")"
+	}
+	return insertStatement(table, columns) + " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+}
+
+func (mysqlDialect) DSN(cfg *config.Config) (string, error) {
+	loc, err := time.LoadLocation(databaseTimezone(cfg))
+	if err != nil {
+		return "", fmt.Errorf("invalid database timezone: %w", err)
+	}
+
+	mc := mysql.NewConfig()
+	mc.User = cfg.Database.User
+	mc.Passwd = cfg.Database.Password
+	mc.DBName = cfg.Database.Database
+	mc.ParseTime = true
+	mc.Loc = loc
+	mc.TLSConfig = cfg.Database.TLS
+	if cfg.Database.Socket != "" {
+		mc.Net = "unix"
+		mc.Addr = cfg.Database.Socket
+	} else {
+		mc.Net = "tcp"
+		mc.Addr = net.JoinHostPort(cfg.Database.Host, strconv.Itoa(cfg.Database.Port))
+	}
+	mc.Params = map[string]string{"charset": "utf8mb4"}
+	for key, value := range cfg.Database.Params {
+		mc.Params[key] = value
+	}
+	return mc.FormatDSN(), nil
+}
+
+// TenantDSN gives every tenant its own database named <database>_<tenant>.
+func (d mysqlDialect) TenantDSN(db *sql.DB, cfg *config.Config, tenant string) (string, error) {
+	tenantCfg := *cfg
+	tenantCfg.Database.Database = cfg.Database.Database + "_" + tenant
+	if _, err := db.Exec("CREATE DATABASE IF NOT EXISTS `" + tenantCfg.Database.Database + "` CHARACTER SET utf8mb4"); err != nil {
+		return "", err
+	}
+	return d.DSN(&tenantCfg)
+}
+
+func (mysqlDialect) ConfigurePool(db *sql.DB) {
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(5)
+}
+
+func (mysqlDialect) Tables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SHOW TABLES")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			continue
+		}
+		tables = append(tables, tableName)
+	}
+	return tables, rows.Err()
+}
+
+func (mysqlDialect) CreateTable(db *sql.DB, table string) (string, error) {
+	var unused, createStmt string
+	err := db.QueryRow("SHOW CREATE TABLE `"+table+"`").Scan(&unused, &createStmt)
+	return createStmt, err
+}
+
+// Explain multiplies the per-table row estimates, which is what a nested
+// loop join examines.
+func (mysqlDialect) Explain(db *sql.DB, query string, params []interface{}) (int64, interface{}, error) {
+	plan, err := queryMaps(db, "EXPLAIN "+query, params...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	estimate := int64(1)
+	for _, step := range plan {
+		switch rows := step["rows"].(type) {
+		case int64:
+			estimate *= rows
+		case uint64:
+			estimate *= int64(rows)
+		case string:
+			n, _ := strconv.ParseInt(rows, 10, 64)
+			estimate *= n
+		}
+	}
+	return estimate, plan, nil
+}
+
+// FullTextIndex uses the ngram parser, since the default parser splits on
+// whitespace and Chinese text has none.
+func (mysqlDialect) FullTextIndex(table, index string, columns []string) string {
+	return fmt.Sprintf("CREATE FULLTEXT INDEX %s ON %s (%s) WITH PARSER ngram", index, table, strings.Join(columns, ", "))
+}
+
+func (mysqlDialect) FullTextScore(columns []string) string {
+	return fmt.Sprintf("MATCH(%s) AGAINST (? IN NATURAL LANGUAGE MODE)", strings.Join(columns, ", "))
+}
+
+func (mysqlDialect) Hint() string {
+	return "MySQL: use AUTO_INCREMENT, ENUM, NOW(), RAND(), backtick-quoted identifiers and ? placeholders."
+}
@@ -0,0 +1,255 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Card geometry in pixels. The poem is set in vertical columns read from
+// right to left: the title, then the author and dynasty, then one column
+// per line of the poem.
+const (
+	cardMargin     = 40.0 // gradient background around the card
+	cardPadding    = 56.0
+	cardRadius     = 20.0
+	cardTitleSize  = 40.0
+	cardMetaSize   = 22.0
+	cardPillSize   = 16.0
+	cardLineSize   = 34.0
+	cardLeading    = 1.25 // vertical advance per character, in font sizes
+	cardColumnGap  = 22.0
+	cardMinRunes   = 8  // shortest column height, so short poems are not squat
+	maxColumnRunes = 14 // longer lines wrap into another column
+)
+
+// The fonts of generatePoemDisplayHTML: the title and poem in KaiTi, the
+// rest in a sans-serif face.
+const (
+	cardKaiFonts  = `'KaiTi', '楷体', 'STKaiti', serif`
+	cardSansFonts = `'Microsoft YaHei', 'PingFang SC', 'Hiragino Sans GB', 'WenQuanYi Micro Hei', sans-serif`
+)
+
+// verticalForms maps punctuation to the forms used in vertical text.
+var verticalForms = map[rune]rune{
+	'《': '︽', '》': '︾', '（': '︵', '）': '︶', '「': '﹁', '」': '﹂',
+	'“': '﹁', '”': '﹂', '‘': '﹃', '’': '﹄', '—': '︱', '…': '︙',
+}
+
+// cornerPunctuation sits in the upper right of its cell in vertical text.
+const cornerPunctuation = "，。、；：？！,.;:?!"
+
+// cardPaint is a solid color or, with Gradient set, a linear gradient
+// running from the top left of the shape along (DX, DY).
+type cardPaint struct {
+	Color    string
+	Opacity  float64 // 0 means opaque
+	Gradient []string
+	DX, DY   float64
+}
+
+var (
+	cardBackground = cardPaint{Gradient: []string{"#667eea", "#764ba2"}, DX: 1, DY: 1}
+	cardAccent     = cardPaint{Gradient: []string{"#667eea", "#764ba2", "#f093fb", "#f5576c"}, DY: 1}
+)
+
+// cardShape is a rounded rectangle.
+type cardShape struct {
+	X, Y, W, H, R float64
+	Paint         cardPaint
+}
+
+// cardGlyph is one character centered in its cell at (X, Y).
+type cardGlyph struct {
+	X, Y  float64
+	Size  float64
+	Color string
+	Bold  bool
+	Kai   bool
+	Rune  rune
+}
+
+// poemCard is the laid out card, drawn by WritePoemCardSVG and
+// WritePoemCardPNG alike so both formats look the same.
+type poemCard struct {
+	Title  string
+	Width  float64
+	Height float64
+	Shapes []cardShape
+	Glyphs []cardGlyph
+}
+
+// cardColumn is a run of characters set top to bottom.
+type cardColumn struct {
+	runes []rune
+	size  float64
+	color string
+	bold  bool
+	kai   bool
+}
+
+func (c cardColumn) height() float64 {
+	return float64(len(c.runes)) * c.size * cardLeading
+}
+
+// splitColumns breaks text into columns of at most maxColumnRunes.
+func splitColumns(text string) [][]rune {
+	runes := []rune(strings.TrimSpace(text))
+	var columns [][]rune
+	for len(runes) > maxColumnRunes {
+		columns = append(columns, runes[:maxColumnRunes])
+		runes = runes[maxColumnRunes:]
+	}
+	if len(runes) > 0 {
+		columns = append(columns, runes)
+	}
+	return columns
+}
+
+// layoutPoemCard places the poem on a card styled after the poem page.
+func layoutPoemCard(poem Poem) *poemCard {
+	var titles, lines []cardColumn
+	for _, runes := range splitColumns(poem.Title) {
+		titles = append(titles, cardColumn{runes: runes, size: cardTitleSize, color: "#2c3e50", bold: true, kai: true})
+	}
+	for i, stanza := range PoemStanzas(poem.Content) {
+		if i > 0 {
+			// An empty column sets the stanzas apart
+			lines = append(lines, cardColumn{size: cardLineSize / 2})
+		}
+		for _, line := range stanza {
+			for _, runes := range splitColumns(line) {
+				lines = append(lines, cardColumn{runes: runes, size: cardLineSize, color: "#2c3e50", kai: true})
+			}
+		}
+	}
+	author := cardColumn{runes: []rune(strings.TrimSpace(poem.Author)), size: cardMetaSize, color: "#34495e", bold: true}
+	dynasty := []rune(DynastyName(poem.Dynasty))
+	pillH := float64(len(dynasty))*cardPillSize*cardLeading + cardPillSize
+	metaH := author.height() + cardMetaSize*0.5 + pillH
+
+	bodyH := float64(cardMinRunes) * cardLineSize * cardLeading
+	bodyW := 0.0
+	for _, col := range titles {
+		bodyH = math.Max(bodyH, col.height())
+		bodyW += col.size + cardColumnGap
+	}
+	bodyH = math.Max(bodyH, metaH)
+	bodyW += cardMetaSize + cardColumnGap*2
+	for _, col := range lines {
+		bodyH = math.Max(bodyH, col.height())
+		bodyW += col.size + cardColumnGap
+	}
+	bodyW -= cardColumnGap
+
+	card := &poemCard{
+		Title:  "《" + poem.Title + "》 " + poem.Author,
+		Width:  math.Ceil(bodyW + 2*(cardPadding+cardMargin)),
+		Height: math.Ceil(bodyH + 2*(cardPadding+cardMargin)),
+	}
+	cardW, cardH := card.Width-2*cardMargin, card.Height-2*cardMargin
+	card.Shapes = []cardShape{
+		{0, 0, card.Width, card.Height, 0, cardBackground},
+		{cardMargin, cardMargin + 10, cardW, cardH, cardRadius, cardPaint{Color: "#000000", Opacity: 0.1}},
+		{cardMargin, cardMargin, cardW, cardH, cardRadius, cardPaint{Color: "#ffffff"}},
+	}
+
+	top := cardMargin + cardPadding
+	x := card.Width - cardMargin - cardPadding
+	for i, col := range titles {
+		x -= col.size
+		card.setColumn(col, x, top)
+		if i == len(titles)-1 {
+			// The title underline of the page, turned to run down the column
+			card.Shapes = append(card.Shapes, cardShape{x - 8, top, 2, 60, 1, cardAccent})
+		}
+		x -= cardColumnGap
+	}
+
+	x -= cardMetaSize
+	card.setColumn(author, x, top)
+	pillY := top + author.height() + cardMetaSize*0.5
+	card.Shapes = append(card.Shapes, cardShape{x, pillY, cardMetaSize, pillH, cardMetaSize / 2, cardBackground})
+	card.setColumn(cardColumn{runes: dynasty, size: cardPillSize, color: "#ffffff"}, x+(cardMetaSize-cardPillSize)/2, pillY+cardPillSize/2)
+	// The rule under the meta line of the page, between meta and poem
+	card.Shapes = append(card.Shapes, cardShape{x - cardColumnGap, top, 1, bodyH, 0, cardPaint{Color: "#ecf0f1"}})
+	x -= cardColumnGap * 2
+
+	for _, col := range lines {
+		x -= col.size
+		card.setColumn(col, x, top)
+		x -= cardColumnGap
+	}
+	return card
+}
+
+// setColumn places the characters of col top to bottom in a column whose
+// left edge is x.
+func (c *poemCard) setColumn(col cardColumn, x, top float64) {
+	step := col.size * cardLeading
+	for i, r := range col.runes {
+		g := cardGlyph{X: x + col.size/2, Y: top + (float64(i)+0.5)*step, Size: col.size, Color: col.color, Bold: col.bold, Kai: col.kai, Rune: r}
+		if v, ok := verticalForms[r]; ok {
+			g.Rune = v
+		} else if strings.ContainsRune(cornerPunctuation, r) {
+			g.X += col.size * 0.3
+			g.Y -= col.size * 0.3
+		}
+		c.Glyphs = append(c.Glyphs, g)
+	}
+}
+
+// glyphBaseline is where the baseline of a glyph centered at y falls; the
+// ideographic em box sits about this far above the baseline.
+func glyphBaseline(g cardGlyph) float64 {
+	return g.Y + g.Size*0.35
+}
+
+// WritePoemCardSVG writes the poem as an SVG card.
+func WritePoemCardSVG(w io.Writer, poem Poem) error {
+	card := layoutPoemCard(poem)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="%[1]g" height="%[2]g" viewBox="0 0 %[1]g %[2]g">
+<title>%[3]s</title>
+<defs>
+`, card.Width, card.Height, xmlEscape(card.Title))
+	for i, s := range card.Shapes {
+		if len(s.Paint.Gradient) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, `<linearGradient id="paint-%d" x1="0" y1="0" x2="%g" y2="%g">`, i, s.Paint.DX, s.Paint.DY)
+		for j, color := range s.Paint.Gradient {
+			fmt.Fprintf(&b, `<stop offset="%.3g" stop-color="%s"/>`, float64(j)/float64(len(s.Paint.Gradient)-1), color)
+		}
+		b.WriteString("</linearGradient>\n")
+	}
+	b.WriteString("</defs>\n")
+
+	for i, s := range card.Shapes {
+		fill := s.Paint.Color
+		if len(s.Paint.Gradient) > 0 {
+			fill = fmt.Sprintf("url(#paint-%d)", i)
+		}
+		fmt.Fprintf(&b, `<rect x="%g" y="%g" width="%g" height="%g" rx="%g" fill="%s"`, s.X, s.Y, s.W, s.H, s.R, fill)
+		if s.Paint.Opacity > 0 {
+			fmt.Fprintf(&b, ` fill-opacity="%g"`, s.Paint.Opacity)
+		}
+		b.WriteString("/>\n")
+	}
+	for _, g := range card.Glyphs {
+		fonts, weight := cardSansFonts, "normal"
+		if g.Kai {
+			fonts = cardKaiFonts
+		}
+		if g.Bold {
+			weight = "bold"
+		}
+		fmt.Fprintf(&b, `<text x="%g" y="%g" font-size="%g" font-family="%s" font-weight="%s" fill="%s" text-anchor="middle">%s</text>`+"\n",
+			g.X, glyphBaseline(g), g.Size, xmlEscape(fonts), weight, g.Color, xmlEscape(string(g.Rune)))
+	}
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
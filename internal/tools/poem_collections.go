@@ -0,0 +1,284 @@
+package tools
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	collectionsTable     = "poem_collections"
+	collectionItemsTable = "poem_collection_items"
+)
+
+// Length limits of a collection, in characters.
+const (
+	maxCollectionName        = 100
+	maxCollectionDescription = 500
+)
+
+// ErrCollectionExists is returned when a collection of the same name exists.
+var ErrCollectionExists = errors.New("a collection with this name already exists")
+
+// Collection is a reader-curated list of poems, kept in the order they were
+// added.
+type Collection struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	PoemCount   int       `json:"poemCount"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Poems       []Poem    `json:"poems,omitempty"`
+}
+
+// collectionsTables remembers the pools whose collection tables have been
+// created, like ratingsTables.
+var collectionsTables sync.Map
+
+func ensureCollectionsTables(pool *sql.DB) error {
+	if _, ok := collectionsTables.Load(pool); ok {
+		return nil
+	}
+	_, err := pool.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id %s,
+		name VARCHAR(100) NOT NULL,
+		description TEXT,
+		created_at TIMESTAMP NOT NULL
+	)`, collectionsTable, dialect.AutoIncrementKey()))
+	if err != nil {
+		return err
+	}
+	_, err = pool.Exec(`CREATE TABLE IF NOT EXISTS ` + collectionItemsTable + ` (
+		collection_id BIGINT NOT NULL,
+		poem_id BIGINT NOT NULL,
+		added_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (collection_id, poem_id)
+	)`)
+	if err != nil {
+		return err
+	}
+	collectionsTables.Store(pool, struct{}{})
+	return nil
+}
+
+// ValidateCollection trims the collection's name and description and checks
+// their lengths.
+func ValidateCollection(c *Collection) error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Description = strings.TrimSpace(c.Description)
+	if c.Name == "" {
+		return errors.New("name must not be empty")
+	}
+	if n := utf8.RuneCountInString(c.Name); n > maxCollectionName {
+		return fmt.Errorf("name is %d characters long, the limit is %d", n, maxCollectionName)
+	}
+	if n := utf8.RuneCountInString(c.Description); n > maxCollectionDescription {
+		return fmt.Errorf("description is %d characters long, the limit is %d", n, maxCollectionDescription)
+	}
+	return nil
+}
+
+// collectionPool returns the request's pool with the collection tables
+// created. Like tenantPool, the caller must release it.
+func collectionPool(info *RequestInfo) (*sql.DB, func(), error) {
+	pool, release, err := tenantPool(info)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ensureCollectionsTables(pool); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return pool, release, nil
+}
+
+// CreateCollection stores a new, empty collection and returns it.
+func CreateCollection(info *RequestInfo, c Collection) (*Collection, error) {
+	if err := ValidateCollection(&c); err != nil {
+		return nil, err
+	}
+	pool, release, err := collectionPool(info)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	var taken int
+	if err := pool.QueryRow(dialect.Rebind(`SELECT COUNT(*) FROM `+collectionsTable+` WHERE name = ?`), c.Name).Scan(&taken); err != nil {
+		return nil, err
+	}
+	if taken > 0 {
+		return nil, ErrCollectionExists
+	}
+
+	query := `INSERT INTO ` + collectionsTable + ` (name, description, created_at) VALUES (?, ?, ?)`
+	params := []interface{}{c.Name, c.Description, time.Now().UTC()}
+	var id int64
+	if _, ok := dialect.(postgresDialect); ok {
+		// lib/pq does not support LastInsertId
+		if err := pool.QueryRow(dialect.Rebind(query+" RETURNING id"), params...).Scan(&id); err != nil {
+			return nil, err
+		}
+	} else {
+		result, err := pool.Exec(dialect.Rebind(query), params...)
+		if err != nil {
+			return nil, err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return nil, err
+		}
+	}
+	return GetCollection(info, id)
+}
+
+// ListCollections returns all collections, newest first, without their
+// poems.
+func ListCollections(info *RequestInfo) ([]Collection, error) {
+	collections := []Collection{}
+	pool, release, err := collectionPool(info)
+	if err != nil {
+		return collections, err
+	}
+	defer release()
+	rows, err := pool.Query(`SELECT c.id, c.name, c.description, c.created_at, COUNT(i.poem_id) FROM ` + collectionsTable + ` c
+		LEFT JOIN ` + collectionItemsTable + ` i ON i.collection_id = c.id
+		GROUP BY c.id, c.name, c.description, c.created_at ORDER BY c.created_at DESC, c.id DESC`)
+	if err != nil {
+		return collections, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Collection
+		var description sql.NullString
+		if err := rows.Scan(&c.ID, &c.Name, &description, &c.CreatedAt, &c.PoemCount); err != nil {
+			return collections, err
+		}
+		c.Description = description.String
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// GetCollection returns the collection with the given id and its poems, or
+// nil if there is none.
+func GetCollection(info *RequestInfo, id int64) (*Collection, error) {
+	pool, release, err := collectionPool(info)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	var c Collection
+	var description sql.NullString
+	err = pool.QueryRow(dialect.Rebind(`SELECT id, name, description, created_at FROM `+collectionsTable+` WHERE id = ?`), id).
+		Scan(&c.ID, &c.Name, &description, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.Description = description.String
+
+	rows, err := pool.Query(dialect.Rebind(`SELECT poem_id FROM `+collectionItemsTable+` WHERE collection_id = ? ORDER BY added_at, poem_id`), id)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var poemID int64
+		if err := rows.Scan(&poemID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, poemID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	c.Poems = []Poem{}
+	for _, poemID := range ids {
+		poem, err := GetPoem(info, poemID)
+		if err != nil {
+			return nil, err
+		}
+		if poem != nil {
+			c.Poems = append(c.Poems, *poem)
+		}
+	}
+	c.PoemCount = len(c.Poems)
+	return &c, nil
+}
+
+// DeleteCollection deletes a collection, but not its poems, and reports
+// whether it existed.
+func DeleteCollection(info *RequestInfo, id int64) (bool, error) {
+	pool, release, err := collectionPool(info)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	result, err := pool.Exec(dialect.Rebind(`DELETE FROM `+collectionsTable+` WHERE id = ?`), id)
+	if err != nil {
+		return false, err
+	}
+	if _, err := pool.Exec(dialect.Rebind(`DELETE FROM `+collectionItemsTable+` WHERE collection_id = ?`), id); err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// AddPoemToCollection adds a poem to the end of a collection. Adding a poem
+// twice leaves it where it is. It reports false if the poem does not exist.
+func AddPoemToCollection(info *RequestInfo, collectionID, poemID int64) (bool, error) {
+	poem, err := GetPoem(info, poemID)
+	if err != nil || poem == nil {
+		return false, err
+	}
+	pool, release, err := collectionPool(info)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	var present int
+	err = pool.QueryRow(dialect.Rebind(`SELECT COUNT(*) FROM `+collectionItemsTable+` WHERE collection_id = ? AND poem_id = ?`),
+		collectionID, poemID).Scan(&present)
+	if err != nil || present > 0 {
+		return err == nil, err
+	}
+	_, err = pool.Exec(dialect.Rebind(`INSERT INTO `+collectionItemsTable+` (collection_id, poem_id, added_at) VALUES (?, ?, ?)`),
+		collectionID, poemID, time.Now().UTC())
+	return err == nil, err
+}
+
+// RemovePoemFromCollection takes a poem out of a collection and reports
+// whether it was in it.
+func RemovePoemFromCollection(info *RequestInfo, collectionID, poemID int64) (bool, error) {
+	pool, release, err := collectionPool(info)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	result, err := pool.Exec(dialect.Rebind(`DELETE FROM `+collectionItemsTable+` WHERE collection_id = ? AND poem_id = ?`),
+		collectionID, poemID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// deleteCollectionItems takes a deleted poem out of every collection.
+func deleteCollectionItems(pool *sql.DB, poemID int64) error {
+	if err := ensureCollectionsTables(pool); err != nil {
+		return err
+	}
+	_, err := pool.Exec(dialect.Rebind(`DELETE FROM `+collectionItemsTable+` WHERE poem_id = ?`), poemID)
+	return err
+}
@@ -0,0 +1,238 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Kinds of verse form.
+const (
+	FormShi = "shi" // regulated verse of fixed line count and length
+	FormCi  = "ci"  // lyrics following the template of a tune (词牌)
+)
+
+// PoemForm is a verse form with its template: the number of characters of
+// each line, stanza by stanza. Lines end at any punctuation, so a 读 marked
+// with 、 counts as a line of its own.
+type PoemForm struct {
+	Name    string  `json:"name"`
+	Kind    string  `json:"kind"`
+	Stanzas [][]int `json:"stanzas"`
+}
+
+// Characters is the length of the form in Chinese characters.
+func (f PoemForm) Characters() int {
+	n := 0
+	for _, stanza := range f.Stanzas {
+		n += sum(stanza)
+	}
+	return n
+}
+
+// Template describes the line lengths, stanzas separated by " / ".
+func (f PoemForm) Template() string {
+	stanzas := make([]string, len(f.Stanzas))
+	for i, stanza := range f.Stanzas {
+		lines := make([]string, len(stanza))
+		for j, n := range stanza {
+			lines[j] = fmt.Sprint(n)
+		}
+		stanzas[i] = strings.Join(lines, ",")
+	}
+	return strings.Join(stanzas, " / ")
+}
+
+func repeatLines(n, size int) []int {
+	lines := make([]int, n)
+	for i := range lines {
+		lines[i] = size
+	}
+	return lines
+}
+
+// poemForms are the supported forms: the four regulated shi and common
+// tunes in their standard (正体) templates.
+var poemForms = []PoemForm{
+	{Name: "五言绝句", Kind: FormShi, Stanzas: [][]int{repeatLines(4, 5)}},
+	{Name: "七言绝句", Kind: FormShi, Stanzas: [][]int{repeatLines(4, 7)}},
+	{Name: "五言律诗", Kind: FormShi, Stanzas: [][]int{repeatLines(8, 5)}},
+	{Name: "七言律诗", Kind: FormShi, Stanzas: [][]int{repeatLines(8, 7)}},
+
+	{Name: "忆江南", Kind: FormCi, Stanzas: [][]int{{3, 5, 7, 7, 5}}},
+	{Name: "如梦令", Kind: FormCi, Stanzas: [][]int{{6, 6, 5, 6, 2, 2, 6}}},
+	{Name: "长相思", Kind: FormCi, Stanzas: [][]int{{3, 3, 7, 5}, {3, 3, 7, 5}}},
+	{Name: "点绛唇", Kind: FormCi, Stanzas: [][]int{{4, 7, 4, 5}, {4, 5, 3, 4, 5}}},
+	{Name: "浣溪沙", Kind: FormCi, Stanzas: [][]int{{7, 7, 7}, {7, 7, 7}}},
+	{Name: "菩萨蛮", Kind: FormCi, Stanzas: [][]int{{7, 7, 5, 5}, {5, 5, 5, 5}}},
+	{Name: "卜算子", Kind: FormCi, Stanzas: [][]int{{5, 5, 7, 5}, {5, 5, 7, 5}}},
+	{Name: "清平乐", Kind: FormCi, Stanzas: [][]int{{4, 5, 7, 6}, {6, 6, 6, 6}}},
+	{Name: "西江月", Kind: FormCi, Stanzas: [][]int{{6, 6, 7, 6}, {6, 6, 7, 6}}},
+	{Name: "鹧鸪天", Kind: FormCi, Stanzas: [][]int{{7, 7, 7, 7}, {3, 3, 7, 7, 7}}},
+	{Name: "虞美人", Kind: FormCi, Stanzas: [][]int{{7, 5, 7, 9}, {7, 5, 7, 9}}},
+	{Name: "蝶恋花", Kind: FormCi, Stanzas: [][]int{{7, 4, 5, 7, 7}, {7, 4, 5, 7, 7}}},
+	{Name: "破阵子", Kind: FormCi, Stanzas: [][]int{{6, 6, 7, 7, 5}, {6, 6, 7, 7, 5}}},
+	{Name: "渔家傲", Kind: FormCi, Stanzas: [][]int{{7, 7, 7, 3, 7}, {7, 7, 7, 3, 7}}},
+	{Name: "江城子", Kind: FormCi, Stanzas: [][]int{{7, 3, 3, 4, 5, 7, 3, 3}, {7, 3, 3, 4, 5, 7, 3, 3}}},
+	{Name: "满江红", Kind: FormCi, Stanzas: [][]int{{4, 3, 4, 3, 4, 4, 7, 7, 3, 5, 3}, {3, 3, 3, 3, 3, 6, 7, 7, 3, 5, 3}}},
+	{Name: "水调歌头", Kind: FormCi, Stanzas: [][]int{{5, 5, 6, 5, 6, 6, 5, 5, 5}, {3, 3, 3, 4, 7, 6, 6, 5, 5, 5}}},
+	{Name: "念奴娇", Kind: FormCi, Stanzas: [][]int{{4, 3, 6, 4, 3, 6, 4, 4, 5, 4, 6}, {6, 5, 4, 4, 3, 6, 4, 5, 4, 4, 6}}},
+}
+
+// formAliases are the short names the shi forms often go by.
+var formAliases = map[string]string{
+	"五绝": "五言绝句", "七绝": "七言绝句", "五律": "五言律诗", "七律": "七言律诗",
+}
+
+// LookupPoemForm finds a form by name, accepting the short shi names and
+// tunes written as 《如梦令》 or 词牌·如梦令.
+func LookupPoemForm(name string) (PoemForm, bool) {
+	name = strings.Trim(strings.TrimSpace(name), "《》")
+	for _, prefix := range []string{"词牌·", "词牌", "词·"} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	if alias, ok := formAliases[name]; ok {
+		name = alias
+	}
+	for _, form := range poemForms {
+		if form.Name == name {
+			return form, true
+		}
+	}
+	return PoemForm{}, false
+}
+
+// PoemForms returns the supported forms, shi first.
+func PoemForms() []PoemForm {
+	return append([]PoemForm(nil), poemForms...)
+}
+
+// PoemFormNames lists the names of the supported forms.
+func PoemFormNames() []string {
+	names := make([]string, len(poemForms))
+	for i, form := range poemForms {
+		names[i] = form.Name
+	}
+	return names
+}
+
+// shiForm is the regulated form a poem of the given shape is written in.
+func shiForm(lines, size int) string {
+	for _, form := range poemForms {
+		if form.Kind == FormShi && len(form.Stanzas[0]) == lines && form.Stanzas[0][0] == size {
+			return form.Name
+		}
+	}
+	return ""
+}
+
+// PoemStanzas splits content into stanzas at blank rows, each a list of
+// its trimmed rows.
+func PoemStanzas(content string) [][]string {
+	var stanzas [][]string
+	var rows []string
+	for _, row := range strings.Split(content, "\n") {
+		if row = strings.TrimSpace(row); row != "" {
+			rows = append(rows, row)
+			continue
+		}
+		if len(rows) > 0 {
+			stanzas = append(stanzas, rows)
+			rows = nil
+		}
+	}
+	if len(rows) > 0 {
+		stanzas = append(stanzas, rows)
+	}
+	return stanzas
+}
+
+// checkForm holds the poem to the template of its form. Shi are checked
+// line by line. Ci must have the tune's stanzas, each of the right length,
+// and may only split the template's lines (at a 读) or only join them.
+func checkForm(form PoemForm, content string) []string {
+	if form.Kind == FormShi {
+		want := form.Stanzas[0]
+		lines := poemLines(content)
+		lengths := make([]int, len(lines))
+		ok := len(lines) == len(want)
+		for i, line := range lines {
+			lengths[i] = hanCount(line)
+			if lengths[i] != want[0] {
+				ok = false
+			}
+		}
+		if ok {
+			return nil
+		}
+		return []string{fmt.Sprintf("a %s has %d lines of %d characters, got %d lines of %v", form.Name, len(want), want[0], len(lines), lengths)}
+	}
+
+	stanzas := PoemStanzas(content)
+	if len(stanzas) != len(form.Stanzas) {
+		return []string{fmt.Sprintf("%s has %d stanzas separated by a blank line, got %d; its template is %s",
+			form.Name, len(form.Stanzas), len(stanzas), form.Template())}
+	}
+	var problems []string
+	for i, stanza := range stanzas {
+		var lengths []int
+		for _, line := range poemLines(strings.Join(stanza, "\n")) {
+			lengths = append(lengths, hanCount(line))
+		}
+		if !matchesTemplate(lengths, form.Stanzas[i]) {
+			problems = append(problems, fmt.Sprintf("stanza %d of %s must have lines of %v characters (%d in all), got %v",
+				i+1, form.Name, form.Stanzas[i], sum(form.Stanzas[i]), lengths))
+		}
+	}
+	return problems
+}
+
+// matchesTemplate reports whether lines have the template's length and
+// every line break of one falls on a line break of the other.
+func matchesTemplate(lines, template []int) bool {
+	if sum(lines) != sum(template) {
+		return false
+	}
+	got, want := lineBreaks(lines), lineBreaks(template)
+	subset := func(a, b map[int]bool) bool {
+		for k := range a {
+			if !b[k] {
+				return false
+			}
+		}
+		return true
+	}
+	return subset(got, want) || subset(want, got)
+}
+
+func lineBreaks(lines []int) map[int]bool {
+	breaks := make(map[int]bool, len(lines))
+	n := 0
+	for _, line := range lines {
+		n += line
+		breaks[n] = true
+	}
+	return breaks
+}
+
+func sum(values []int) int {
+	n := 0
+	for _, v := range values {
+		n += v
+	}
+	return n
+}
+
+// PoemFormGuide describes the forms for the /generate prompt: the chosen
+// one, or every form when the choice is empty or random.
+func PoemFormGuide(choice string) string {
+	if form, ok := LookupPoemForm(choice); ok {
+		return fmt.Sprintf("Write a %s and set \"form\" to %q. Its template, in characters per line with stanzas separated by /, is %s (%d characters).\n",
+			form.Name, form.Name, form.Template(), form.Characters())
+	}
+	var b strings.Builder
+	b.WriteString("Choose a form that suits the poet and set \"form\" to its name. Characters per line, stanzas separated by /:\n")
+	for _, form := range PoemForms() {
+		fmt.Fprintf(&b, "- %s: %s\n", form.Name, form.Template())
+	}
+	return b.String()
+}
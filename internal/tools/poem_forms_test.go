@@ -0,0 +1,169 @@
+package tools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// formContent writes a poem that follows the stanzas line for line.
+func formContent(stanzas [][]int) string {
+	rows := make([]string, len(stanzas))
+	for i, stanza := range stanzas {
+		var row strings.Builder
+		for j, n := range stanza {
+			row.WriteString(strings.Repeat("月", n))
+			if j == len(stanza)-1 {
+				row.WriteString("。")
+			} else {
+				row.WriteString("，")
+			}
+		}
+		rows[i] = row.String()
+	}
+	return strings.Join(rows, "\n\n")
+}
+
+// withStanza copies stanzas with stanza i replaced.
+func withStanza(stanzas [][]int, i int, lines []int) [][]int {
+	out := append([][]int(nil), stanzas...)
+	out[i] = lines
+	return out
+}
+
+func TestCheckFormCiTemplates(t *testing.T) {
+	ci := 0
+	for _, form := range poemForms {
+		if form.Kind != FormCi {
+			continue
+		}
+		ci++
+		t.Run(form.Name, func(t *testing.T) {
+			last := len(form.Stanzas) - 1
+			stanza := form.Stanzas[last]
+
+			joined := append([]int{stanza[0] + stanza[1]}, stanza[2:]...)
+			split := append([]int{1, stanza[0] - 1}, stanza[1:]...)
+			short := append(append([]int(nil), stanza[:len(stanza)-1]...), stanza[len(stanza)-1]-1)
+			shifted := append([]int{stanza[0] + 1, stanza[1] - 1}, stanza[2:]...)
+
+			tests := []struct {
+				name    string
+				stanzas [][]int
+				ok      bool
+			}{
+				{"template", form.Stanzas, true},
+				{"lines joined", withStanza(form.Stanzas, last, joined), true},
+				{"line split at a du", withStanza(form.Stanzas, last, split), true},
+				{"character missing", withStanza(form.Stanzas, last, short), false},
+				{"line break moved", withStanza(form.Stanzas, last, shifted), false},
+				{"extra stanza", append(append([][]int(nil), form.Stanzas...), stanza), false},
+			}
+			for _, tt := range tests {
+				problems := checkForm(form, formContent(tt.stanzas))
+				if ok := len(problems) == 0; ok != tt.ok {
+					t.Errorf("%s: checkForm(%v) = %q, want ok %v", tt.name, tt.stanzas, problems, tt.ok)
+				}
+			}
+			if len(form.Stanzas) > 1 {
+				merged := strings.ReplaceAll(formContent(form.Stanzas), "\n\n", "\n")
+				if problems := checkForm(form, merged); len(problems) != 1 || !strings.Contains(problems[0], "stanzas") {
+					t.Errorf("stanzas without a blank row: checkForm() = %q, want a stanza count problem", problems)
+				}
+			}
+		})
+	}
+	if ci != 18 {
+		t.Errorf("found %d ci templates, want 18", ci)
+	}
+}
+
+func TestCheckFormShi(t *testing.T) {
+	wujue, _ := LookupPoemForm("五言绝句")
+	qilv, _ := LookupPoemForm("七律")
+	tests := []struct {
+		name    string
+		form    PoemForm
+		content string
+		ok      bool
+	}{
+		{"wujue", wujue, jingYeSi, true},
+		{"wujue on separate rows", wujue, "床前明月光\n疑是地上霜\n\n举头望明月\n低头思故乡", true},
+		{"wujue with a long line", wujue, "床前明月光，疑是地上霜。\n举头望明月，低头思故乡啊。", false},
+		{"wujue with too few lines", wujue, "床前明月光，疑是地上霜。", false},
+		{"qilv", qilv, formContent([][]int{repeatLines(8, 7)}), true},
+		{"qilv written as qijue", qilv, formContent([][]int{repeatLines(4, 7)}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if problems := checkForm(tt.form, tt.content); (len(problems) == 0) != tt.ok {
+				t.Errorf("checkForm(%s) = %q, want ok %v", tt.form.Name, problems, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMatchesTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []int
+		template []int
+		want     bool
+	}{
+		{"equal", []int{3, 5, 7}, []int{3, 5, 7}, true},
+		{"joined", []int{8, 7}, []int{3, 5, 7}, true},
+		{"split", []int{3, 2, 3, 7}, []int{3, 5, 7}, true},
+		{"all in one", []int{15}, []int{3, 5, 7}, true},
+		{"too short", []int{3, 5, 6}, []int{3, 5, 7}, false},
+		{"too long", []int{3, 5, 8}, []int{3, 5, 7}, false},
+		{"moved break", []int{4, 4, 7}, []int{3, 5, 7}, false},
+		{"joined and split", []int{8, 3, 4}, []int{3, 5, 7}, false},
+		{"empty", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesTemplate(tt.lines, tt.template); got != tt.want {
+				t.Errorf("matchesTemplate(%v, %v) = %v, want %v", tt.lines, tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupPoemForm(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"如梦令", "如梦令"},
+		{"《如梦令》", "如梦令"},
+		{"词牌·如梦令", "如梦令"},
+		{" 水调歌头 ", "水调歌头"},
+		{"七绝", "七言绝句"},
+		{"五言律诗", "五言律诗"},
+		{"十四行诗", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		form, ok := LookupPoemForm(tt.name)
+		if form.Name != tt.want || ok != (tt.want != "") {
+			t.Errorf("LookupPoemForm(%q) = %q, %v, want %q", tt.name, form.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestPoemStanzas(t *testing.T) {
+	tests := []struct {
+		content string
+		want    [][]string
+	}{
+		{"", nil},
+		{"a\nb", [][]string{{"a", "b"}}},
+		{"\n a \n\n\n b\nc \n", [][]string{{"a"}, {"b", "c"}}},
+		{"a\n  \nb", [][]string{{"a"}, {"b"}}},
+	}
+	for _, tt := range tests {
+		if got := PoemStanzas(tt.content); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("PoemStanzas(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
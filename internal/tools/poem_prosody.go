@@ -0,0 +1,104 @@
+package tools
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+)
+
+const prosodyTable = "nokode_poem_prosody"
+
+// prosodyTables remembers the pools whose prosody table has been created,
+// since every tenant keeps its scores next to its own poems.
+var prosodyTables sync.Map
+
+func ensureProsodyTable(pool *sql.DB) error {
+	if _, ok := prosodyTables.Load(pool); ok {
+		return nil
+	}
+	_, err := pool.Exec(`CREATE TABLE IF NOT EXISTS ` + prosodyTable + ` (
+		poem_id BIGINT NOT NULL PRIMARY KEY,
+		form VARCHAR(16) NOT NULL,
+		length_score INT NOT NULL,
+		tone_score INT NOT NULL,
+		rhyme_score INT NOT NULL,
+		score INT NOT NULL,
+		rhyme_group VARCHAR(32),
+		notes TEXT,
+		created_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	prosodyTables.Store(pool, struct{}{})
+	return nil
+}
+
+// saveProsody stores the score of a poem, replacing any earlier one.
+func saveProsody(pool *sql.DB, poemID int64, s *ProsodyScore) error {
+	if err := ensureProsodyTable(pool); err != nil {
+		return err
+	}
+	tx, err := pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(dialect.Rebind(`DELETE FROM `+prosodyTable+` WHERE poem_id = ?`), poemID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(dialect.Rebind(`INSERT INTO `+prosodyTable+` (poem_id, form, length_score, tone_score, rhyme_score, score, rhyme_group, notes, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		poemID, s.Form, s.Length, s.Tone, s.Rhyme, s.Score, s.RhymeGroup, strings.Join(s.Notes, "\n"), time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadProsody returns the stored scores of the given poems by poem id.
+func loadProsody(pool *sql.DB, ids []int64) (map[int64]*ProsodyScore, error) {
+	scores := make(map[int64]*ProsodyScore)
+	if len(ids) == 0 {
+		return scores, nil
+	}
+	if err := ensureProsodyTable(pool); err != nil {
+		return nil, err
+	}
+
+	params := make([]interface{}, len(ids))
+	for i, id := range ids {
+		params[i] = id
+	}
+	rows, err := pool.Query(dialect.Rebind(`SELECT poem_id, form, length_score, tone_score, rhyme_score, score, rhyme_group, notes FROM `+prosodyTable+`
+		WHERE poem_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`), params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var group, notes sql.NullString
+		s := &ProsodyScore{}
+		if err := rows.Scan(&id, &s.Form, &s.Length, &s.Tone, &s.Rhyme, &s.Score, &group, &notes); err != nil {
+			return nil, err
+		}
+		s.RhymeGroup = group.String
+		if notes.String != "" {
+			s.Notes = strings.Split(notes.String, "\n")
+		}
+		scores[id] = s
+	}
+	return scores, rows.Err()
+}
+
+func deleteProsody(pool *sql.DB, poemID int64) error {
+	if err := ensureProsodyTable(pool); err != nil {
+		return err
+	}
+	_, err := pool.Exec(dialect.Rebind(`DELETE FROM `+prosodyTable+` WHERE poem_id = ?`), poemID)
+	return err
+}
@@ -0,0 +1,237 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ratingsTable = "poem_ratings"
+
+// Ratings run from 1 to 5 stars.
+const (
+	minRating = 1
+	maxRating = 5
+)
+
+// minExampleRating is the average rating a poem needs to be shown to the
+// model as an example.
+const minExampleRating = 4.0
+
+// RatingSummary is the feedback readers have given a poem.
+type RatingSummary struct {
+	Average   float64 `json:"average"`
+	Count     int     `json:"count"`
+	Favorites int     `json:"favorites"`
+}
+
+// ratingsTables remembers the pools whose ratings table has been created,
+// like prosodyTables.
+var ratingsTables sync.Map
+
+func ensureRatingsTable(pool *sql.DB) error {
+	if _, ok := ratingsTables.Load(pool); ok {
+		return nil
+	}
+	_, err := pool.Exec(`CREATE TABLE IF NOT EXISTS ` + ratingsTable + ` (
+		poem_id BIGINT NOT NULL,
+		voter VARCHAR(64) NOT NULL,
+		rating INT NOT NULL,
+		favorite INT NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (poem_id, voter)
+	)`)
+	if err != nil {
+		return err
+	}
+	ratingsTables.Store(pool, struct{}{})
+	return nil
+}
+
+// RatePoem records the voter's rating of a poem, replacing their earlier
+// one, and returns the poem's new summary. It returns nil if the poem does
+// not exist.
+func RatePoem(info *RequestInfo, poemID int64, voter string, rating int) (*RatingSummary, error) {
+	if rating < minRating || rating > maxRating {
+		return nil, fmt.Errorf("rating must be between %d and %d", minRating, maxRating)
+	}
+	return updateFeedback(info, poemID, voter, "rating", rating)
+}
+
+// FavoritePoem marks or unmarks a poem as one of the voter's favorites and
+// returns the poem's new summary. It returns nil if the poem does not exist.
+func FavoritePoem(info *RequestInfo, poemID int64, voter string, favorite bool) (*RatingSummary, error) {
+	value := 0
+	if favorite {
+		value = 1
+	}
+	return updateFeedback(info, poemID, voter, "favorite", value)
+}
+
+// updateFeedback sets the rating or favorite column of the voter's row for
+// a poem with a single upsert on (poem_id, voter), leaving the other column
+// as it was. Rows left with neither a rating nor a favorite are removed.
+func updateFeedback(info *RequestInfo, poemID int64, voter, column string, value int) (*RatingSummary, error) {
+	poem, err := GetPoem(info, poemID)
+	if err != nil || poem == nil {
+		return nil, err
+	}
+	pool, release, err := tenantPool(info)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err := ensureRatingsTable(pool); err != nil {
+		return nil, err
+	}
+
+	values := map[string]int{"rating": 0, "favorite": 0}
+	values[column] = value
+	upsert := dialect.Upsert(ratingsTable, []string{"poem_id", "voter", "rating", "favorite", "updated_at"},
+		[]string{"poem_id", "voter"}, []string{column, "updated_at"})
+	if _, err := pool.Exec(dialect.Rebind(upsert), poemID, voter, values["rating"], values["favorite"], time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	if value == 0 {
+		_, err := pool.Exec(dialect.Rebind(`DELETE FROM `+ratingsTable+` WHERE poem_id = ? AND voter = ? AND rating = 0 AND favorite = 0`), poemID, voter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	summaries, err := loadRatings(pool, []int64{poemID})
+	if err != nil {
+		return nil, err
+	}
+	if s := summaries[poemID]; s != nil {
+		return s, nil
+	}
+	return &RatingSummary{}, nil
+}
+
+// loadRatings returns the rating summaries of the given poems by poem id.
+// Poems nobody has rated or favorited are left out.
+func loadRatings(pool *sql.DB, ids []int64) (map[int64]*RatingSummary, error) {
+	summaries := make(map[int64]*RatingSummary)
+	if len(ids) == 0 {
+		return summaries, nil
+	}
+	if err := ensureRatingsTable(pool); err != nil {
+		return nil, err
+	}
+
+	params := make([]interface{}, len(ids))
+	for i, id := range ids {
+		params[i] = id
+	}
+	rows, err := pool.Query(dialect.Rebind(`SELECT poem_id, SUM(rating), SUM(CASE WHEN rating > 0 THEN 1 ELSE 0 END), SUM(favorite) FROM `+ratingsTable+`
+		WHERE poem_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`) GROUP BY poem_id`), params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, total int64
+		s := &RatingSummary{}
+		if err := rows.Scan(&id, &total, &s.Count, &s.Favorites); err != nil {
+			return nil, err
+		}
+		if s.Count > 0 {
+			s.Average = float64(total*10/int64(s.Count)) / 10
+		}
+		summaries[id] = s
+	}
+	return summaries, rows.Err()
+}
+
+func deleteRatings(pool *sql.DB, poemID int64) error {
+	if err := ensureRatingsTable(pool); err != nil {
+		return err
+	}
+	_, err := pool.Exec(dialect.Rebind(`DELETE FROM `+ratingsTable+` WHERE poem_id = ?`), poemID)
+	return err
+}
+
+// TopRatedPoems returns up to limit of the best rated poems written for the
+// given poet_preference, or for any preference when it is empty or
+// "random". Only poems averaging at least minExampleRating are returned,
+// and only those the model generated or an admin stored: poems the model
+// inserted with the database tool may carry text from any request.
+func TopRatedPoems(info *RequestInfo, preference string, limit int) ([]Poem, error) {
+	exists, err := poemsTableExists(info)
+	if err != nil || !exists || limit <= 0 {
+		return nil, err
+	}
+	pool, release, err := tenantPool(info)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err := ensureRatingsTable(pool); err != nil {
+		return nil, err
+	}
+	if err := ensureSourcesTable(pool); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT r.poem_id FROM ` + ratingsTable + ` r JOIN poems p ON p.id = r.poem_id
+		JOIN ` + sourcesTable + ` s ON s.poem_id = r.poem_id AND s.source IN (?, ?) WHERE r.rating > 0`
+	params := []interface{}{PoemSourceGenerated, PoemSourceAdmin}
+	if preference != "" && preference != RandomPoetStyle {
+		query += ` AND p.user_preference = ?`
+		params = append(params, preference)
+	}
+	query += fmt.Sprintf(` GROUP BY r.poem_id HAVING AVG(r.rating) >= %.1f ORDER BY AVG(r.rating) DESC, COUNT(*) DESC, r.poem_id DESC LIMIT %d`,
+		minExampleRating, limit)
+
+	rows, err := pool.Query(dialect.Rebind(query), params...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var poems []Poem
+	for _, id := range ids {
+		poem, err := GetPoem(info, id)
+		if err != nil {
+			return nil, err
+		}
+		if poem != nil {
+			poems = append(poems, *poem)
+		}
+	}
+	return poems, nil
+}
+
+// PoemExamplesPrompt formats top rated poems as few-shot examples for the
+// /generate prompt. It returns "" when there are none.
+func PoemExamplesPrompt(poems []Poem) string {
+	if len(poems) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Readers rated these earlier poems highly. Match their quality and voice, but write something new; do not reuse their lines:\n")
+	for _, poem := range poems {
+		fmt.Fprintf(&b, "\n《%s》 %s", poem.Title, poem.Author)
+		if poem.Rating != nil {
+			fmt.Fprintf(&b, " (%.1f stars from %d ratings)", poem.Rating.Average, poem.Rating.Count)
+		}
+		b.WriteString("\n" + poem.Content + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package tools
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+)
+
+const similarityTable = "nokode_poem_similarity"
+
+// similarityTables remembers the pools whose similarity table has been
+// created, like prosodyTables.
+var similarityTables sync.Map
+
+func ensureSimilarityTable(pool *sql.DB) error {
+	if _, ok := similarityTables.Load(pool); ok {
+		return nil
+	}
+	_, err := pool.Exec(`CREATE TABLE IF NOT EXISTS ` + similarityTable + ` (
+		poem_id BIGINT NOT NULL PRIMARY KEY,
+		fingerprint VARCHAR(64) NOT NULL,
+		score INT NOT NULL,
+		match_source VARCHAR(16),
+		match_id BIGINT,
+		match_title VARCHAR(100),
+		match_author VARCHAR(100),
+		created_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	similarityTables.Store(pool, struct{}{})
+	return nil
+}
+
+// saveSimilarity records the closest match found for a poem when it was
+// created, replacing any earlier one.
+func saveSimilarity(pool *sql.DB, poemID int64, m *SimilarityMatch) error {
+	if err := ensureSimilarityTable(pool); err != nil {
+		return err
+	}
+	tx, err := pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(dialect.Rebind(`DELETE FROM `+similarityTable+` WHERE poem_id = ?`), poemID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(dialect.Rebind(`INSERT INTO `+similarityTable+` (poem_id, fingerprint, score, match_source, match_id, match_title, match_author, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		poemID, m.Fingerprint, m.Score, m.Source, m.PoemID, m.Title, m.Author, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loadSimilarity returns the recorded matches of the given poems by poem id.
+func loadSimilarity(pool *sql.DB, ids []int64) (map[int64]*SimilarityMatch, error) {
+	matches := make(map[int64]*SimilarityMatch)
+	if len(ids) == 0 {
+		return matches, nil
+	}
+	if err := ensureSimilarityTable(pool); err != nil {
+		return nil, err
+	}
+
+	params := make([]interface{}, len(ids))
+	for i, id := range ids {
+		params[i] = id
+	}
+	rows, err := pool.Query(dialect.Rebind(`SELECT poem_id, fingerprint, score, match_source, match_id, match_title, match_author FROM `+similarityTable+`
+		WHERE poem_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`), params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var source, title, author sql.NullString
+		var matchID sql.NullInt64
+		m := &SimilarityMatch{}
+		if err := rows.Scan(&id, &m.Fingerprint, &m.Score, &source, &matchID, &title, &author); err != nil {
+			return nil, err
+		}
+		m.Source, m.PoemID, m.Title, m.Author = source.String, matchID.Int64, title.String, author.String
+		matches[id] = m
+	}
+	return matches, rows.Err()
+}
+
+func deleteSimilarity(pool *sql.DB, poemID int64) error {
+	if err := ensureSimilarityTable(pool); err != nil {
+		return err
+	}
+	_, err := pool.Exec(dialect.Rebind(`DELETE FROM `+similarityTable+` WHERE poem_id = ?`), poemID)
+	return err
+}
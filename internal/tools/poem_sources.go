@@ -0,0 +1,58 @@
+package tools
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+)
+
+const sourcesTable = "nokode_poem_sources"
+
+// Poem sources recorded by CreatePoem. Poems the model inserts with the
+// database tool have no source.
+const (
+	// PoemSourceGenerated marks poems the model wrote for /generate or a
+	// batch job.
+	PoemSourceGenerated = "generated"
+	// PoemSourceAdmin marks poems stored through the API with the admin
+	// token.
+	PoemSourceAdmin = "admin"
+)
+
+// sourcesTables remembers the pools whose sources table has been created,
+// like prosodyTables.
+var sourcesTables sync.Map
+
+func ensureSourcesTable(pool *sql.DB) error {
+	if _, ok := sourcesTables.Load(pool); ok {
+		return nil
+	}
+	_, err := pool.Exec(`CREATE TABLE IF NOT EXISTS ` + sourcesTable + ` (
+		poem_id BIGINT NOT NULL PRIMARY KEY,
+		source VARCHAR(16) NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	sourcesTables.Store(pool, struct{}{})
+	return nil
+}
+
+// saveSource records where a poem came from.
+func saveSource(pool *sql.DB, poemID int64, source string) error {
+	if err := ensureSourcesTable(pool); err != nil {
+		return err
+	}
+	_, err := pool.Exec(dialect.Rebind(dialect.Upsert(sourcesTable, []string{"poem_id", "source", "created_at"}, []string{"poem_id"}, []string{"source"})),
+		poemID, source, time.Now().UTC())
+	return err
+}
+
+func deleteSource(pool *sql.DB, poemID int64) error {
+	if err := ensureSourcesTable(pool); err != nil {
+		return err
+	}
+	_, err := pool.Exec(dialect.Rebind(`DELETE FROM `+sourcesTable+` WHERE poem_id = ?`), poemID)
+	return err
+}
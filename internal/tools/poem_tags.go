@@ -0,0 +1,288 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const tagsTable = "poem_tags"
+
+// Tag limits. Tags are short themes such as 月, 酒, 思乡 or 秋.
+const (
+	maxPoemTags  = 8
+	maxTagLength = 20
+)
+
+// maxPromptTaggedPoems bounds the poem ids listed in the prompt for a
+// filtered poem list.
+const maxPromptTaggedPoems = 50
+
+// TagCount is a tag and the number of poems carrying it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// tagsTables remembers the pools whose tags table has been created, like
+// ratingsTables.
+var tagsTables sync.Map
+
+func ensureTagsTable(pool *sql.DB) error {
+	if _, ok := tagsTables.Load(pool); ok {
+		return nil
+	}
+	_, err := pool.Exec(`CREATE TABLE IF NOT EXISTS ` + tagsTable + ` (
+		poem_id BIGINT NOT NULL,
+		tag VARCHAR(50) NOT NULL,
+		PRIMARY KEY (poem_id, tag)
+	)`)
+	if err != nil {
+		return err
+	}
+	tagsTables.Store(pool, struct{}{})
+	return nil
+}
+
+// normalizeTags trims the tags, drops a leading '#', empty tags and
+// duplicates, and returns a problem for each tag that is too long.
+func normalizeTags(tags []string) ([]string, []string) {
+	var result, problems []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(tag), "#＃"))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if n := utf8.RuneCountInString(tag); n > maxTagLength {
+			problems = append(problems, fmt.Sprintf("tag %q is %d characters long, the limit is %d", tag, n, maxTagLength))
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result, problems
+}
+
+// TagPoem adds tags to a poem, or with replace set replaces its tags, and
+// returns the poem's tags. It returns nil if the poem does not exist, and
+// a *PoemValidationError for tags that are too long or too many.
+func TagPoem(info *RequestInfo, poemID int64, tags []string, replace bool) ([]string, error) {
+	tags, problems := normalizeTags(tags)
+	if len(problems) > 0 {
+		return nil, &PoemValidationError{Problems: problems}
+	}
+	poem, err := GetPoem(info, poemID)
+	if err != nil || poem == nil {
+		return nil, err
+	}
+	pool, release, err := tenantPool(info)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err := ensureTagsTable(pool); err != nil {
+		return nil, err
+	}
+
+	merged := tags
+	if !replace {
+		merged, _ = normalizeTags(append(poem.Tags, tags...))
+	}
+	if len(merged) > maxPoemTags {
+		return nil, &PoemValidationError{Problems: []string{fmt.Sprintf("tags has %d entries, the limit is %d", len(merged), maxPoemTags)}}
+	}
+
+	tx, err := pool.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(dialect.Rebind(`DELETE FROM `+tagsTable+` WHERE poem_id = ?`), poemID); err != nil {
+		return nil, err
+	}
+	for _, tag := range merged {
+		if _, err := tx.Exec(dialect.Rebind(`INSERT INTO `+tagsTable+` (poem_id, tag) VALUES (?, ?)`), poemID, tag); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(merged)
+	if merged == nil {
+		merged = []string{}
+	}
+	return merged, nil
+}
+
+func saveTags(pool *sql.DB, poemID int64, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	if err := ensureTagsTable(pool); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := pool.Exec(dialect.Rebind(`INSERT INTO `+tagsTable+` (poem_id, tag) VALUES (?, ?)`), poemID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadTags returns the tags of the given poems by poem id, sorted.
+func loadTags(pool *sql.DB, ids []int64) (map[int64][]string, error) {
+	tags := make(map[int64][]string)
+	if len(ids) == 0 {
+		return tags, nil
+	}
+	if err := ensureTagsTable(pool); err != nil {
+		return nil, err
+	}
+
+	params := make([]interface{}, len(ids))
+	for i, id := range ids {
+		params[i] = id
+	}
+	rows, err := pool.Query(dialect.Rebind(`SELECT poem_id, tag FROM `+tagsTable+`
+		WHERE poem_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`) ORDER BY poem_id, tag`), params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+	return tags, rows.Err()
+}
+
+func deleteTags(pool *sql.DB, poemID int64) error {
+	if err := ensureTagsTable(pool); err != nil {
+		return err
+	}
+	_, err := pool.Exec(dialect.Rebind(`DELETE FROM `+tagsTable+` WHERE poem_id = ?`), poemID)
+	return err
+}
+
+// taggedPoemIDs returns the ids of the newest limit poems carrying tag.
+func taggedPoemIDs(pool *sql.DB, tag string, limit int) ([]int64, error) {
+	if err := ensureTagsTable(pool); err != nil {
+		return nil, err
+	}
+	rows, err := pool.Query(dialect.Rebind(fmt.Sprintf(`SELECT poem_id FROM %s WHERE tag = ? ORDER BY poem_id DESC LIMIT %d`,
+		tagsTable, limit)), tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ListTags returns the tags in use, most used first.
+func ListTags(info *RequestInfo) ([]TagCount, error) {
+	tags := []TagCount{}
+	pool, release, err := tenantPool(info)
+	if err != nil {
+		return tags, err
+	}
+	defer release()
+	if err := ensureTagsTable(pool); err != nil {
+		return tags, err
+	}
+	rows, err := pool.Query(`SELECT tag, COUNT(*) FROM ` + tagsTable + ` GROUP BY tag ORDER BY COUNT(*) DESC, tag`)
+	if err != nil {
+		return tags, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t TagCount
+		if err := rows.Scan(&t.Tag, &t.Count); err != nil {
+			return tags, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// PoemTagsPrompt describes the stored tags for GET /poems pages, which the
+// model cannot query itself: the tags in use, and the poems carrying tag
+// when the list is filtered, or the tags of poem poemID on its page.
+func PoemTagsPrompt(info *RequestInfo, tag string, poemID int64) (string, error) {
+	pool, release, err := tenantPool(info)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var b strings.Builder
+	if poemID != 0 {
+		tags, err := loadTags(pool, []int64{poemID})
+		if err != nil {
+			return "", err
+		}
+		if len(tags[poemID]) == 0 {
+			return "", nil
+		}
+		fmt.Fprintf(&b, "Tags of this poem: %s. Show them as links to /poems?tag=<tag>.\n", strings.Join(tags[poemID], ", "))
+		return b.String(), nil
+	}
+
+	counts, err := ListTags(info)
+	if err != nil {
+		return "", err
+	}
+	if len(counts) == 0 {
+		return "", nil
+	}
+	b.WriteString("Tags in use (poem count):")
+	tagged := 0
+	for i, t := range counts {
+		if t.Tag == tag {
+			tagged = t.Count
+		}
+		if i < 50 {
+			fmt.Fprintf(&b, " %s (%d)", t.Tag, t.Count)
+		}
+	}
+	b.WriteString(". Show them as links to /poems?tag=<tag>.\n")
+	if tag != "" {
+		ids, err := taggedPoemIDs(pool, tag, maxPromptTaggedPoems)
+		if err != nil {
+			return "", err
+		}
+		if len(ids) == 0 {
+			fmt.Fprintf(&b, "No poem is tagged %q: say so instead of listing poems.\n", tag)
+			return b.String(), nil
+		}
+		strs := make([]string, len(ids))
+		for i, id := range ids {
+			strs[i] = fmt.Sprint(id)
+		}
+		fmt.Fprintf(&b, "The list is filtered by the tag %q: show only the poems with these ids: %s.\n", tag, strings.Join(strs, ", "))
+		if tagged > len(ids) {
+			fmt.Fprintf(&b, "These are the newest %d of the %d poems tagged %q; say that older ones are not shown.\n", len(ids), tagged, tag)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,260 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Length limits in characters, kept within the column sizes of the poems
+// table in the prompt.
+const (
+	maxPoemTitle          = 50
+	maxPoemAuthor         = 50
+	maxPoemUserPreference = 100
+	maxPoemContent        = 2000
+)
+
+var dynastyNames = map[string]string{
+	"tang": "tang", "唐": "tang", "唐代": "tang", "唐朝": "tang",
+	"song": "song", "宋": "song", "宋代": "song", "宋朝": "song",
+}
+
+// PoemValidationError lists everything wrong with a poem at once, so a
+// repair prompt can ask for all of it to be fixed in one go.
+type PoemValidationError struct {
+	Problems []string
+}
+
+func (e *PoemValidationError) Error() string {
+	return "invalid poem: " + strings.Join(e.Problems, "; ")
+}
+
+// ParseGeneratedPoem decodes the JSON poem the model returns for POST
+// /generate, tolerating markdown fences and prose around the object, and
+// validates it.
+func ParseGeneratedPoem(content string) (*Poem, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(stripCodeFence(content)), &fields); err != nil {
+		return nil, &PoemValidationError{Problems: []string{"response is not a JSON object: " + err.Error()}}
+	}
+
+	var problems []string
+	bad := map[string]bool{}
+	str := func(field string) string {
+		switch v := fields[field].(type) {
+		case string:
+			return v
+		case nil:
+			problems = append(problems, fmt.Sprintf("missing field %q", field))
+		default:
+			problems = append(problems, fmt.Sprintf("field %q must be a string, got %s", field, jsonType(v)))
+		}
+		bad[field] = true
+		return ""
+	}
+	poem := &Poem{
+		Title:          str("title"),
+		Author:         str("author"),
+		Dynasty:        str("dynasty"),
+		Content:        str("content"),
+		UserPreference: str("user_preference"),
+	}
+	// Form and tags are optional
+	switch v := fields["form"].(type) {
+	case nil:
+	case string:
+		poem.Form = v
+	default:
+		problems = append(problems, fmt.Sprintf("field \"form\" must be a string, got %s", jsonType(v)))
+		bad["form"] = true
+	}
+	switch v := fields["tags"].(type) {
+	case nil:
+	case []interface{}:
+		for _, tag := range v {
+			if s, ok := tag.(string); ok {
+				poem.Tags = append(poem.Tags, s)
+			} else {
+				problems = append(problems, fmt.Sprintf("field \"tags\" must hold strings, got %s", jsonType(tag)))
+				bad["tags"] = true
+				break
+			}
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("field \"tags\" must be an array of strings, got %s", jsonType(v)))
+		bad["tags"] = true
+	}
+
+	if err := ValidatePoem(poem); err != nil {
+		for _, problem := range err.(*PoemValidationError).Problems {
+			// Fields that are missing or of the wrong type are already reported
+			if field, _, _ := strings.Cut(problem, " "); !bad[field] {
+				problems = append(problems, problem)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &PoemValidationError{Problems: problems}
+	}
+	return poem, nil
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// ValidatePoem normalizes the poem in place (trimmed fields, lower-case
+// dynasty, canonical form name, one line per row of content, distinct
+// tags) and returns a *PoemValidationError if anything is wrong with it.
+func ValidatePoem(poem *Poem) error {
+	poem.Title = strings.TrimSpace(poem.Title)
+	poem.Author = strings.TrimSpace(poem.Author)
+	poem.UserPreference = strings.TrimSpace(poem.UserPreference)
+	poem.Content = normalizePoemContent(poem.Content)
+	if dynasty, ok := dynastyNames[strings.ToLower(strings.TrimSpace(poem.Dynasty))]; ok {
+		poem.Dynasty = dynasty
+	}
+
+	var problems []string
+	for _, f := range []struct {
+		name, value string
+		max         int
+		required    bool
+	}{
+		{"title", poem.Title, maxPoemTitle, true},
+		{"author", poem.Author, maxPoemAuthor, true},
+		{"content", poem.Content, maxPoemContent, true},
+		{"user_preference", poem.UserPreference, maxPoemUserPreference, false},
+	} {
+		n := utf8.RuneCountInString(f.value)
+		if f.required && n == 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be empty", f.name))
+		} else if n > f.max {
+			problems = append(problems, fmt.Sprintf("%s is %d characters long, the limit is %d", f.name, n, f.max))
+		}
+	}
+	if poem.Dynasty != "tang" && poem.Dynasty != "song" {
+		problems = append(problems, fmt.Sprintf("dynasty must be \"tang\" or \"song\", got %q", poem.Dynasty))
+	}
+	form, hasForm := LookupPoemForm(poem.Form)
+	if hasForm {
+		poem.Form = form.Name
+	} else if poem.Form = strings.TrimSpace(poem.Form); poem.Form != "" {
+		problems = append(problems, fmt.Sprintf("form %q is not supported, use one of %s", poem.Form, strings.Join(PoemFormNames(), ", ")))
+	}
+	if poem.Content != "" {
+		if hasForm {
+			problems = append(problems, checkForm(form, poem.Content)...)
+		} else if poem.Form == "" {
+			problems = append(problems, checkLineStructure(poem)...)
+		}
+	}
+	tags, tagProblems := normalizeTags(poem.Tags)
+	poem.Tags = tags
+	problems = append(problems, tagProblems...)
+	if len(tags) > maxPoemTags {
+		problems = append(problems, fmt.Sprintf("tags has %d entries, the limit is %d", len(tags), maxPoemTags))
+	}
+
+	if len(problems) > 0 {
+		return &PoemValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// checkLineStructure holds Tang poems that name no form to the regular
+// shi forms: an even number of at least four lines, all of five or all of
+// seven characters, and fills in the form when it is one of poemForms.
+// Song poems may be ci, whose line lengths follow the tune, so they are
+// not checked here.
+func checkLineStructure(poem *Poem) []string {
+	if poem.Dynasty != "tang" {
+		return nil
+	}
+
+	lines := poemLines(poem.Content)
+	if len(lines) == 0 {
+		return []string{"content has no lines"}
+	}
+	lengths := make([]int, len(lines))
+	uniform := true
+	for i, line := range lines {
+		lengths[i] = hanCount(line)
+		if lengths[i] != lengths[0] {
+			uniform = false
+		}
+	}
+
+	var problems []string
+	if !uniform || (lengths[0] != 5 && lengths[0] != 7) {
+		problems = append(problems, fmt.Sprintf("shi lines must all have 5 or all have 7 characters, got %v", lengths))
+	}
+	if len(lines) < 4 || len(lines)%2 != 0 {
+		problems = append(problems, fmt.Sprintf("shi need an even number of at least 4 lines, got %d", len(lines)))
+	}
+	if len(problems) == 0 {
+		poem.Form = shiForm(len(lines), lengths[0])
+	}
+	return problems
+}
+
+// poemLines splits content into verse lines at line breaks and
+// punctuation, so 床前明月光，疑是地上霜。 counts as two lines whether or not
+// it is written on one row.
+func poemLines(content string) []string {
+	return strings.FieldsFunc(content, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("，。！？；、,.!?;：:", r)
+	})
+}
+
+func hanCount(s string) int {
+	n := 0
+	for _, r := range s {
+		if unicode.Is(unicode.Han, r) {
+			n++
+		}
+	}
+	return n
+}
+
+// normalizePoemContent unifies line endings and trims every row, keeping a
+// single blank row between stanzas.
+func normalizePoemContent(content string) string {
+	stanzas := PoemStanzas(strings.ReplaceAll(content, "\r\n", "\n"))
+	blocks := make([]string, len(stanzas))
+	for i, rows := range stanzas {
+		blocks[i] = strings.Join(rows, "\n")
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// stripCodeFence removes a ```json fence, or any text around the outermost
+// JSON object, from a model answer.
+func stripCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		if i := strings.IndexByte(content, '\n'); i >= 0 {
+			content = content[i+1:]
+		}
+		content = strings.TrimSuffix(strings.TrimSpace(content), "```")
+	}
+	start, end := strings.IndexByte(content, '{'), strings.LastIndexByte(content, '}')
+	if start >= 0 && end > start {
+		content = content[start : end+1]
+	}
+	return strings.TrimSpace(content)
+}
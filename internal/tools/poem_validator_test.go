@@ -0,0 +1,175 @@
+package tools
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidatePoem(t *testing.T) {
+	tests := []struct {
+		name     string
+		poem     Poem
+		problems []string // substrings of the expected problems, in order
+		form     string
+	}{
+		{
+			name: "valid jueju gets its form",
+			poem: Poem{Title: "静夜思", Author: "李白", Dynasty: "tang", Content: jingYeSi},
+			form: "五言绝句",
+		},
+		{
+			name: "dynasty names are normalized",
+			poem: Poem{Title: " 静夜思 ", Author: "李白", Dynasty: "唐朝", Content: jingYeSi},
+			form: "五言绝句",
+		},
+		{
+			name: "form aliases are canonicalized",
+			poem: Poem{Title: "静夜思", Author: "李白", Dynasty: "tang", Form: "五绝", Content: jingYeSi},
+			form: "五言绝句",
+		},
+		{
+			name:     "missing fields",
+			poem:     Poem{Dynasty: "tang"},
+			problems: []string{"title must not be empty", "author must not be empty", "content must not be empty"},
+		},
+		{
+			name:     "unknown dynasty",
+			poem:     Poem{Title: "t", Author: "a", Dynasty: "han", Content: jingYeSi},
+			problems: []string{`dynasty must be "tang" or "song"`},
+		},
+		{
+			name:     "title too long",
+			poem:     Poem{Title: strings.Repeat("月", maxPoemTitle+1), Author: "a", Dynasty: "tang", Content: jingYeSi},
+			problems: []string{"title is 51 characters long"},
+		},
+		{
+			name:     "unsupported form",
+			poem:     Poem{Title: "t", Author: "a", Dynasty: "song", Form: "十四行诗", Content: jingYeSi},
+			problems: []string{`form "十四行诗" is not supported`},
+		},
+		{
+			name:     "content does not fit the named form",
+			poem:     Poem{Title: "t", Author: "a", Dynasty: "tang", Form: "七言绝句", Content: jingYeSi},
+			problems: []string{"七言绝句"},
+		},
+		{
+			name: "song poems without a form are not held to shi lines",
+			poem: Poem{Title: "t", Author: "a", Dynasty: "song", Content: "明月几时有\n把酒问青天"},
+		},
+		{
+			name:     "too many tags",
+			poem:     Poem{Title: "t", Author: "a", Dynasty: "tang", Content: jingYeSi, Tags: strings.Split("一 二 三 四 五 六 七 八 九", " ")},
+			problems: []string{"tags has 9 entries"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poem := tt.poem
+			err := ValidatePoem(&poem)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("ValidatePoem() = %v, want no error", err)
+				}
+				if poem.Form != tt.form {
+					t.Errorf("Form = %q, want %q", poem.Form, tt.form)
+				}
+				return
+			}
+			var verr *PoemValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("ValidatePoem() = %v, want a *PoemValidationError", err)
+			}
+			if len(verr.Problems) < len(tt.problems) {
+				t.Fatalf("problems = %q, want %d or more", verr.Problems, len(tt.problems))
+			}
+			for i, want := range tt.problems {
+				if !strings.Contains(verr.Problems[i], want) {
+					t.Errorf("problem %d = %q, want it to contain %q", i, verr.Problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePoemNormalizes(t *testing.T) {
+	poem := Poem{
+		Title:   "  静夜思\t",
+		Author:  "李白",
+		Dynasty: "TANG",
+		Content: "  床前明月光，疑是地上霜。 \r\n\r\n\r\n 举头望明月，低头思故乡。",
+		Tags:    []string{"#月", "月", " 思乡 ", ""},
+	}
+	if err := ValidatePoem(&poem); err != nil {
+		t.Fatalf("ValidatePoem() = %v", err)
+	}
+	if poem.Title != "静夜思" || poem.Dynasty != "tang" {
+		t.Errorf("title, dynasty = %q, %q", poem.Title, poem.Dynasty)
+	}
+	if want := "床前明月光，疑是地上霜。\n\n举头望明月，低头思故乡。"; poem.Content != want {
+		t.Errorf("Content = %q, want %q", poem.Content, want)
+	}
+	if got := strings.Join(poem.Tags, ","); got != "月,思乡" {
+		t.Errorf("Tags = %q, want 月,思乡", got)
+	}
+}
+
+func TestCheckLineStructure(t *testing.T) {
+	tests := []struct {
+		name     string
+		dynasty  string
+		content  string
+		problems int
+		form     string
+	}{
+		{"wujue", "tang", jingYeSi, 0, "五言绝句"},
+		{"wujue on four rows", "tang", "床前明月光\n疑是地上霜\n举头望明月\n低头思故乡", 0, "五言绝句"},
+		{"qijue", "tang", "朝辞白帝彩云间，千里江陵一日还。\n两岸猿声啼不住，轻舟已过万重山。", 0, "七言绝句"},
+		{"wulv", "tang", "国破山河在，城春草木深。\n感时花溅泪，恨别鸟惊心。\n烽火连三月，家书抵万金。\n白头搔更短，浑欲不胜簪。", 0, "五言律诗"},
+		{"six even lines of five are shi without a form", "tang", "一二三四五，一二三四五。\n一二三四五，一二三四五。\n一二三四五，一二三四五。", 0, ""},
+		{"mixed lengths", "tang", "床前明月光，疑是地上霜。\n举头望明月，低头思故乡啊。", 1, ""},
+		{"six characters", "tang", "一二三四五六，一二三四五六。\n一二三四五六，一二三四五六。", 1, ""},
+		{"odd line count", "tang", "床前明月光，疑是地上霜。\n举头望明月。", 1, ""},
+		{"too few lines", "tang", "床前明月光，疑是地上霜。", 1, ""},
+		{"both wrong", "tang", "床前明月光，疑是地上霜啊。\n举头望明月。", 2, ""},
+		{"song is not checked", "song", "明月几时有", 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poem := Poem{Dynasty: tt.dynasty, Content: tt.content}
+			problems := checkLineStructure(&poem)
+			if len(problems) != tt.problems {
+				t.Fatalf("checkLineStructure() = %q, want %d problems", problems, tt.problems)
+			}
+			if poem.Form != tt.form {
+				t.Errorf("Form = %q, want %q", poem.Form, tt.form)
+			}
+		})
+	}
+}
+
+func TestParseGeneratedPoem(t *testing.T) {
+	fenced := "Here it is:\n```json\n{\"title\": \"静夜思\", \"author\": \"李白\", \"dynasty\": \"唐\", \"content\": \"床前明月光，疑是地上霜。\\n举头望明月，低头思故乡。\", \"user_preference\": \"李白\", \"tags\": [\"月\"]}\n```"
+	poem, err := ParseGeneratedPoem(fenced)
+	if err != nil {
+		t.Fatalf("ParseGeneratedPoem() = %v", err)
+	}
+	if poem.Title != "静夜思" || poem.Dynasty != "tang" || poem.Form != "五言绝句" || len(poem.Tags) != 1 {
+		t.Errorf("poem = %+v", poem)
+	}
+
+	_, err = ParseGeneratedPoem(`{"title": 1, "author": "李白", "dynasty": "tang", "content": "床前明月光"}`)
+	var verr *PoemValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("ParseGeneratedPoem() = %v, want a *PoemValidationError", err)
+	}
+	for _, problem := range verr.Problems {
+		if strings.HasPrefix(problem, "title ") {
+			t.Errorf("wrongly typed title reported twice: %q", verr.Problems)
+		}
+	}
+	if !strings.Contains(verr.Error(), `field "title" must be a string, got a number`) ||
+		!strings.Contains(verr.Error(), `missing field "user_preference"`) {
+		t.Errorf("Error() = %q", verr.Error())
+	}
+}
@@ -0,0 +1,343 @@
+package tools
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nokode/nokode/internal/utils"
+)
+
+const (
+	poemDefaultPageSize = 20
+	poemMaxPageSize     = 100
+	poemColumns         = "id, title, author, dynasty, form, content, user_preference, created_at"
+)
+
+// Poem is a row of the poems table.
+type Poem struct {
+	ID             int64  `json:"id"`
+	Title          string `json:"title"`
+	Author         string `json:"author"`
+	Dynasty        string `json:"dynasty"`
+	Form           string `json:"form,omitempty"`
+	Content        string `json:"content"`
+	UserPreference string `json:"user_preference"`
+	CreatedAt      string `json:"created_at"`
+
+	// Prosody is set for poems in one of the regulated forms
+	Prosody *ProsodyScore `json:"prosody,omitempty"`
+	// Similarity is the closest poem found when it was created
+	Similarity *SimilarityMatch `json:"similarity,omitempty"`
+	// Rating is set once readers have rated or favorited the poem
+	Rating *RatingSummary `json:"rating,omitempty"`
+	// Tags are the poem's themes, such as 月, 酒 or 思乡
+	Tags []string `json:"tags,omitempty"`
+	// Source is set by the caller of CreatePoem to PoemSourceGenerated or
+	// PoemSourceAdmin
+	Source string `json:"-"`
+}
+
+// PoemFilter selects a page of poems. Empty fields match all poems.
+type PoemFilter struct {
+	Dynasty        string
+	Form           string
+	Author         string
+	UserPreference string
+	Tag            string
+	Page           int
+	PageSize       int
+}
+
+// PoemPage is one page of ListPoems results.
+type PoemPage struct {
+	Poems    []Poem `json:"poems"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"pageSize"`
+	Total    int64  `json:"total"`
+}
+
+// poemsTableExists reports whether the model has created the poems table
+// yet in the request's database.
+func poemsTableExists(info *RequestInfo) (bool, error) {
+	pool, release, err := tenantPool(info)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	tables, err := applicationTables(pool)
+	if err != nil {
+		return false, err
+	}
+	return containsFold(tables, "poems"), nil
+}
+
+// ListPoems returns a page of poems, newest first.
+func ListPoems(info *RequestInfo, filter PoemFilter) (PoemPage, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize <= 0 || filter.PageSize > poemMaxPageSize {
+		filter.PageSize = poemDefaultPageSize
+	}
+	page := PoemPage{Poems: []Poem{}, Page: filter.Page, PageSize: filter.PageSize}
+
+	exists, err := poemsTableExists(info)
+	if err != nil || !exists {
+		return page, err
+	}
+
+	var conditions []string
+	var params []interface{}
+	for _, f := range []struct{ column, value string }{
+		{"dynasty", filter.Dynasty},
+		{"form", filter.Form},
+		{"author", filter.Author},
+		{"user_preference", filter.UserPreference},
+	} {
+		if f.value != "" {
+			conditions = append(conditions, f.column+" = ?")
+			params = append(params, f.value)
+		}
+	}
+	if filter.Tag != "" {
+		pool, release, err := tenantPool(info)
+		if err != nil {
+			return page, err
+		}
+		defer release()
+		if err := ensureTagsTable(pool); err != nil {
+			return page, err
+		}
+		conditions = append(conditions, "id IN (SELECT poem_id FROM "+tagsTable+" WHERE tag = ?)")
+		params = append(params, filter.Tag)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	count := ExecuteDatabaseQuery(info, "SELECT COUNT(*) AS total FROM poems"+where, params, "query")
+	if !count.Success {
+		return page, fmt.Errorf("%s", count.Error)
+	}
+	if len(count.Rows) > 0 {
+		page.Total = int64Value(count.Rows[0]["total"])
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM poems%s ORDER BY created_at DESC, id DESC LIMIT %d OFFSET %d",
+		poemColumns, where, filter.PageSize, (filter.Page-1)*filter.PageSize)
+	result := ExecuteDatabaseQuery(info, query, params, "query")
+	if !result.Success {
+		return page, fmt.Errorf("%s", result.Error)
+	}
+	for _, row := range result.Rows {
+		page.Poems = append(page.Poems, poemFromRow(row))
+	}
+	attachScores(info, page.Poems)
+	return page, nil
+}
+
+// GetPoem returns the poem with the given id, or nil if there is none.
+func GetPoem(info *RequestInfo, id int64) (*Poem, error) {
+	exists, err := poemsTableExists(info)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	result := ExecuteDatabaseQuery(info, "SELECT "+poemColumns+" FROM poems WHERE id = ?", []interface{}{id}, "query")
+	if !result.Success {
+		return nil, fmt.Errorf("%s", result.Error)
+	}
+	if len(result.Rows) == 0 {
+		return nil, nil
+	}
+	poems := []Poem{poemFromRow(result.Rows[0])}
+	attachScores(info, poems)
+	return &poems[0], nil
+}
+
+// attachScores fills in the stored prosody scores, similarity matches,
+// ratings and tags. The poems are still served if they cannot be read.
+func attachScores(info *RequestInfo, poems []Poem) {
+	if len(poems) == 0 {
+		return
+	}
+	pool, release, err := tenantPool(info)
+	if err != nil {
+		return
+	}
+	defer release()
+	ids := make([]int64, len(poems))
+	for i, poem := range poems {
+		ids[i] = poem.ID
+	}
+	scores, err := loadProsody(pool, ids)
+	if err != nil {
+		utils.Log.Warn("prosody", "Failed to load prosody scores", map[string]interface{}{"error": err.Error()})
+	}
+	matches, err := loadSimilarity(pool, ids)
+	if err != nil {
+		utils.Log.Warn("similarity", "Failed to load similarity matches", map[string]interface{}{"error": err.Error()})
+	}
+	ratings, err := loadRatings(pool, ids)
+	if err != nil {
+		utils.Log.Warn("ratings", "Failed to load poem ratings", map[string]interface{}{"error": err.Error()})
+	}
+	tags, err := loadTags(pool, ids)
+	if err != nil {
+		utils.Log.Warn("tags", "Failed to load poem tags", map[string]interface{}{"error": err.Error()})
+	}
+	for i := range poems {
+		poems[i].Prosody = scores[poems[i].ID]
+		poems[i].Similarity = matches[poems[i].ID]
+		poems[i].Rating = ratings[poems[i].ID]
+		poems[i].Tags = tags[poems[i].ID]
+	}
+}
+
+// CreatePoem inserts a poem and returns it as stored. The closest match
+// among existing and famous poems is recorded with it; callers that have
+// already run CheckDuplicate pass its result in poem.Similarity.
+func CreatePoem(info *RequestInfo, poem Poem) (*Poem, error) {
+	exists, err := poemsTableExists(info)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("poems table does not exist yet")
+	}
+	if poem.Similarity == nil {
+		if poem.Similarity, err = CheckDuplicate(info, poem.Content); err != nil {
+			utils.Log.Warn("similarity", "Failed to compare poem with existing poems", map[string]interface{}{"error": err.Error()})
+		}
+	}
+
+	query := "INSERT INTO poems (title, author, dynasty, form, content, user_preference) VALUES (?, ?, ?, ?, ?, ?)"
+	params := []interface{}{poem.Title, poem.Author, poem.Dynasty, poem.Form, poem.Content, poem.UserPreference}
+
+	var id int64
+	if _, ok := dialect.(postgresDialect); ok {
+		// lib/pq does not support LastInsertId
+		result := ExecuteDatabaseQuery(info, query+" RETURNING id", params, "query")
+		if !result.Success {
+			return nil, fmt.Errorf("%s", result.Error)
+		}
+		if len(result.Rows) > 0 {
+			id = int64Value(result.Rows[0]["id"])
+		}
+	} else {
+		result := ExecuteDatabaseQuery(info, query, params, "insert")
+		if !result.Success {
+			return nil, fmt.Errorf("%s", result.Error)
+		}
+		id = result.LastInsertRowID
+	}
+
+	if score := ScorePoem(poem); score != nil && id != 0 {
+		pool, release, err := tenantPool(info)
+		if err == nil {
+			defer release()
+			err = saveProsody(pool, id, score)
+		}
+		if err != nil {
+			utils.Log.Warn("prosody", "Failed to save prosody score", map[string]interface{}{"poemId": id, "error": err.Error()})
+		}
+	}
+	if poem.Similarity != nil && id != 0 {
+		pool, release, err := tenantPool(info)
+		if err == nil {
+			defer release()
+			err = saveSimilarity(pool, id, poem.Similarity)
+		}
+		if err != nil {
+			utils.Log.Warn("similarity", "Failed to save similarity match", map[string]interface{}{"poemId": id, "error": err.Error()})
+		}
+	}
+	if poem.Source != "" && id != 0 {
+		pool, release, err := tenantPool(info)
+		if err == nil {
+			defer release()
+			err = saveSource(pool, id, poem.Source)
+		}
+		if err != nil {
+			utils.Log.Warn("ratings", "Failed to save poem source", map[string]interface{}{"poemId": id, "error": err.Error()})
+		}
+	}
+	if len(poem.Tags) > 0 && id != 0 {
+		pool, release, err := tenantPool(info)
+		if err == nil {
+			defer release()
+			err = saveTags(pool, id, poem.Tags)
+		}
+		if err != nil {
+			utils.Log.Warn("tags", "Failed to save poem tags", map[string]interface{}{"poemId": id, "error": err.Error()})
+		}
+	}
+
+	return GetPoem(info, id)
+}
+
+// DeletePoem deletes the poem with the given id and reports whether it
+// existed.
+func DeletePoem(info *RequestInfo, id int64) (bool, error) {
+	exists, err := poemsTableExists(info)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	result := ExecuteDatabaseQuery(info, "DELETE FROM poems WHERE id = ?", []interface{}{id}, "delete")
+	if !result.Success {
+		return false, fmt.Errorf("%s", result.Error)
+	}
+	if pool, release, err := tenantPool(info); err == nil {
+		defer release()
+		if err := deleteProsody(pool, id); err != nil {
+			utils.Log.Warn("prosody", "Failed to delete prosody score", map[string]interface{}{"poemId": id, "error": err.Error()})
+		}
+		if err := deleteSimilarity(pool, id); err != nil {
+			utils.Log.Warn("similarity", "Failed to delete similarity match", map[string]interface{}{"poemId": id, "error": err.Error()})
+		}
+		if err := deleteRatings(pool, id); err != nil {
+			utils.Log.Warn("ratings", "Failed to delete poem ratings", map[string]interface{}{"poemId": id, "error": err.Error()})
+		}
+		if err := deleteTags(pool, id); err != nil {
+			utils.Log.Warn("tags", "Failed to delete poem tags", map[string]interface{}{"poemId": id, "error": err.Error()})
+		}
+		if err := deleteCollectionItems(pool, id); err != nil {
+			utils.Log.Warn("collections", "Failed to remove poem from collections", map[string]interface{}{"poemId": id, "error": err.Error()})
+		}
+		if err := deleteSource(pool, id); err != nil {
+			utils.Log.Warn("ratings", "Failed to delete poem source", map[string]interface{}{"poemId": id, "error": err.Error()})
+		}
+	}
+	return result.Changes > 0, nil
+}
+
+func poemFromRow(row map[string]interface{}) Poem {
+	return Poem{
+		ID:             int64Value(row["id"]),
+		Title:          stringValue(row["title"]),
+		Author:         stringValue(row["author"]),
+		Dynasty:        stringValue(row["dynasty"]),
+		Form:           stringValue(row["form"]),
+		Content:        stringValue(row["content"]),
+		UserPreference: stringValue(row["user_preference"]),
+		CreatedAt:      stringValue(row["created_at"]),
+	}
+}
+
+func int64Value(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case uint64:
+		return int64(n)
+	case float64:
+		return int64(n)
+	case string:
+		i, _ := strconv.ParseInt(n, 10, 64)
+		return i
+	}
+	return 0
+}
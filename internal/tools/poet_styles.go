@@ -0,0 +1,300 @@
+package tools
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+const poetStylesTable = "poet_styles"
+
+// RandomPoetStyle is the poet_preference value that leaves the choice of
+// poet to the model.
+const RandomPoetStyle = "random"
+
+// ErrPoetStyleExists is returned when a style with the same name exists.
+var ErrPoetStyleExists = errors.New("a poet style with this name already exists")
+
+// PoetStyle describes a poet the /generate form offers and how the model
+// should write in their style.
+type PoetStyle struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Dynasty     string    `json:"dynasty"`
+	Description string    `json:"description"`
+	SampleLines []string  `json:"sampleLines"`
+	Forms       []string  `json:"forms"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// defaultPoetStyles seed an empty poet_styles table with the poets the
+// prompt used to hardcode.
+var defaultPoetStyles = []PoetStyle{
+	{
+		Name:        "李白",
+		Dynasty:     "tang",
+		Description: "浪漫主义诗人，诗歌豪放不羁，富有想象力。多写山水、明月与美酒，语言流畅奔放，情感浓烈。",
+		SampleLines: []string{"君不见黄河之水天上来，奔流到海不复回。", "举杯邀明月，对影成三人。"},
+		Forms:       []string{"七绝", "五绝", "五律"},
+	},
+	{
+		Name:        "杜甫",
+		Dynasty:     "tang",
+		Description: "现实主义诗人，关注社会民生，沉郁顿挫。多写战乱、贫困与家国之忧，观察细致，情怀悲悯。",
+		SampleLines: []string{"朱门酒肉臭，路有冻死骨。", "安得广厦千万间，大庇天下寒士俱欢颜。"},
+		Forms:       []string{"七律", "五律"},
+	},
+	{
+		Name:        "苏轼",
+		Dynasty:     "song",
+		Description: "豪迈旷达，哲理深刻，气象万千。常借自然景物抒发人生感悟，胸怀开阔，乐观从容。",
+		SampleLines: []string{"竹杖芒鞋轻胜马，谁怕？一蓑烟雨任平生。", "人有悲欢离合，月有阴晴圆缺。"},
+		Forms:       []string{"词", "七绝"},
+	},
+	{
+		Name:        "李清照",
+		Dynasty:     "song",
+		Description: "婉约细腻，情感真挚，语言精炼。善写离愁别绪与四时变化，含蓄深婉，清丽典雅。",
+		SampleLines: []string{"莫道不消魂，帘卷西风，人比黄花瘦。", "此情无计可消除，才下眉头，却上心头。"},
+		Forms:       []string{"词"},
+	},
+}
+
+// InitPoetStyles creates the poet_styles table in the primary database and
+// seeds it with the default poets when it is empty. Styles are shared by
+// all tenants.
+func InitPoetStyles(cfg *config.Config) error {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id %s,
+		name VARCHAR(100) NOT NULL UNIQUE,
+		dynasty VARCHAR(16) NOT NULL,
+		description TEXT NOT NULL,
+		sample_lines TEXT,
+		forms VARCHAR(255),
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`, poetStylesTable, dialect.AutoIncrementKey()))
+	if err != nil {
+		utils.Log.Error("styles", "Failed to create poet_styles table", err)
+		return err
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ` + poetStylesTable).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		for _, style := range defaultPoetStyles {
+			if _, err := CreatePoetStyle(style); err != nil {
+				utils.Log.Error("styles", "Failed to seed poet style", err)
+				return err
+			}
+		}
+		utils.Log.Success("styles", fmt.Sprintf("Seeded %d poet styles", len(defaultPoetStyles)), nil)
+	}
+	return nil
+}
+
+// ValidatePoetStyle trims the style in place and returns an error listing
+// everything wrong with it.
+func ValidatePoetStyle(style *PoetStyle) error {
+	style.Name = strings.TrimSpace(style.Name)
+	style.Description = strings.TrimSpace(style.Description)
+	if dynasty, ok := dynastyNames[strings.ToLower(strings.TrimSpace(style.Dynasty))]; ok {
+		style.Dynasty = dynasty
+	}
+	style.SampleLines = trimmedNonEmpty(style.SampleLines)
+	style.Forms = trimmedNonEmpty(style.Forms)
+
+	var problems []string
+	switch {
+	case style.Name == "":
+		problems = append(problems, "name must not be empty")
+	case utf8.RuneCountInString(style.Name) > maxPoemUserPreference:
+		problems = append(problems, fmt.Sprintf("name is longer than %d characters", maxPoemUserPreference))
+	case strings.EqualFold(style.Name, RandomPoetStyle):
+		problems = append(problems, fmt.Sprintf("name %q is reserved", RandomPoetStyle))
+	}
+	if style.Dynasty != "tang" && style.Dynasty != "song" {
+		problems = append(problems, fmt.Sprintf("dynasty must be \"tang\" or \"song\", got %q", style.Dynasty))
+	}
+	if style.Description == "" {
+		problems = append(problems, "description must not be empty")
+	}
+	for _, form := range style.Forms {
+		if strings.Contains(form, ",") {
+			problems = append(problems, fmt.Sprintf("form %q must not contain a comma", form))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid poet style: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func trimmedNonEmpty(values []string) []string {
+	result := []string{}
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+const poetStyleColumns = "id, name, dynasty, description, sample_lines, forms, created_at, updated_at"
+
+// ListPoetStyles returns all poet styles in the order they were added.
+func ListPoetStyles() ([]PoetStyle, error) {
+	rows, err := db.Query(`SELECT ` + poetStyleColumns + ` FROM ` + poetStylesTable + ` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	styles := []PoetStyle{}
+	for rows.Next() {
+		style, err := scanPoetStyle(rows)
+		if err != nil {
+			return nil, err
+		}
+		styles = append(styles, *style)
+	}
+	return styles, rows.Err()
+}
+
+// GetPoetStyle returns the style with the given id, or nil if there is none.
+func GetPoetStyle(id int64) (*PoetStyle, error) {
+	style, err := scanPoetStyle(db.QueryRow(dialect.Rebind(`SELECT `+poetStyleColumns+` FROM `+poetStylesTable+` WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return style, err
+}
+
+// CreatePoetStyle stores a validated style and returns it as stored.
+func CreatePoetStyle(style PoetStyle) (*PoetStyle, error) {
+	if taken, err := poetStyleNameTaken(style.Name, 0); err != nil || taken {
+		if taken {
+			err = ErrPoetStyleExists
+		}
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	query := `INSERT INTO ` + poetStylesTable + ` (name, dynasty, description, sample_lines, forms, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	params := []interface{}{style.Name, style.Dynasty, style.Description, strings.Join(style.SampleLines, "\n"), strings.Join(style.Forms, ","), now, now}
+
+	var id int64
+	if _, ok := dialect.(postgresDialect); ok {
+		// lib/pq does not support LastInsertId
+		if err := db.QueryRow(dialect.Rebind(query+" RETURNING id"), params...).Scan(&id); err != nil {
+			return nil, err
+		}
+	} else {
+		result, err := db.Exec(dialect.Rebind(query), params...)
+		if err != nil {
+			return nil, err
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return nil, err
+		}
+	}
+	return GetPoetStyle(id)
+}
+
+// UpdatePoetStyle replaces the style with the given id and returns it as
+// stored, or nil if there is none.
+func UpdatePoetStyle(id int64, style PoetStyle) (*PoetStyle, error) {
+	if taken, err := poetStyleNameTaken(style.Name, id); err != nil || taken {
+		if taken {
+			err = ErrPoetStyleExists
+		}
+		return nil, err
+	}
+
+	result, err := db.Exec(dialect.Rebind(`UPDATE `+poetStylesTable+` SET name = ?, dynasty = ?, description = ?, sample_lines = ?, forms = ?, updated_at = ? WHERE id = ?`),
+		style.Name, style.Dynasty, style.Description, strings.Join(style.SampleLines, "\n"), strings.Join(style.Forms, ","), time.Now().UTC(), id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+	return GetPoetStyle(id)
+}
+
+// DeletePoetStyle deletes the style with the given id and reports whether
+// it existed.
+func DeletePoetStyle(id int64) (bool, error) {
+	result, err := db.Exec(dialect.Rebind(`DELETE FROM `+poetStylesTable+` WHERE id = ?`), id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// poetStyleNameTaken reports whether a style other than exceptID already
+// uses the name.
+func poetStyleNameTaken(name string, exceptID int64) (bool, error) {
+	var count int
+	err := db.QueryRow(dialect.Rebind(`SELECT COUNT(*) FROM `+poetStylesTable+` WHERE name = ? AND id <> ?`), name, exceptID).Scan(&count)
+	return count > 0, err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPoetStyle(row rowScanner) (*PoetStyle, error) {
+	var style PoetStyle
+	var samples, forms sql.NullString
+	if err := row.Scan(&style.ID, &style.Name, &style.Dynasty, &style.Description, &samples, &forms, &style.CreatedAt, &style.UpdatedAt); err != nil {
+		return nil, err
+	}
+	style.SampleLines = trimmedNonEmpty(strings.Split(samples.String, "\n"))
+	style.Forms = trimmedNonEmpty(strings.Split(forms.String, ","))
+	return &style, nil
+}
+
+// PoetStyleGuide is the style guide injected into the prompt. For a known
+// poet_preference it describes that poet in full; otherwise, as for
+// "random", it lists every poet briefly.
+func PoetStyleGuide(styles []PoetStyle, preference string) string {
+	var b strings.Builder
+	for _, style := range styles {
+		if style.Name == preference {
+			fmt.Fprintf(&b, "The user chose **%s** (%s dynasty). %s\n", style.Name, style.Dynasty, style.Description)
+			if len(style.Forms) > 0 {
+				fmt.Fprintf(&b, "- Preferred forms: %s\n", strings.Join(style.Forms, "、"))
+			}
+			if len(style.SampleLines) > 0 {
+				b.WriteString("- Sample lines, for the voice only; do not reuse them:\n")
+				for _, line := range style.SampleLines {
+					fmt.Fprintf(&b, "  - %s\n", line)
+				}
+			}
+			fmt.Fprintf(&b, "\nSet `author` to \"%s\", `dynasty` to \"%s\" and `user_preference` to \"%s\".\n", style.Name, style.Dynasty, style.Name)
+			return b.String()
+		}
+	}
+
+	b.WriteString("No poet was chosen (\"random\"): pick one of these poets, or mix their styles into something new.\n")
+	for _, style := range styles {
+		fmt.Fprintf(&b, "- **%s** (%s): %s", style.Name, style.Dynasty, style.Description)
+		if len(style.Forms) > 0 {
+			fmt.Fprintf(&b, " Forms: %s.", strings.Join(style.Forms, "、"))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\nSet `user_preference` to \"random\".\n")
+	return b.String()
+}
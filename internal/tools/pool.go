@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/nokode/nokode/internal/config"
+)
+
+// PoolStats is a snapshot of a connection pool's usage, exported for
+// monitoring.
+type PoolStats struct {
+	MaxOpenConnections int   `json:"maxOpenConnections"`
+	OpenConnections    int   `json:"openConnections"`
+	InUse              int   `json:"inUse"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"waitCount"`
+	WaitDurationMs     int64 `json:"waitDurationMs"`
+	MaxIdleClosed      int64 `json:"maxIdleClosed"`
+	MaxIdleTimeClosed  int64 `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed  int64 `json:"maxLifetimeClosed"`
+}
+
+func databaseTimezone(cfg *config.Config) string {
+	if cfg.Database.Timezone == "" {
+		return "Local"
+	}
+	return cfg.Database.Timezone
+}
+
+// configurePool applies the dialect's default pool settings and then any
+// overrides from the configuration.
+func configurePool(pool *sql.DB, cfg *config.Config) error {
+	dialect.ConfigurePool(pool)
+
+	if cfg.Database.MaxOpenConns > 0 {
+		pool.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	}
+	if cfg.Database.MaxIdleConns > 0 {
+		pool.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	}
+	if cfg.Database.ConnMaxLifetime != "" {
+		d, err := time.ParseDuration(cfg.Database.ConnMaxLifetime)
+		if err != nil {
+			return fmt.Errorf("invalid ConnMaxLifetime %q: %w", cfg.Database.ConnMaxLifetime, err)
+		}
+		pool.SetConnMaxLifetime(d)
+	}
+	if cfg.Database.ConnMaxIdleTime != "" {
+		d, err := time.ParseDuration(cfg.Database.ConnMaxIdleTime)
+		if err != nil {
+			return fmt.Errorf("invalid ConnMaxIdleTime %q: %w", cfg.Database.ConnMaxIdleTime, err)
+		}
+		pool.SetConnMaxIdleTime(d)
+	}
+	return nil
+}
+
+func poolStats(pool *sql.DB) PoolStats {
+	s := pool.Stats()
+	return PoolStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDurationMs:     s.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	}
+}
+
+// DatabaseStats returns the connection pool statistics of the primary and,
+// if configured, the read replica.
+func DatabaseStats() map[string]PoolStats {
+	stats := map[string]PoolStats{}
+	if db != nil {
+		stats["primary"] = poolStats(db)
+	}
+	if replica != nil {
+		stats["replica"] = poolStats(replica)
+	}
+	return stats
+}
@@ -0,0 +1,312 @@
+package tools
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"github.com/nokode/nokode/internal/config"
+)
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string             { return "PostgreSQL" }
+func (postgresDialect) DriverName() string       { return "postgres" }
+func (postgresDialect) RandomFunc() string       { return "RANDOM()" }
+func (postgresDialect) AutoIncrementKey() string { return "BIGSERIAL PRIMARY KEY" }
+func (postgresDialect) LongText() string         { return "TEXT" }
+
+func (postgresDialect) Upsert(table string, columns, key, update []string) string {
+	return upsertOnConflict(table, columns, key, update)
+}
+
+func (postgresDialect) DSN(cfg *config.Config) (string, error) {
+	port := cfg.Database.Port
+	if port == 0 {
+		port = 5432
+	}
+	sslmode, err := postgresSSLMode(cfg.Database.TLS)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("sslmode", sslmode)
+	if tz := databaseTimezone(cfg); tz != "Local" {
+		query.Set("timezone", tz)
+	}
+	u := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(cfg.Database.User, cfg.Database.Password),
+		Host:   net.JoinHostPort(cfg.Database.Host, strconv.Itoa(port)),
+		Path:   "/" + cfg.Database.Database,
+	}
+	if cfg.Database.Socket != "" {
+		// lib/pq treats a host starting with / as a unix socket directory
+		u.Host = ""
+		query.Set("host", cfg.Database.Socket)
+		query.Set("port", strconv.Itoa(port))
+	}
+	for key, value := range cfg.Database.Params {
+		query.Set(key, value)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// postgresSSLMode maps the TLS setting onto an sslmode lib/pq understands,
+// accepting the MySQL style true/false/skip-verify values as well.
+func postgresSSLMode(tls string) (string, error) {
+	switch tls {
+	case "", "false", "disable":
+		return "disable", nil
+	case "true", "skip-verify", "preferred", "require":
+		return "require", nil
+	case "verify-ca", "verify-full":
+		return tls, nil
+	default:
+		return "", fmt.Errorf("unsupported TLS mode for postgres: %s", tls)
+	}
+}
+
+// TenantDSN gives every tenant its own schema named tenant_<tenant> and
+// points the connection's search_path at it.
+func (d postgresDialect) TenantDSN(db *sql.DB, cfg *config.Config, tenant string) (string, error) {
+	schema := "tenant_" + tenant
+	if _, err := db.Exec(`CREATE SCHEMA IF NOT EXISTS "` + schema + `"`); err != nil {
+		return "", err
+	}
+
+	tenantCfg := *cfg
+	tenantCfg.Database.Params = map[string]string{"search_path": schema}
+	for key, value := range cfg.Database.Params {
+		if key != "search_path" {
+			tenantCfg.Database.Params[key] = value
+		}
+	}
+	return d.DSN(&tenantCfg)
+}
+
+func (postgresDialect) ConfigurePool(db *sql.DB) {
+	db.SetMaxOpenConns(25)
+	db.SetMaxIdleConns(5)
+}
+
+func (postgresDialect) Tables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+		ORDER BY table_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			continue
+		}
+		tables = append(tables, tableName)
+	}
+	return tables, rows.Err()
+}
+
+// Explain reports the largest row estimate of any node in the plan, so a
+// sequential scan of a big table is caught even below an aggregate.
+func (postgresDialect) Explain(db *sql.DB, query string, params []interface{}) (int64, interface{}, error) {
+	var raw string
+	if err := db.QueryRow("EXPLAIN (FORMAT JSON) "+postgresDialect{}.Rebind(query), params...).Scan(&raw); err != nil {
+		return 0, nil, err
+	}
+
+	var plan []map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &plan); err != nil {
+		return 0, nil, err
+	}
+	if len(plan) == 0 {
+		return 0, plan, nil
+	}
+
+	var largest func(node map[string]interface{}) int64
+	largest = func(node map[string]interface{}) int64 {
+		rows, _ := node["Plan Rows"].(float64)
+		estimate := int64(rows)
+		children, _ := node["Plans"].([]interface{})
+		for _, child := range children {
+			if childNode, ok := child.(map[string]interface{}); ok {
+				if n := largest(childNode); n > estimate {
+					estimate = n
+				}
+			}
+		}
+		return estimate
+	}
+	root, _ := plan[0]["Plan"].(map[string]interface{})
+	return largest(root), plan, nil
+}
+
+// resetSequences moves the sequences of a table's serial and identity
+// columns past the highest restored value, so new rows do not collide with
+// the ids a snapshot restore inserted explicitly.
+func (postgresDialect) resetSequences(tx *sql.Tx, table string, columns []string) error {
+	for _, column := range columns {
+		var sequence sql.NullString
+		if err := tx.QueryRow(`SELECT pg_get_serial_sequence($1, $2)`, table, column).Scan(&sequence); err != nil {
+			return err
+		}
+		if !sequence.Valid {
+			continue
+		}
+		_, err := tx.Exec(fmt.Sprintf(`SELECT setval($1, COALESCE((SELECT MAX(%s) FROM %s), 0) + 1, false)`, column, table), sequence.String)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serialTypes are the serial pseudo-types of the integer column types.
+var serialTypes = map[string]string{"smallint": "smallserial", "integer": "serial", "bigint": "bigserial"}
+
+// CreateTable rebuilds an approximate CREATE TABLE statement from
+// information_schema, since PostgreSQL has no SHOW CREATE TABLE.
+func (postgresDialect) CreateTable(db *sql.DB, table string) (string, error) {
+	rows, err := db.Query(`SELECT column_name, data_type, character_maximum_length, is_nullable, column_default, is_identity
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+		ORDER BY ordinal_position`, table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name, dataType, nullable, identity string
+		var maxLength sql.NullInt64
+		var defaultValue sql.NullString
+		if err := rows.Scan(&name, &dataType, &maxLength, &nullable, &defaultValue, &identity); err != nil {
+			return "", err
+		}
+
+		// A nextval() default names a sequence that is dropped with the
+		// table, so serial columns are declared as such again
+		serial, isSerial := serialTypes[dataType]
+		if isSerial && defaultValue.Valid && strings.HasPrefix(defaultValue.String, "nextval(") {
+			dataType, defaultValue.Valid = serial, false
+		}
+		column := fmt.Sprintf("  %s %s", name, dataType)
+		if maxLength.Valid {
+			column += fmt.Sprintf("(%d)", maxLength.Int64)
+		}
+		if nullable == "NO" {
+			column += " NOT NULL"
+		}
+		if identity == "YES" {
+			column += " GENERATED BY DEFAULT AS IDENTITY"
+		}
+		if defaultValue.Valid {
+			column += " DEFAULT " + defaultValue.String
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(columns) == 0 {
+		return "", fmt.Errorf("table %s has no columns", table)
+	}
+
+	keyRows, err := db.Query(`SELECT kcu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+		  ON tc.constraint_name = kcu.constraint_name AND tc.table_schema = kcu.table_schema
+		WHERE tc.table_schema = current_schema() AND tc.table_name = $1 AND tc.constraint_type = 'PRIMARY KEY'
+		ORDER BY kcu.ordinal_position`, table)
+	if err != nil {
+		return "", err
+	}
+	defer keyRows.Close()
+
+	var keys []string
+	for keyRows.Next() {
+		var name string
+		if err := keyRows.Scan(&name); err != nil {
+			return "", err
+		}
+		keys = append(keys, name)
+	}
+	if len(keys) > 0 {
+		columns = append(columns, "  PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n)", table, strings.Join(columns, ",\n")), nil
+}
+
+// PostgreSQL text search configurations do not segment Chinese, so search
+// falls back to LIKE matching.
+func (postgresDialect) FullTextIndex(table, index string, columns []string) string { return "" }
+func (postgresDialect) FullTextScore(columns []string) string                      { return "" }
+
+func (postgresDialect) Hint() string {
+	return "PostgreSQL: use SERIAL or GENERATED ALWAYS AS IDENTITY, CHECK constraints instead of ENUM, NOW(), RANDOM() and double-quoted identifiers. Add RETURNING id to an INSERT to get the generated key. Write ? placeholders; they are rewritten to $1, $2, ... automatically."
+}
+
+// Rebind rewrites ? placeholders to PostgreSQL's positional $n form,
+// leaving string literals, quoted identifiers and comments untouched.
+func (postgresDialect) Rebind(query string) string {
+	var out strings.Builder
+	out.Grow(len(query) + 8)
+
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := i + 1
+			for end < len(query) {
+				if query[end] == c {
+					// A doubled quote is an escaped quote inside the literal
+					if end+1 < len(query) && query[end+1] == c {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			if end >= len(query) {
+				end = len(query) - 1
+			}
+			out.WriteString(query[i : end+1])
+			i = end
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			out.WriteString(query[i : i+end+1])
+			i += end
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				out.WriteString(query[i:])
+				return out.String()
+			}
+			out.WriteString(query[i : i+2+end+2])
+			i += 2 + end + 1
+		case c == '?':
+			n++
+			out.WriteString("$" + strconv.Itoa(n))
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,30 @@
+package tools
+
+import "testing"
+
+func TestPostgresRebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"no placeholders", "SELECT 1", "SELECT 1"},
+		{"placeholders in order", "SELECT * FROM poems WHERE id = ? AND author = ?", "SELECT * FROM poems WHERE id = $1 AND author = $2"},
+		{"string literal", "SELECT * FROM poems WHERE title = '?' AND id = ?", "SELECT * FROM poems WHERE title = '?' AND id = $1"},
+		{"escaped quote in literal", "SELECT 'it''s ?' , ?", "SELECT 'it''s ?' , $1"},
+		{"quoted identifier", `SELECT "what?" FROM t WHERE a = ?`, `SELECT "what?" FROM t WHERE a = $1`},
+		{"line comment", "SELECT ? -- why?\nFROM t WHERE a = ?", "SELECT $1 -- why?\nFROM t WHERE a = $2"},
+		{"trailing line comment", "SELECT ? -- why?", "SELECT $1 -- why?"},
+		{"block comment", "SELECT /* a ? b */ ?", "SELECT /* a ? b */ $1"},
+		{"unterminated block comment", "SELECT ? /* ?", "SELECT $1 /* ?"},
+		{"unterminated literal", "SELECT ?, 'abc?", "SELECT $1, 'abc?"},
+		{"ten placeholders", "VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", "VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (postgresDialect{}).Rebind(tt.query); got != tt.want {
+				t.Errorf("Rebind(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package tools
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//go:embed data/pingshui.txt
+var pingshuiData string
+
+// maxProsodyNotes bounds the notes kept per poem, so a badly broken poem
+// does not flood the repair prompt.
+const maxProsodyNotes = 10
+
+// toneEntry is what the 平水韵 table says about one character.
+type toneEntry struct {
+	level   bool     // listed under a 平声 rhyme group
+	oblique bool     // listed under 上, 去 or 入
+	groups  []string // the 平声 rhyme groups it belongs to
+}
+
+var (
+	pingshui     map[rune]*toneEntry
+	pingshuiOnce sync.Once
+)
+
+// The four line patterns of five-character regulated verse. Seven-character
+// lines put two characters of the opposite tone in front.
+var (
+	lineA = "仄仄平平仄"
+	lineB = "平平仄仄平"
+	lineC = "平平平仄仄"
+	lineD = "仄仄仄平平"
+)
+
+// quatrainTemplates are the four jueju patterns: 仄起 and 平起, with and
+// without a rhyme on the first line. Lüshi continue the same cycle for
+// another four lines.
+var quatrainTemplates = [][]string{
+	{lineA, lineB, lineC, lineD},
+	{lineD, lineB, lineC, lineD},
+	{lineC, lineD, lineA, lineB},
+	{lineB, lineD, lineA, lineB},
+}
+
+// ProsodyScore rates how closely a poem follows the 五绝, 七绝, 五律 or 七律
+// form. All scores run from 0 to 100.
+type ProsodyScore struct {
+	Form       string   `json:"form"`
+	Length     int      `json:"length"`
+	Tone       int      `json:"tone"`
+	Rhyme      int      `json:"rhyme"`
+	Score      int      `json:"score"`
+	RhymeGroup string   `json:"rhymeGroup,omitempty"`
+	Notes      []string `json:"notes,omitempty"`
+}
+
+func loadPingshui() {
+	pingshui = make(map[rune]*toneEntry)
+	for _, line := range strings.Split(pingshuiData, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		group, tone, chars := fields[0], fields[1], fields[2]
+		for _, r := range chars {
+			entry := pingshui[r]
+			if entry == nil {
+				entry = &toneEntry{}
+				pingshui[r] = entry
+			}
+			if tone == "平" {
+				entry.level = true
+				entry.groups = append(entry.groups, group)
+			} else {
+				entry.oblique = true
+			}
+		}
+	}
+}
+
+func lookupTone(r rune) *toneEntry {
+	pingshuiOnce.Do(loadPingshui)
+	return pingshui[r]
+}
+
+// ScorePoem scores a poem's prosody unless its form is a ci tune, whose
+// tonal pattern differs from the regulated forms even when its lines happen
+// to have their shape.
+func ScorePoem(poem Poem) *ProsodyScore {
+	if form, ok := LookupPoemForm(poem.Form); ok && form.Kind == FormCi {
+		return nil
+	}
+	return ScoreProsody(poem.Content)
+}
+
+// ScoreProsody scores a poem against the regulated forms. It returns nil
+// for poems that are not four or eight lines of five or seven characters,
+// such as ci, which follow their tune instead.
+func ScoreProsody(content string) *ProsodyScore {
+	lines := make([][]rune, 0, 8)
+	for _, line := range poemLines(content) {
+		var chars []rune
+		for _, r := range line {
+			if unicode.Is(unicode.Han, r) {
+				chars = append(chars, r)
+			}
+		}
+		lines = append(lines, chars)
+	}
+	if len(lines) != 4 && len(lines) != 8 {
+		return nil
+	}
+
+	fives, sevens := 0, 0
+	for _, line := range lines {
+		switch len(line) {
+		case 5:
+			fives++
+		case 7:
+			sevens++
+		}
+	}
+	if fives == 0 && sevens == 0 {
+		return nil
+	}
+	size, matching := 5, fives
+	if sevens > fives {
+		size, matching = 7, sevens
+	}
+
+	s := &ProsodyScore{Form: prosodyForm(size, len(lines))}
+	s.Length = percent(matching, len(lines))
+	for i, line := range lines {
+		if len(line) != size {
+			s.note("line %d has %d characters instead of %d", i+1, len(line), size)
+		}
+	}
+	s.scoreTone(lines, size)
+	s.scoreRhyme(lines)
+	s.Score = (s.Length + s.Tone + s.Rhyme) / 3
+	return s
+}
+
+func prosodyForm(size, lines int) string {
+	form := map[int]string{5: "五", 7: "七"}[size]
+	if lines == 4 {
+		return form + "绝"
+	}
+	return form + "律"
+}
+
+// scoreTone compares the characters at the positions that must follow the
+// pattern, the even ones and the last (一三五不论，二四六分明), against the
+// template that fits best. Characters missing from the table are skipped.
+func (s *ProsodyScore) scoreTone(lines [][]rune, size int) {
+	var bestNotes []string
+	bestMatched, bestChecked := -1, 0
+
+	for _, quatrain := range quatrainTemplates {
+		var notes []string
+		matched, checked := 0, 0
+		for i, line := range lines {
+			if len(line) != size {
+				continue
+			}
+			pattern := []rune(quatrain[i%4])
+			if size == 7 {
+				pattern = append(oppositeTones(pattern[:2]), pattern...)
+			}
+			for pos := 1; pos < size; pos++ {
+				if pos%2 == 0 && pos != size-1 {
+					continue
+				}
+				entry := lookupTone(line[pos])
+				if entry == nil {
+					continue
+				}
+				checked++
+				want := pattern[pos]
+				if (want == '平' && entry.level) || (want == '仄' && entry.oblique) {
+					matched++
+				} else {
+					notes = append(notes, fmt.Sprintf("line %d, character %d %q should be %c", i+1, pos+1, line[pos], want))
+				}
+			}
+		}
+		if matched > bestMatched {
+			bestMatched, bestChecked, bestNotes = matched, checked, notes
+		}
+	}
+
+	s.Tone = percent(bestMatched, bestChecked)
+	for _, note := range bestNotes {
+		s.note("%s", note)
+	}
+}
+
+// scoreRhyme checks that the even lines, and the first line when it ends
+// on a level tone, end in characters of the same 平声 rhyme group.
+func (s *ProsodyScore) scoreRhyme(lines [][]rune) {
+	type rhyme struct {
+		line  int
+		char  rune
+		entry *toneEntry
+	}
+	var rhymes []rhyme
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		last := line[len(line)-1]
+		entry := lookupTone(last)
+		if i%2 == 1 || (i == 0 && entry != nil && entry.level && !entry.oblique) {
+			rhymes = append(rhymes, rhyme{i + 1, last, entry})
+		}
+	}
+
+	counts := map[string]int{}
+	for _, r := range rhymes {
+		if r.entry == nil {
+			continue
+		}
+		for _, group := range r.entry.groups {
+			counts[group]++
+		}
+	}
+	for group, n := range counts {
+		if n > counts[s.RhymeGroup] || (n == counts[s.RhymeGroup] && group < s.RhymeGroup) {
+			s.RhymeGroup = group
+		}
+	}
+
+	known := 0
+	for _, r := range rhymes {
+		if r.entry == nil {
+			continue
+		}
+		known++
+		if !r.entry.level {
+			s.note("line %d ends in %q, which is not a level tone", r.line, r.char)
+		} else if s.RhymeGroup != "" && !containsFold(r.entry.groups, s.RhymeGroup) {
+			s.note("line %d ends in %q, which does not rhyme in %s", r.line, r.char, s.RhymeGroup)
+		}
+	}
+	s.Rhyme = percent(counts[s.RhymeGroup], known)
+}
+
+func (s *ProsodyScore) note(format string, args ...interface{}) {
+	if len(s.Notes) < maxProsodyNotes {
+		s.Notes = append(s.Notes, fmt.Sprintf(format, args...))
+	}
+}
+
+func oppositeTones(pattern []rune) []rune {
+	out := make([]rune, len(pattern))
+	for i, tone := range pattern {
+		out[i] = '平'
+		if tone == '平' {
+			out[i] = '仄'
+		}
+	}
+	return out
+}
+
+// percent is n of total as a whole percentage; nothing to check scores 100.
+func percent(n, total int) int {
+	if total == 0 {
+		return 100
+	}
+	return n * 100 / total
+}
@@ -0,0 +1,104 @@
+package tools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// jingYeSi is Li Bai's 静夜思, an ancient-style quatrain whose tones break
+// the regulated pattern twice.
+const jingYeSi = "床前明月光，疑是地上霜。\n举头望明月，低头思故乡。"
+
+func TestScoreProsody(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    ProsodyScore // Notes are checked by substring below
+		notes   []string
+	}{
+		{
+			name:    "regulated wujue",
+			content: "白日依山尽，黄河入海流。\n欲穷千里目，更上一层楼。",
+			want:    ProsodyScore{Form: "五绝", Length: 100, Tone: 100, Rhyme: 100, Score: 100, RhymeGroup: "下平十一尤"},
+		},
+		{
+			name:    "regulated qijue",
+			content: "朝辞白帝彩云间，千里江陵一日还。\n两岸猿声啼不住，轻舟已过万重山。",
+			want:    ProsodyScore{Form: "七绝", Length: 100, Tone: 100, Rhyme: 100, Score: 100, RhymeGroup: "上平十五删"},
+		},
+		{
+			name:    "regulated wulv",
+			content: "国破山河在，城春草木深。\n感时花溅泪，恨别鸟惊心。\n烽火连三月，家书抵万金。\n白头搔更短，浑欲不胜簪。",
+			want:    ProsodyScore{Form: "五律", Length: 100, Tone: 100, Rhyme: 100, Score: 100, RhymeGroup: "下平十二侵"},
+		},
+		{
+			name:    "ancient-style tones",
+			content: jingYeSi,
+			want:    ProsodyScore{Form: "五绝", Length: 100, Tone: 83, Rhyme: 100, Score: 94, RhymeGroup: "下平七阳"},
+			notes:   []string{"line 2, character 4 '上' should be 平", "line 3, character 2 '头' should be 仄"},
+		},
+		{
+			name:    "line of the wrong length",
+			content: "床前明月光，疑是地上霜。\n举头望明月，低头思故乡啊。",
+			want:    ProsodyScore{Form: "五绝", Length: 75, Tone: 77, Rhyme: 100, Score: 84, RhymeGroup: "下平七阳"},
+			notes:   []string{"line 4 has 6 characters instead of 5", "'上' should be 平", "'头' should be 仄"},
+		},
+		{
+			name:    "oblique rhyme",
+			content: "白日依山尽，黄河入海流。\n欲穷千里目，更上一层里。",
+			want:    ProsodyScore{Form: "五绝", Length: 100, Tone: 91, Rhyme: 50, Score: 80, RhymeGroup: "下平十一尤"},
+			notes:   []string{"line 4, character 5 '里' should be 平", "line 4 ends in '里', which is not a level tone"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ScoreProsody(tt.content)
+			if got == nil {
+				t.Fatal("ScoreProsody() = nil")
+			}
+			notes := got.Notes
+			got.Notes = nil
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("ScoreProsody() = %+v, want %+v", *got, tt.want)
+			}
+			if len(notes) != len(tt.notes) {
+				t.Fatalf("notes = %q, want %q", notes, tt.notes)
+			}
+			for i, want := range tt.notes {
+				if !strings.Contains(notes[i], want) {
+					t.Errorf("note %d = %q, want it to contain %q", i, notes[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestScoreProsodyUnscored(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"two lines", "床前明月光，疑是地上霜。"},
+		{"six lines", "一二三四五，一二三四五。\n一二三四五，一二三四五。\n一二三四五，一二三四五。"},
+		{"no five or seven character lines", "一二三四，一二三四。\n一二三四，一二三四。"},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		if got := ScoreProsody(tt.content); got != nil {
+			t.Errorf("%s: ScoreProsody() = %+v, want nil", tt.name, *got)
+		}
+	}
+}
+
+func TestScorePoemSkipsCi(t *testing.T) {
+	if got := ScorePoem(Poem{Form: "卜算子", Content: jingYeSi}); got != nil {
+		t.Errorf("ScorePoem(ci) = %+v, want nil", *got)
+	}
+	if got := ScorePoem(Poem{Form: "五言绝句", Content: jingYeSi}); got == nil || got.Score != 94 {
+		t.Errorf("ScorePoem(shi) = %+v, want a score of 94", got)
+	}
+	if got := ScorePoem(Poem{Content: jingYeSi}); got == nil {
+		t.Error("ScorePoem(no form) = nil, want a score")
+	}
+}
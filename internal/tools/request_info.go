@@ -0,0 +1,19 @@
+package tools
+
+// RequestInfo identifies the HTTP request on whose behalf the model is
+// calling tools. It is passed down to the database layer so statements can
+// be attributed to the request that issued them.
+type RequestInfo struct {
+	RequestID string
+	Method    string
+	Route     string
+	ClientIP  string
+	Provider  string
+	Model     string
+	// Tenant scopes the request's queries when tenancy is enabled.
+	Tenant string
+
+	// wrote is set once the request has sent a write to the primary, after
+	// which its reads skip the replica so they see their own writes.
+	wrote bool
+}
@@ -0,0 +1,193 @@
+package tools
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	poemSearchDefaultLimit = 20
+	poemSearchMaxLimit     = 100
+	// poemSearchCandidates bounds the rows ranked in Go by the LIKE fallback.
+	poemSearchCandidates = 500
+	snippetRadius        = 30
+)
+
+var poemSearchColumns = []string{"title", "content", "author"}
+
+// PoemSearchResult is one ranked search hit. TitleHighlight and Snippet are
+// HTML with the matched terms wrapped in <mark>.
+type PoemSearchResult struct {
+	ID             interface{} `json:"id"`
+	Title          string      `json:"title"`
+	Author         string      `json:"author"`
+	Dynasty        string      `json:"dynasty"`
+	Score          float64     `json:"score"`
+	TitleHighlight string      `json:"titleHighlight"`
+	Snippet        string      `json:"snippet"`
+}
+
+// SearchPoems finds poems whose title, content or author match the query,
+// best matches first. It uses the full-text index where the backend has one
+// and ranks LIKE matches otherwise. Before the first poem is stored there is
+// no poems table and nothing matches.
+func SearchPoems(info *RequestInfo, q string, limit int) ([]PoemSearchResult, error) {
+	terms := strings.Fields(q)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("search query is empty")
+	}
+	if limit <= 0 || limit > poemSearchMaxLimit {
+		limit = poemSearchDefaultLimit
+	}
+	exists, err := poemsTableExists(info)
+	if err != nil || !exists {
+		return []PoemSearchResult{}, err
+	}
+
+	var rows []map[string]interface{}
+	fullText := false
+	if score := dialect.FullTextScore(poemSearchColumns); score != "" {
+		query := fmt.Sprintf("SELECT id, title, author, dynasty, content, %s AS score FROM poems WHERE %s > 0 ORDER BY score DESC LIMIT %d",
+			score, score, limit)
+		result := ExecuteDatabaseQuery(info, query, []interface{}{q, q}, "query")
+		// Without the index, or for terms shorter than the ngram size,
+		// fall back to LIKE matching
+		if result.Success && len(result.Rows) > 0 {
+			rows = result.Rows
+			fullText = true
+		}
+	}
+
+	if !fullText {
+		var conditions []string
+		var params []interface{}
+		for _, term := range terms {
+			pattern := "%" + escapeLike(term) + "%"
+			for _, column := range poemSearchColumns {
+				conditions = append(conditions, column+" LIKE ? ESCAPE '!'")
+				params = append(params, pattern)
+			}
+		}
+		query := fmt.Sprintf("SELECT id, title, author, dynasty, content FROM poems WHERE %s LIMIT %d",
+			strings.Join(conditions, " OR "), poemSearchCandidates)
+		result := ExecuteDatabaseQuery(info, query, params, "query")
+		if !result.Success {
+			return nil, fmt.Errorf("%s", result.Error)
+		}
+		rows = result.Rows
+	}
+
+	matcher := termPattern(terms)
+	results := make([]PoemSearchResult, 0, len(rows))
+	for _, row := range rows {
+		r := PoemSearchResult{
+			ID:      row["id"],
+			Title:   stringValue(row["title"]),
+			Author:  stringValue(row["author"]),
+			Dynasty: stringValue(row["dynasty"]),
+		}
+		content := stringValue(row["content"])
+		if fullText {
+			r.Score = floatValue(row["score"])
+		} else {
+			// Title hits weigh most, then author, then content
+			r.Score = float64(3*len(matcher.FindAllStringIndex(r.Title, -1)) +
+				2*len(matcher.FindAllStringIndex(r.Author, -1)) +
+				len(matcher.FindAllStringIndex(content, -1)))
+		}
+		r.TitleHighlight = highlight(r.Title, matcher)
+		r.Snippet = snippet(content, matcher)
+		results = append(results, r)
+	}
+
+	if !fullText {
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+		if len(results) > limit {
+			results = results[:limit]
+		}
+	}
+	return results, nil
+}
+
+func escapeLike(term string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(term)
+}
+
+// termPattern matches any of the terms, case-insensitively, longest first.
+func termPattern(terms []string) *regexp.Regexp {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
+
+// highlight HTML-escapes text and wraps every match in <mark>.
+func highlight(text string, matcher *regexp.Regexp) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range matcher.FindAllStringIndex(text, -1) {
+		b.WriteString(html.EscapeString(text[last:m[0]]))
+		b.WriteString("<mark>" + html.EscapeString(text[m[0]:m[1]]) + "</mark>")
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// snippet cuts the content around the first match and highlights it. Line
+// breaks are shown as " / ", as is usual when quoting verse inline.
+func snippet(content string, matcher *regexp.Regexp) string {
+	content = strings.Join(strings.Fields(strings.ReplaceAll(content, "\n", " / ")), " ")
+	runes := []rune(content)
+
+	start := 0
+	if m := matcher.FindStringIndex(content); m != nil {
+		start = len([]rune(content[:m[0]])) - snippetRadius
+	}
+	if start < 0 {
+		start = 0
+	}
+	end := start + 2*snippetRadius
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	text := highlight(string(runes[start:end]), matcher)
+	if start > 0 {
+		text = "…" + text
+	}
+	if end < len(runes) {
+		text += "…"
+	}
+	return text
+}
+
+func stringValue(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+func floatValue(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int64:
+		return float64(n)
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return f
+	}
+	return 0
+}
@@ -0,0 +1,21 @@
+package tools
+
+import "testing"
+
+func TestSearchPoemsWithoutPoemsTable(t *testing.T) {
+	useSQLiteTenancy(t, 4)
+
+	results, err := SearchPoems(&RequestInfo{Tenant: "fresh"}, "明月", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results == nil || len(results) != 0 {
+		t.Errorf("SearchPoems() = %#v, want an empty list", results)
+	}
+}
+
+func TestSearchPoemsEmptyQuery(t *testing.T) {
+	if _, err := SearchPoems(nil, "  ", 10); err == nil {
+		t.Error("SearchPoems() with a blank query succeeded")
+	}
+}
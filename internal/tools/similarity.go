@@ -0,0 +1,150 @@
+package tools
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//go:embed data/famous_poems.txt
+var famousPoemsData string
+
+// similarityWindow is how many of the newest poems a new poem is compared
+// against. Older poems are unlikely to be repeated and reading the whole
+// table on every generation would not scale.
+const similarityWindow = 1000
+
+// SimilarityMatch is the closest existing or famous poem found for a poem.
+// Score runs from 0 (nothing in common) to 100 (the same text).
+type SimilarityMatch struct {
+	Score       int    `json:"score"`
+	Source      string `json:"source,omitempty"` // "poems" or "corpus"
+	PoemID      int64  `json:"poemId,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Fingerprint string `json:"-"`
+}
+
+// famousPoem is a poem of the bundled corpus, with its text prepared for
+// comparison.
+type famousPoem struct {
+	title, author string
+	fingerprint   string
+	grams         map[string]bool
+}
+
+var (
+	famousPoems     []famousPoem
+	famousPoemsOnce sync.Once
+)
+
+func loadFamousPoems() {
+	for _, line := range strings.Split(famousPoemsData, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(strings.TrimSpace(line), "|")
+		if len(fields) != 4 {
+			continue
+		}
+		famousPoems = append(famousPoems, famousPoem{
+			title:       fields[0],
+			author:      fields[1],
+			fingerprint: poemFingerprint(fields[3]),
+			grams:       poemBigrams(fields[3]),
+		})
+	}
+}
+
+// CheckDuplicate compares a poem with the newest poems of the request's
+// database and with the famous poem corpus, and returns the closest match.
+// An exact copy, ignoring punctuation and layout, scores 100.
+func CheckDuplicate(info *RequestInfo, content string) (*SimilarityMatch, error) {
+	famousPoemsOnce.Do(loadFamousPoems)
+	fingerprint := poemFingerprint(content)
+	grams := poemBigrams(content)
+	best := &SimilarityMatch{Fingerprint: fingerprint}
+
+	consider := func(source string, id int64, title, author, otherFingerprint string, other map[string]bool) {
+		score := bigramSimilarity(grams, other)
+		if otherFingerprint == fingerprint {
+			score = 100
+		}
+		if score > best.Score {
+			*best = SimilarityMatch{Score: score, Source: source, PoemID: id, Title: title, Author: author, Fingerprint: fingerprint}
+		}
+	}
+
+	for _, p := range famousPoems {
+		consider("corpus", 0, p.title, p.author, p.fingerprint, p.grams)
+	}
+
+	exists, err := poemsTableExists(info)
+	if err != nil || !exists {
+		return best, err
+	}
+	result := ExecuteDatabaseQuery(info, fmt.Sprintf("SELECT id, title, author, content FROM poems ORDER BY id DESC LIMIT %d", similarityWindow), nil, "query")
+	if !result.Success {
+		return best, fmt.Errorf("%s", result.Error)
+	}
+	for _, row := range result.Rows {
+		other := stringValue(row["content"])
+		consider("poems", int64Value(row["id"]), stringValue(row["title"]), stringValue(row["author"]), poemFingerprint(other), poemBigrams(other))
+	}
+	return best, nil
+}
+
+// poemFingerprint hashes the Han characters of a poem, so copies that only
+// differ in punctuation, spacing or line breaks share a fingerprint.
+func poemFingerprint(content string) string {
+	var b strings.Builder
+	for _, r := range content {
+		if unicode.Is(unicode.Han, r) {
+			b.WriteRune(r)
+		}
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// poemBigrams returns the set of two-character sequences within the lines
+// of a poem. Pairs across line breaks are left out, so reordering the lines
+// of a poem does not make it look new.
+func poemBigrams(content string) map[string]bool {
+	grams := make(map[string]bool)
+	for _, line := range poemLines(content) {
+		var chars []rune
+		for _, r := range line {
+			if unicode.Is(unicode.Han, r) {
+				chars = append(chars, r)
+			}
+		}
+		for i := 0; i+1 < len(chars); i++ {
+			grams[string(chars[i:i+2])] = true
+		}
+	}
+	return grams
+}
+
+// bigramSimilarity is the share of the smaller set found in the larger one,
+// as a percentage. Using the smaller set catches a famous quatrain padded
+// out with lines of its own as well as a poem trimmed from a longer one.
+func bigramSimilarity(a, b map[string]bool) int {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	if len(a) == 0 {
+		return 0
+	}
+	shared := 0
+	for gram := range a {
+		if b[gram] {
+			shared++
+		}
+	}
+	return shared * 100 / len(a)
+}
@@ -0,0 +1,93 @@
+package tools
+
+import "testing"
+
+func TestPoemFingerprint(t *testing.T) {
+	base := poemFingerprint(jingYeSi)
+	tests := []struct {
+		name    string
+		content string
+		same    bool
+	}{
+		{"identical", jingYeSi, true},
+		{"one row", "床前明月光，疑是地上霜。举头望明月，低头思故乡。", true},
+		{"no punctuation", "床前明月光\n疑是地上霜\n举头望明月\n低头思故乡", true},
+		{"other punctuation and spaces", "床前明月光, 疑是地上霜.\r\n\n  举头望明月! 低头思故乡?", true},
+		{"latin letters ignored", "床前明月光 abc 疑是地上霜举头望明月低头思故乡", true},
+		{"one character changed", "床前明月光，疑是地上霜。\n举头望明月，低头思故园。", false},
+		{"lines reordered", "举头望明月，低头思故乡。\n床前明月光，疑是地上霜。", false},
+	}
+	for _, tt := range tests {
+		if got := poemFingerprint(tt.content) == base; got != tt.same {
+			t.Errorf("%s: same fingerprint = %v, want %v", tt.name, got, tt.same)
+		}
+	}
+}
+
+func TestPoemBigrams(t *testing.T) {
+	grams := poemBigrams(jingYeSi)
+	// Four lines of four pairs, with 明月 twice.
+	if len(grams) != 15 {
+		t.Errorf("len(poemBigrams()) = %d, want 15", len(grams))
+	}
+	for _, gram := range []string{"床前", "明月", "故乡"} {
+		if !grams[gram] {
+			t.Errorf("poemBigrams() is missing %q", gram)
+		}
+	}
+	for _, gram := range []string{"光疑", "霜举", "月低"} {
+		if grams[gram] {
+			t.Errorf("poemBigrams() has %q, which spans a line break", gram)
+		}
+	}
+	if got := poemBigrams("，。\n"); len(got) != 0 {
+		t.Errorf("poemBigrams(punctuation) = %v, want none", got)
+	}
+}
+
+func TestBigramSimilarity(t *testing.T) {
+	poem := poemBigrams(jingYeSi)
+	tests := []struct {
+		name  string
+		a, b  string
+		score int
+	}{
+		{"same poem", jingYeSi, jingYeSi, 100},
+		{"lines reordered", jingYeSi, "举头望明月，低头思故乡。\n床前明月光，疑是地上霜。", 100},
+		{"padded with new lines", jingYeSi, jingYeSi + "\n春眠不觉晓，处处闻啼鸟。", 100},
+		{"first half only", jingYeSi, "床前明月光，疑是地上霜。", 100},
+		{"one character changed", jingYeSi, "床前明月光，疑是地上霜。\n举头望明月，低头思故园。", 93},
+		{"unrelated", jingYeSi, "白日依山尽，黄河入海流。", 0},
+		{"empty", jingYeSi, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := poemBigrams(tt.a), poemBigrams(tt.b)
+			if got := bigramSimilarity(a, b); got != tt.score {
+				t.Errorf("bigramSimilarity() = %d, want %d", got, tt.score)
+			}
+			if got := bigramSimilarity(b, a); got != tt.score {
+				t.Errorf("bigramSimilarity() reversed = %d, want %d", got, tt.score)
+			}
+		})
+	}
+	if got := bigramSimilarity(poem, nil); got != 0 {
+		t.Errorf("bigramSimilarity(poem, nil) = %d, want 0", got)
+	}
+}
+
+func TestFamousPoemsCorpus(t *testing.T) {
+	famousPoemsOnce.Do(loadFamousPoems)
+	if len(famousPoems) == 0 {
+		t.Fatal("the famous poem corpus is empty")
+	}
+	fingerprint := poemFingerprint(jingYeSi)
+	for _, p := range famousPoems {
+		if p.title == "" || p.author == "" || len(p.grams) == 0 {
+			t.Errorf("corpus entry %+v is incomplete", p)
+		}
+		if p.title == "静夜思" && p.fingerprint != fingerprint {
+			t.Error("静夜思 in the corpus does not match its copy by fingerprint")
+		}
+	}
+}
@@ -0,0 +1,469 @@
+package tools
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+var (
+	snapshotDir   = "snapshots"
+	snapshotKeep  = 50
+	snapshotMutex sync.Mutex
+)
+
+var (
+	destructivePattern = regexp.MustCompile(`(?i)^\s*(DROP|ALTER|TRUNCATE|DELETE|UPDATE)\b`)
+	wherePattern       = regexp.MustCompile(`(?i)\bWHERE\b`)
+	quotedPattern      = regexp.MustCompile(`'(?:[^']|'')*'|"(?:[^"]|"")*"|` + "`[^`]*`")
+)
+
+// poemLinkedTables are the internal tables keyed by poem id. They are
+// snapshotted and restored together with the poems table, so that scores,
+// ratings, tags, collections and sources keep pointing at the same poems.
+var poemLinkedTables = []struct {
+	name   string
+	ensure func(*sql.DB) error
+}{
+	{prosodyTable, ensureProsodyTable},
+	{similarityTable, ensureSimilarityTable},
+	{ratingsTable, ensureRatingsTable},
+	{tagsTable, ensureTagsTable},
+	{collectionsTable, ensureCollectionsTables},
+	{collectionItemsTable, ensureCollectionsTables},
+	{sourcesTable, ensureSourcesTable},
+}
+
+// Snapshot is a logical dump of the application tables and, with the poems
+// table, of the internal tables linked to it.
+type Snapshot struct {
+	Name      string          `json:"name"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Reason    string          `json:"reason"`
+	Tenant    string          `json:"tenant,omitempty"`
+	Dialect   string          `json:"dialect"`
+	Tables    []SnapshotTable `json:"tables"`
+}
+
+// SnapshotTable holds the DDL and every row of one table. Internal tables
+// are recreated by nokode itself rather than from Create.
+type SnapshotTable struct {
+	Name     string          `json:"name"`
+	Create   string          `json:"create"`
+	Internal bool            `json:"internal,omitempty"`
+	Columns  []string        `json:"columns"`
+	Types    []string        `json:"types,omitempty"`
+	Rows     [][]interface{} `json:"rows"`
+}
+
+// SnapshotInfo describes a snapshot file without loading its rows.
+type SnapshotInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// InitSnapshots configures where snapshots are written and starts the
+// periodic snapshot schedule if one is configured.
+func InitSnapshots(cfg *config.Config) error {
+	if cfg.Snapshots.Dir != "" {
+		snapshotDir = cfg.Snapshots.Dir
+	}
+	if cfg.Snapshots.Keep > 0 {
+		snapshotKeep = cfg.Snapshots.Keep
+	}
+	if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+		utils.Log.Error("snapshot", "Failed to create snapshot directory", err)
+		return err
+	}
+
+	if cfg.Snapshots.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Snapshots.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid snapshot interval %q: %w", cfg.Snapshots.Interval, err)
+		}
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				if _, err := TakeSnapshot("", "scheduled"); err != nil {
+					utils.Log.Error("snapshot", "Scheduled snapshot failed", err)
+				}
+				for _, tenant := range openTenants() {
+					if _, err := TakeSnapshot(tenant, "scheduled"); err != nil {
+						utils.Log.Error("snapshot", "Scheduled snapshot of tenant "+tenant+" failed", err)
+					}
+				}
+			}
+		}()
+		utils.Log.Info("snapshot", fmt.Sprintf("Taking scheduled snapshots every %s", interval), nil)
+	}
+	return nil
+}
+
+// destructiveKind returns the lowercased verb of the first statement that
+// can lose data (DROP, ALTER, TRUNCATE, and DELETE or UPDATE without a WHERE
+// clause), or "" if the query is safe.
+func destructiveKind(query string) string {
+	for _, statement := range splitStatements(query) {
+		m := destructivePattern.FindStringSubmatch(statement)
+		if m == nil {
+			continue
+		}
+		kind := strings.ToLower(m[1])
+		switch kind {
+		case "delete", "update":
+			if !wherePattern.MatchString(quotedPattern.ReplaceAllString(statement, "''")) {
+				return kind
+			}
+		default:
+			return kind
+		}
+	}
+	return ""
+}
+
+// TakeSnapshot dumps every application table to a new snapshot file and
+// prunes old snapshots beyond the retention limit. An empty tenant
+// snapshots the shared database.
+func TakeSnapshot(tenant, reason string) (string, error) {
+	pool, release, err := tenantPool(&RequestInfo{Tenant: tenant})
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return takeSnapshot(pool, tenant, reason)
+}
+
+func takeSnapshot(pool *sql.DB, tenant, reason string) (string, error) {
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+
+	tables, err := applicationTables(pool)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	label := reason
+	if tenant != "" {
+		label = tenant + "_" + reason
+	}
+	snapshot := Snapshot{
+		Name:      fmt.Sprintf("%s_%s", now.Format("20060102T150405.000Z"), slugPattern.ReplaceAllString(strings.ToLower(label), "-")),
+		CreatedAt: now,
+		Reason:    reason,
+		Tenant:    tenant,
+		Dialect:   dialect.Name(),
+	}
+	for _, table := range tables {
+		dump, err := dumpTable(pool, table)
+		if err != nil {
+			return "", fmt.Errorf("failed to dump %s: %w", table, err)
+		}
+		snapshot.Tables = append(snapshot.Tables, dump)
+	}
+	if containsFold(tables, "poems") {
+		for _, linked := range poemLinkedTables {
+			if err := linked.ensure(pool); err != nil {
+				return "", fmt.Errorf("failed to create %s: %w", linked.name, err)
+			}
+			dump, err := dumpTable(pool, linked.name)
+			if err != nil {
+				return "", fmt.Errorf("failed to dump %s: %w", linked.name, err)
+			}
+			dump.Internal = true
+			snapshot.Tables = append(snapshot.Tables, dump)
+		}
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(snapshotDir, snapshot.Name+".json")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return "", err
+	}
+
+	utils.Log.Success("snapshot", fmt.Sprintf("Snapshot %s written (%d tables)", snapshot.Name, len(snapshot.Tables)), nil)
+	pruneSnapshots()
+	return snapshot.Name, nil
+}
+
+func dumpTable(pool *sql.DB, table string) (SnapshotTable, error) {
+	dump := SnapshotTable{Name: table, Rows: [][]interface{}{}}
+
+	createStmt, err := dialect.CreateTable(pool, table)
+	if err != nil {
+		return dump, err
+	}
+	dump.Create = createStmt
+
+	rows, err := pool.Query("SELECT * FROM " + table)
+	if err != nil {
+		return dump, err
+	}
+	defer rows.Close()
+
+	dump.Columns, err = rows.Columns()
+	if err != nil {
+		return dump, err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return dump, err
+	}
+	for _, t := range types {
+		dump.Types = append(dump.Types, t.DatabaseTypeName())
+	}
+
+	for rows.Next() {
+		values := make([]interface{}, len(dump.Columns))
+		valuePtrs := make([]interface{}, len(dump.Columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return dump, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		dump.Rows = append(dump.Rows, values)
+	}
+	return dump, rows.Err()
+}
+
+// ListSnapshots returns the available snapshots, newest first.
+func ListSnapshots() ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(snapshotDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []SnapshotInfo
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, SnapshotInfo{
+			Name: strings.TrimSuffix(entry.Name(), ".json"),
+			Size: info.Size(),
+		})
+	}
+	// Names start with a UTC timestamp, so they sort chronologically
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name > snapshots[j].Name })
+	return snapshots, nil
+}
+
+func pruneSnapshots() {
+	snapshots, err := ListSnapshots()
+	if err != nil {
+		return
+	}
+	for i := snapshotKeep; i < len(snapshots); i++ {
+		os.Remove(filepath.Join(snapshotDir, snapshots[i].Name+".json"))
+	}
+}
+
+// RestoreSnapshot replaces the contents of every table in the snapshot with
+// the snapshot rows, recreating tables that were dropped since. When the
+// poems table is restored, the internal tables linked to it are restored
+// too, or cleared if the snapshot predates them. A safety snapshot of the
+// current state is taken first. The statements are recorded in the audit
+// log on behalf of info, with one entry per restored table for the inserts.
+func RestoreSnapshot(info *RequestInfo, name string) error {
+	data, err := os.ReadFile(filepath.Join(snapshotDir, filepath.Base(strings.TrimSuffix(name, ".json"))+".json"))
+	if err != nil {
+		return err
+	}
+
+	var snapshot Snapshot
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&snapshot); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	if snapshot.Dialect != dialect.Name() {
+		return fmt.Errorf("snapshot was taken on %s, cannot restore into %s", snapshot.Dialect, dialect.Name())
+	}
+
+	if snapshot.Tenant != "" && !TenancyEnabled() {
+		return fmt.Errorf("snapshot belongs to tenant %s but tenancy is disabled", snapshot.Tenant)
+	}
+	pool, release, err := tenantPool(&RequestInfo{Tenant: snapshot.Tenant})
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if _, err := takeSnapshot(pool, snapshot.Tenant, "pre-restore"); err != nil {
+		return fmt.Errorf("failed to take pre-restore snapshot: %w", err)
+	}
+
+	existing, err := applicationTables(pool)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool)
+	for _, table := range existing {
+		present[strings.ToLower(table)] = true
+	}
+
+	snapshotMutex.Lock()
+	defer snapshotMutex.Unlock()
+
+	// DDL cannot be rolled back on MySQL, so recreate missing tables first
+	restoresPoems := false
+	var recreated []string
+	for _, table := range snapshot.Tables {
+		if table.Internal {
+			continue
+		}
+		if strings.EqualFold(table.Name, "poems") {
+			restoresPoems = true
+		}
+		if !present[strings.ToLower(table.Name)] {
+			result := executeQuery(pool, table.Create, nil, "exec")
+			recordAudit(info, table.Create, nil, "exec", result)
+			if !result.Success {
+				return fmt.Errorf("failed to recreate %s: %s", table.Name, result.Error)
+			}
+			recreated = append(recreated, table.Name)
+		}
+	}
+	tables := snapshot.Tables
+	if restoresPoems {
+		for _, linked := range poemLinkedTables {
+			if err := linked.ensure(pool); err != nil {
+				return fmt.Errorf("failed to create %s: %w", linked.name, err)
+			}
+			if !snapshotHasTable(snapshot, linked.name) {
+				tables = append(tables, SnapshotTable{Name: linked.name, Internal: true})
+			}
+		}
+	} else {
+		// Without the poems they belong to, internal rows are left alone
+		tables = nil
+		for _, table := range snapshot.Tables {
+			if !table.Internal {
+				tables = append(tables, table)
+			}
+		}
+	}
+
+	tx, err := pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range tables {
+		deleteAll := "DELETE FROM " + table.Name
+		result := executeQuery(tx, deleteAll, nil, "exec")
+		recordAudit(info, deleteAll, nil, "exec", result)
+		if !result.Success {
+			return fmt.Errorf("failed to clear %s: %s", table.Name, result.Error)
+		}
+		if len(table.Rows) == 0 {
+			continue
+		}
+
+		insert := dialect.Rebind(insertStatement(table.Name, table.Columns))
+		start := time.Now()
+		for _, row := range table.Rows {
+			for i, v := range row {
+				var typ string
+				if i < len(table.Types) {
+					typ = table.Types[i]
+				}
+				row[i] = snapshotValue(v, typ)
+			}
+			if _, err := tx.Exec(insert, row...); err != nil {
+				recordAudit(info, insert, nil, "exec", DatabaseResult{Error: err.Error(), Duration: time.Since(start).Milliseconds()})
+				return fmt.Errorf("failed to restore %s: %w", table.Name, err)
+			}
+		}
+		recordAudit(info, insert, nil, "exec", DatabaseResult{Success: true, Changes: int64(len(table.Rows)), Duration: time.Since(start).Milliseconds()})
+		if pg, ok := dialect.(postgresDialect); ok {
+			if err := pg.resetSequences(tx, table.Name, table.Columns); err != nil {
+				return fmt.Errorf("failed to reset sequences of %s: %w", table.Name, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Recreated tables have the schema they had when the snapshot was taken
+	if snapshot.Tenant == "" && len(recreated) > 0 {
+		if err := forgetManagedMigrations(recreated, snapshot.CreatedAt); err != nil {
+			utils.Log.Error("snapshot", "Failed to reset managed migrations", err)
+		}
+		applyManagedMigrations()
+	}
+	reloadSchema(&RequestInfo{Tenant: snapshot.Tenant})
+	utils.Log.Success("snapshot", fmt.Sprintf("Restored snapshot %s", snapshot.Name), nil)
+	return nil
+}
+
+func snapshotHasTable(snapshot Snapshot, name string) bool {
+	for _, table := range snapshot.Tables {
+		if strings.EqualFold(table.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotValue turns a value read back from snapshot JSON into a query
+// parameter: numbers become int64 or float64, and timestamps time.Time,
+// since MySQL rejects the RFC 3339 form in strict mode. Snapshots taken
+// before column types were recorded have every RFC 3339 string parsed.
+func snapshotValue(v interface{}, typ string) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if iv, err := v.Int64(); err == nil {
+			return iv
+		}
+		if fv, err := v.Float64(); err == nil {
+			return fv
+		}
+	case string:
+		if typ != "" && !isTimeType(typ) {
+			return v
+		}
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+	}
+	return v
+}
+
+// isTimeType reports whether a database type name holds dates or times of
+// day, e.g. DATETIME, TIMESTAMP, TIMESTAMPTZ or DATE.
+func isTimeType(typ string) bool {
+	typ = strings.ToUpper(typ)
+	return strings.Contains(typ, "DATE") || strings.Contains(typ, "TIMESTAMP")
+}
@@ -0,0 +1,72 @@
+package tools
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDestructiveKind(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM poems", ""},
+		{"INSERT INTO poems (title) VALUES ('x')", ""},
+		{"DELETE FROM poems WHERE id = 1", ""},
+		{"UPDATE poems SET title = 'x' WHERE id = 1", ""},
+		{"DELETE FROM poems", "delete"},
+		{"delete from poems;", "delete"},
+		{"UPDATE poems SET title = 'x'", "update"},
+		{"UPDATE poems SET title = 'WHERE'", "update"},
+		{`UPDATE poems SET "where" = 1`, "update"},
+		{"DROP TABLE poems", "drop"},
+		{"ALTER TABLE poems ADD COLUMN form VARCHAR(50)", "alter"},
+		{"TRUNCATE TABLE poems", "truncate"},
+		{"SELECT 1; DROP TABLE poems", "drop"},
+		{"-- DROP TABLE poems\nSELECT 1", ""},
+		{"INSERT INTO notes (body) VALUES ('DROP TABLE poems')", ""},
+	}
+	for _, tt := range tests {
+		if got := destructiveKind(tt.query); got != tt.want {
+			t.Errorf("destructiveKind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSnapshotValue(t *testing.T) {
+	stamp := time.Date(2026, 10, 18, 14, 23, 29, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value interface{}
+		typ   string
+		want  interface{}
+	}{
+		{"integer", json.Number("42"), "BIGINT", int64(42)},
+		{"float", json.Number("4.5"), "REAL", 4.5},
+		{"timestamp column", "2026-10-18T14:23:29Z", "TIMESTAMP", stamp},
+		{"datetime column", "2026-10-18T14:23:29Z", "DATETIME", stamp},
+		{"timestamptz column", "2026-10-18T14:23:29Z", "TIMESTAMPTZ", stamp},
+		{"text column keeps time-like text", "2026-10-18T14:23:29Z", "TEXT", "2026-10-18T14:23:29Z"},
+		{"untyped time", "2026-10-18T14:23:29Z", "", stamp},
+		{"untyped text", "床前明月光", "", "床前明月光"},
+		{"bad time in time column", "yesterday", "TIMESTAMP", "yesterday"},
+		{"null", nil, "TEXT", nil},
+		{"bool", true, "BOOLEAN", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := snapshotValue(tt.value, tt.typ)
+			if want, ok := tt.want.(time.Time); ok {
+				if got, ok := got.(time.Time); !ok || !got.Equal(want) {
+					t.Errorf("snapshotValue(%v, %q) = %#v, want %v", tt.value, tt.typ, got, want)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("snapshotValue(%v, %q) = %#v, want %#v", tt.value, tt.typ, got, tt.want)
+			}
+		})
+	}
+}
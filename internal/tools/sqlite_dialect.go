@@ -0,0 +1,217 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nokode/nokode/internal/config"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	sqliteScanPattern = regexp.MustCompile(`^SCAN (?:TABLE )?(.+?)(?: USING .*)?$`)
+	// sqliteAliasPattern matches a table in a FROM or JOIN clause, or after
+	// a comma, and the word following it, which may be its alias.
+	sqliteAliasPattern = regexp.MustCompile(`(?i)(?:\bFROM|\bJOIN|,)\s+("[^"]+"|` + "`[^`]+`" + `|\[[^\]]+\]|[\w.]+)(?:\s+(?:AS\s+)?("[^"]+"|` + "`[^`]+`" + `|\[[^\]]+\]|\w+))?`)
+)
+
+// sqliteClauseKeywords can follow a table in a FROM clause, so they are not
+// taken as its alias.
+var sqliteClauseKeywords = map[string]bool{
+	"CROSS": true, "EXCEPT": true, "FULL": true, "GROUP": true, "HAVING": true, "INDEXED": true,
+	"INNER": true, "INTERSECT": true, "JOIN": true, "LEFT": true, "LIMIT": true, "NATURAL": true,
+	"NOT": true, "ON": true, "ORDER": true, "OUTER": true, "RETURNING": true, "RIGHT": true,
+	"SET": true, "UNION": true, "USING": true, "WHERE": true, "WINDOW": true,
+}
+
+// sqliteDialect stores everything in a single local file, so nokode can run
+// without a database server. The driver is pure Go, so builds need no cgo.
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string               { return "SQLite" }
+func (sqliteDialect) DriverName() string         { return "sqlite" }
+func (sqliteDialect) RandomFunc() string         { return "RANDOM()" }
+func (sqliteDialect) AutoIncrementKey() string   { return "INTEGER PRIMARY KEY AUTOINCREMENT" }
+func (sqliteDialect) LongText() string           { return "TEXT" }
+func (sqliteDialect) Rebind(query string) string { return query }
+
+func (sqliteDialect) Upsert(table string, columns, key, update []string) string {
+	return upsertOnConflict(table, columns, key, update)
+}
+
+func (sqliteDialect) DSN(cfg *config.Config) (string, error) {
+	path := cfg.Database.Path
+	if path == "" {
+		path = "nokode.db"
+	}
+	if path != ":memory:" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return "", err
+		}
+	}
+	query := url.Values{}
+	query.Add("_pragma", "foreign_keys(1)")
+	query.Add("_pragma", "busy_timeout(5000)")
+	// Write times as SQLite's own date functions and earlier versions do
+	query.Set("_time_format", "sqlite")
+	for key, value := range cfg.Database.Params {
+		if key == "_pragma" {
+			// Pragmas add to the defaults rather than replacing them
+			query.Add(key, value)
+			continue
+		}
+		query.Set(key, value)
+	}
+	return "file:" + path + "?" + query.Encode(), nil
+}
+
+// TenantDSN stores every tenant in its own file next to the shared one,
+// e.g. nokode_acme.db; the file is created when first opened.
+func (d sqliteDialect) TenantDSN(db *sql.DB, cfg *config.Config, tenant string) (string, error) {
+	if cfg.Database.Path == ":memory:" {
+		return "", fmt.Errorf("tenancy needs a database file, not :memory:")
+	}
+	path := cfg.Database.Path
+	if path == "" {
+		path = "nokode.db"
+	}
+	ext := filepath.Ext(path)
+
+	tenantCfg := *cfg
+	tenantCfg.Database.Path = strings.TrimSuffix(path, ext) + "_" + tenant + ext
+	return d.DSN(&tenantCfg)
+}
+
+// ConfigurePool keeps a single connection: SQLite serialises writers anyway
+// and an in-memory database only exists on the connection that created it.
+func (sqliteDialect) ConfigurePool(db *sql.DB) {
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+}
+
+func (sqliteDialect) Tables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			continue
+		}
+		tables = append(tables, tableName)
+	}
+	return tables, rows.Err()
+}
+
+func (sqliteDialect) CreateTable(db *sql.DB, table string) (string, error) {
+	var createStmt string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&createStmt)
+	return createStmt, err
+}
+
+// Explain has no row estimates to work with: EXPLAIN QUERY PLAN only says
+// which tables are scanned in full, so their current row counts are
+// multiplied instead. The plan names a table by its alias, which is mapped
+// back through the query's FROM and JOIN clauses. Subqueries, common table
+// expressions and anything else that is not a table count as the largest
+// table.
+func (d sqliteDialect) Explain(db *sql.DB, query string, params []interface{}) (int64, interface{}, error) {
+	plan, err := queryMaps(db, "EXPLAIN QUERY PLAN "+query, params...)
+	if err != nil {
+		return 0, nil, err
+	}
+	tables, err := d.Tables(db)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	known := make(map[string]string, len(tables))
+	for _, table := range tables {
+		known[strings.ToLower(table)] = table
+	}
+	aliases := sqliteAliases(query, known)
+
+	count := func(table string) int64 {
+		var n int64
+		if err := db.QueryRow(`SELECT COUNT(*) FROM "` + strings.ReplaceAll(table, `"`, `""`) + `"`).Scan(&n); err != nil {
+			return 0
+		}
+		return n
+	}
+	largest := int64(-1)
+
+	estimate := int64(1)
+	for _, step := range plan {
+		detail, _ := step["detail"].(string)
+		m := sqliteScanPattern.FindStringSubmatch(detail)
+		if m == nil || m[1] == "CONSTANT ROW" {
+			continue
+		}
+		name := strings.ToLower(m[1])
+		table, ok := aliases[name]
+		if !ok {
+			table, ok = known[name]
+		}
+		if ok {
+			estimate *= count(table)
+			continue
+		}
+		if largest < 0 {
+			largest = 0
+			for _, table := range tables {
+				largest = max(largest, count(table))
+			}
+		}
+		estimate *= largest
+	}
+	return estimate, plan, nil
+}
+
+// sqliteAliases maps the lowercased aliases of a query's tables to the
+// tables they stand for. known maps lowercased table names to the tables.
+func sqliteAliases(query string, known map[string]string) map[string]string {
+	aliases := make(map[string]string)
+	for rest := query; ; {
+		m := sqliteAliasPattern.FindStringSubmatchIndex(rest)
+		if m == nil {
+			return aliases
+		}
+		next := m[1]
+		if m[4] >= 0 {
+			alias := rest[m[4]:m[5]]
+			if sqliteClauseKeywords[strings.ToUpper(alias)] {
+				// Look for the next table from the keyword on
+				next = m[4]
+			} else if table, ok := known[strings.ToLower(sqliteUnquote(rest[m[2]:m[3]]))]; ok {
+				aliases[strings.ToLower(sqliteUnquote(alias))] = table
+			}
+		}
+		rest = rest[next:]
+	}
+}
+
+// sqliteUnquote strips the quotes SQLite accepts around an identifier.
+func sqliteUnquote(name string) string {
+	if len(name) >= 2 && strings.ContainsRune("\"`[", rune(name[0])) {
+		return name[1 : len(name)-1]
+	}
+	return name
+}
+
+// FTS4 tokenizers do not segment Chinese either, so search falls back to
+// LIKE matching.
+func (sqliteDialect) FullTextIndex(table, index string, columns []string) string { return "" }
+func (sqliteDialect) FullTextScore(columns []string) string                      { return "" }
+
+func (sqliteDialect) Hint() string {
+	return "SQLite: use INTEGER PRIMARY KEY AUTOINCREMENT, TEXT with CHECK constraints instead of ENUM, CURRENT_TIMESTAMP, RANDOM() and ? placeholders. SHOW statements are not available; query sqlite_master instead."
+}
@@ -0,0 +1,76 @@
+package tools
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSqliteExplain(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "explain.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, statement := range []string{
+		"CREATE TABLE poems (id INTEGER PRIMARY KEY, author TEXT)",
+		"CREATE TABLE poem_tags (poem_id INTEGER, tag TEXT)",
+		"INSERT INTO poems (author) VALUES ('李白'), ('杜甫'), ('苏轼')",
+		"INSERT INTO poem_tags VALUES (1, '月'), (2, '秋')",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  int64
+	}{
+		{"table", "SELECT * FROM poems", 3},
+		{"alias", "SELECT * FROM poems p", 3},
+		{"quoted alias", `SELECT * FROM "poems" AS "p p"`, 3},
+		// The joined table is searched through an automatic index
+		{"aliased join", "SELECT * FROM poem_tags t JOIN poems p ON p.author = t.tag", 2},
+		{"join without aliases", "SELECT * FROM poems JOIN poem_tags ON poem_tags.tag = poems.author", 3},
+		{"comma join", "SELECT * FROM poems p, poem_tags t", 6},
+		{"index lookup", "SELECT * FROM poems WHERE id = 1", 1},
+		{"constant", "SELECT 1", 1},
+		{"common table expression", "WITH c AS MATERIALIZED (SELECT * FROM poem_tags) SELECT * FROM c", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, plan, err := sqliteDialect{}.Explain(db, tt.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Explain(%q) = %d, want %d; plan %v", tt.query, got, tt.want, plan)
+			}
+		})
+	}
+}
+
+func TestSqliteAliases(t *testing.T) {
+	known := map[string]string{"poems": "poems", "poem_tags": "poem_tags"}
+	tests := []struct {
+		query string
+		want  map[string]string
+	}{
+		{"SELECT * FROM poems", map[string]string{}},
+		{"SELECT * FROM poems WHERE id = 1", map[string]string{}},
+		{"SELECT * FROM poems AS p", map[string]string{"p": "poems"}},
+		{"SELECT * FROM Poems P LEFT JOIN poem_tags t ON t.poem_id = P.id", map[string]string{"p": "poems", "t": "poem_tags"}},
+		{"SELECT * FROM poems JOIN poem_tags t ON t.poem_id = poems.id", map[string]string{"t": "poem_tags"}},
+		{"SELECT * FROM poems p, poem_tags", map[string]string{"p": "poems"}},
+		{"SELECT * FROM `poems` [p]", map[string]string{"p": "poems"}},
+		{"SELECT * FROM other o", map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := sqliteAliases(tt.query, known); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sqliteAliases(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
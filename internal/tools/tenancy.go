@@ -0,0 +1,295 @@
+package tools
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nokode/nokode/internal/config"
+	"github.com/nokode/nokode/internal/utils"
+)
+
+// tenantDatabase is a tenant's own schema and the cached description of it
+// shown to the model. users counts the callers holding the pool; a pool
+// evicted while in use is closed when the last of them releases it.
+type tenantDatabase struct {
+	pool     *sql.DB
+	schema   string
+	lastUsed time.Time
+	users    int
+	evicted  bool
+}
+
+var (
+	tenancyMode       = "off"
+	tenancyConfig     *config.Config
+	tenants           = make(map[string]*tenantDatabase)
+	tenantsMutex      sync.Mutex
+	tenantOpenLocks   sync.Map // tenant ID -> *sync.Mutex held while opening its pool
+	tenantMaxOpen     = 32
+	tenantIdleTimeout = 30 * time.Minute
+)
+
+// InitTenancy configures how model queries are scoped to tenants. In
+// "schema" mode every tenant gets its own schema (a MySQL database, a
+// PostgreSQL schema or a separate SQLite file), created on first use.
+// Tenant pools idle for longer than Tenancy.IdleTimeout are closed, and at
+// most Tenancy.MaxOpen are kept open.
+func InitTenancy(cfg *config.Config) error {
+	switch cfg.Tenancy.Mode {
+	case "", "off":
+		tenancyMode = "off"
+		return nil
+	case "schema":
+	default:
+		return fmt.Errorf("unsupported tenancy mode: %s", cfg.Tenancy.Mode)
+	}
+
+	if len(cfg.Tenancy.Tenants) == 0 && cfg.Tenancy.Default == "" {
+		return fmt.Errorf("tenancy needs Tenancy.Tenants or Tenancy.Default")
+	}
+	if cfg.Tenancy.Source == "auth" {
+		if cfg.Tenancy.Header != "" && len(cfg.Proxies.Trusted) == 0 {
+			return fmt.Errorf("an identity header needs Proxies.Trusted")
+		}
+		if cfg.Tenancy.Header == "" && len(cfg.Tenancy.Credentials) == 0 && cfg.Tenancy.Default == "" {
+			return fmt.Errorf("the auth tenancy source needs Tenancy.Credentials for Basic auth")
+		}
+	}
+	if cfg.Tenancy.MaxOpen > 0 {
+		tenantMaxOpen = cfg.Tenancy.MaxOpen
+	}
+	if cfg.Tenancy.IdleTimeout != "" {
+		timeout, err := time.ParseDuration(cfg.Tenancy.IdleTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid tenancy idle timeout %q: %w", cfg.Tenancy.IdleTimeout, err)
+		}
+		tenantIdleTimeout = timeout
+	}
+
+	tenancyMode = cfg.Tenancy.Mode
+	tenancyConfig = cfg
+	go func() {
+		ticker := time.NewTicker(min(tenantIdleTimeout, time.Minute))
+		defer ticker.Stop()
+		for range ticker.C {
+			closeIdleTenants()
+		}
+	}()
+	utils.Log.Success("tenancy", fmt.Sprintf("Scoping model queries to per-tenant schemas (%d tenants configured)", len(cfg.Tenancy.Tenants)), nil)
+	return nil
+}
+
+// TenancyEnabled reports whether requests must be mapped to a tenant.
+func TenancyEnabled() bool {
+	return tenancyMode != "off"
+}
+
+// TenantID normalizes a host name or identity into a tenant ID that is
+// safe to use in schema and file names, e.g. "Poems.Example.com" becomes
+// "poems_example_com".
+func TenantID(raw string) string {
+	id := strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(raw), "_"), "_")
+	if len(id) > 48 {
+		id = id[:48]
+	}
+	return id
+}
+
+// tenantPool returns the pool that serves the request's tenant, opening
+// it and creating the tenant schema on first use. Requests without a
+// tenant, and statements nokode issues itself, use the shared database.
+// The caller must call release once it is done with the pool, so that an
+// evicted pool is not closed under it.
+func tenantPool(info *RequestInfo) (pool *sql.DB, release func(), err error) {
+	if tenancyMode == "off" || info == nil || info.Tenant == "" {
+		return db, func() {}, nil
+	}
+	t, err := acquireTenant(info.Tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t.pool, func() { releaseTenant(info.Tenant, t) }, nil
+}
+
+// acquireTenant returns the tenant's database with one more user, opening
+// it first if needed. Opening runs outside tenantsMutex, so a slow tenant
+// only holds up requests for the same tenant.
+func acquireTenant(tenant string) (*tenantDatabase, error) {
+	if t := useTenant(tenant); t != nil {
+		return t, nil
+	}
+
+	lock, _ := tenantOpenLocks.LoadOrStore(tenant, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Another request may have opened it while this one waited
+	if t := useTenant(tenant); t != nil {
+		return t, nil
+	}
+	t, err := openTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantsMutex.Lock()
+	var evicted []*sql.DB
+	for len(tenants) >= tenantMaxOpen {
+		oldest := ""
+		for id, other := range tenants {
+			if oldest == "" || other.lastUsed.Before(tenants[oldest].lastUsed) {
+				oldest = id
+			}
+		}
+		if pool := evictTenant(oldest); pool != nil {
+			evicted = append(evicted, pool)
+		}
+	}
+	t.users = 1
+	tenants[tenant] = t
+	tenantsMutex.Unlock()
+
+	closeTenantPools(evicted)
+	utils.Log.Success("tenancy", fmt.Sprintf("Opened database for tenant %s", tenant), nil)
+	return t, nil
+}
+
+// useTenant returns the tenant's open database with one more user, or nil.
+func useTenant(tenant string) *tenantDatabase {
+	tenantsMutex.Lock()
+	defer tenantsMutex.Unlock()
+
+	t, ok := tenants[tenant]
+	if !ok {
+		return nil
+	}
+	t.users++
+	t.lastUsed = time.Now()
+	return t
+}
+
+// openTenant creates the tenant's schema if needed and opens a pool on it.
+func openTenant(tenant string) (*tenantDatabase, error) {
+	dsn, err := dialect.TenantDSN(db, tenancyConfig, tenant)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := sql.Open(dialect.DriverName(), dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	if err := configurePool(pool, tenancyConfig); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return &tenantDatabase{pool: pool, schema: describeSchema(pool), lastUsed: time.Now()}, nil
+}
+
+// releaseTenant drops a user of t, closing its pool if it was evicted while
+// in use and this was the last user.
+func releaseTenant(tenant string, t *tenantDatabase) {
+	tenantsMutex.Lock()
+	t.users--
+	closing := t.evicted && t.users == 0
+	tenantsMutex.Unlock()
+
+	if closing {
+		closeTenantPools([]*sql.DB{t.pool})
+		utils.Log.Debug("tenancy", fmt.Sprintf("Closed database of tenant %s", tenant), nil)
+	}
+}
+
+// closeIdleTenants closes the pools of tenants that nobody is using and
+// that have not been used for Tenancy.IdleTimeout.
+func closeIdleTenants() {
+	tenantsMutex.Lock()
+	var idle []*sql.DB
+	for id, t := range tenants {
+		if t.users == 0 && time.Since(t.lastUsed) > tenantIdleTimeout {
+			idle = append(idle, evictTenant(id))
+		}
+	}
+	tenantsMutex.Unlock()
+
+	closeTenantPools(idle)
+}
+
+// evictTenant removes a tenant from the open tenants and returns its pool
+// if it can be closed now. A pool still in use is marked evicted instead
+// and closed by its last user. The caller holds tenantsMutex.
+func evictTenant(id string) *sql.DB {
+	t := tenants[id]
+	delete(tenants, id)
+	utils.Log.Debug("tenancy", fmt.Sprintf("Evicted database of tenant %s", id), nil)
+	if t.users > 0 {
+		t.evicted = true
+		return nil
+	}
+	return t.pool
+}
+
+// closeTenantPools closes evicted pools. It is called without tenantsMutex
+// held, since closing waits for the pool's connections.
+func closeTenantPools(pools []*sql.DB) {
+	for _, pool := range pools {
+		if err := pool.Close(); err != nil {
+			utils.Log.Error("tenancy", "Failed to close tenant database", err)
+		}
+	}
+}
+
+// openTenants returns the IDs of the tenants whose pools are open.
+func openTenants() []string {
+	tenantsMutex.Lock()
+	defer tenantsMutex.Unlock()
+
+	ids := make([]string, 0, len(tenants))
+	for id := range tenants {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// GetSchema returns the cached schema description for the request's
+// tenant, or the shared schema when tenancy is off.
+func GetSchema(info *RequestInfo) string {
+	if tenancyMode == "off" || info == nil || info.Tenant == "" {
+		return cachedSchema
+	}
+	t, err := acquireTenant(info.Tenant)
+	if err != nil {
+		utils.Log.Error("tenancy", "Failed to open tenant database", err)
+		return ""
+	}
+	defer releaseTenant(info.Tenant, t)
+
+	tenantsMutex.Lock()
+	defer tenantsMutex.Unlock()
+	return t.schema
+}
+
+// reloadSchema refreshes the cached schema after a schema change.
+func reloadSchema(info *RequestInfo) {
+	if tenancyMode == "off" || info == nil || info.Tenant == "" {
+		loadDatabaseSchema()
+		return
+	}
+
+	t := useTenant(info.Tenant)
+	if t == nil {
+		return
+	}
+	defer releaseTenant(info.Tenant, t)
+	schema := describeSchema(t.pool)
+
+	tenantsMutex.Lock()
+	defer tenantsMutex.Unlock()
+	t.schema = schema
+}
@@ -0,0 +1,115 @@
+package tools
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nokode/nokode/internal/config"
+)
+
+// useSQLiteTenancy switches the package to schema tenancy over SQLite files
+// in a temporary directory for the duration of the test.
+func useSQLiteTenancy(t *testing.T, maxOpen int) {
+	cfg := &config.Config{}
+	cfg.Database.Path = filepath.Join(t.TempDir(), "nokode.db")
+
+	savedDialect, savedMode, savedConfig, savedMax := dialect, tenancyMode, tenancyConfig, tenantMaxOpen
+	dialect, tenancyMode, tenancyConfig, tenantMaxOpen = sqliteDialect{}, "schema", cfg, maxOpen
+	tenants = make(map[string]*tenantDatabase)
+	t.Cleanup(func() {
+		for id := range tenants {
+			if pool := evictTenant(id); pool != nil {
+				pool.Close()
+			}
+		}
+		dialect, tenancyMode, tenancyConfig, tenantMaxOpen = savedDialect, savedMode, savedConfig, savedMax
+	})
+}
+
+func TestTenantPoolEvictionWaitsForUsers(t *testing.T) {
+	useSQLiteTenancy(t, 1)
+
+	poolA, releaseA, err := tenantPool(&RequestInfo{Tenant: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	poolB, releaseB, err := tenantPool(&RequestInfo{Tenant: "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := openTenants(); len(ids) != 1 || ids[0] != "b" {
+		t.Fatalf("open tenants = %v, want [b]", ids)
+	}
+	if err := poolA.Ping(); err != nil {
+		t.Fatalf("evicted pool closed while in use: %v", err)
+	}
+
+	releaseA()
+	if err := poolA.Ping(); err == nil {
+		t.Error("evicted pool still open after its last user released it")
+	}
+
+	releaseB()
+	if err := poolB.Ping(); err != nil {
+		t.Errorf("pool closed on release although it was not evicted: %v", err)
+	}
+}
+
+func TestCloseIdleTenantsSkipsPoolsInUse(t *testing.T) {
+	useSQLiteTenancy(t, 8)
+	savedTimeout := tenantIdleTimeout
+	tenantIdleTimeout = 0
+	defer func() { tenantIdleTimeout = savedTimeout }()
+
+	busy, releaseBusy, err := tenantPool(&RequestInfo{Tenant: "busy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, releaseIdle, err := tenantPool(&RequestInfo{Tenant: "idle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseIdle()
+
+	closeIdleTenants()
+	if ids := openTenants(); len(ids) != 1 || ids[0] != "busy" {
+		t.Fatalf("open tenants = %v, want [busy]", ids)
+	}
+	if err := busy.Ping(); err != nil {
+		t.Errorf("pool in use was closed: %v", err)
+	}
+	releaseBusy()
+}
+
+func TestTenantPoolOpensOnce(t *testing.T) {
+	useSQLiteTenancy(t, 8)
+
+	const n = 8
+	pools := make([]*sql.DB, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pool, release, err := tenantPool(&RequestInfo{Tenant: "shared"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+			pools[i] = pool
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 1; i < n; i++ {
+		if pools[i] != pools[0] {
+			t.Fatal("concurrent requests opened separate pools for one tenant")
+		}
+	}
+	if users := tenants["shared"].users; users != 0 {
+		t.Errorf("users = %d after every request released the pool, want 0", users)
+	}
+}
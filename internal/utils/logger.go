@@ -125,7 +125,7 @@ func (l *Logger) Request(method, path string, data interface{}) {
 func (l *Logger) Tool(toolName, message string, data interface{}) {
 	timestamp := formatTimestamp()
 	areaTag := formatArea("tool")
-	fmt.Printf("%s%s%s %s%s%s %s%s🔧 %s%s %s%s%s\n", 
+	fmt.Printf("%s%s%s %s%s%s %s🔧 %s%s %s%s%s\n", 
 		colorGray, timestamp, colorReset, 
 		colorMagenta, areaTag, colorReset, 
 		colorBright, toolName, colorReset, 
@@ -2,7 +2,6 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"os"
 
@@ -10,7 +9,6 @@ import (
 	"github.com/nokode/nokode/internal/config"
 	"github.com/nokode/nokode/internal/handler"
 	"github.com/nokode/nokode/internal/tools"
-	"github.com/zeromicro/go-zero/core/conf"
 	"github.com/zeromicro/go-zero/rest"
 )
 
@@ -25,56 +23,100 @@ func main() {
 	}
 
 	// Load config
-	var c config.Config
-	conf.MustLoad(*configFile, &c)
-
-	// Override with environment variables
-	portStr := getEnv("PORT", "3001")
-	if c.RestConf.Port == 0 {
-		// Try to parse port from string
-		var port int
-		if _, err := fmt.Sscanf(portStr, "%d", &port); err == nil {
-			c.RestConf.Port = port
-		} else {
-			c.RestConf.Port = 3001
-		}
+	c, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
 	}
-	if c.Provider == "" {
-		c.Provider = getEnv("LLM_PROVIDER", "qwen")
+
+	// Initialize database
+	if err := tools.InitDatabase(c); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	if c.RestConf.Host == "" {
-		c.RestConf.Host = "0.0.0.0"
+	if err := tools.InitAudit(c); err != nil {
+		log.Fatalf("Failed to initialize audit log: %v", err)
 	}
-	if c.RestConf.Timeout == 0 {
-		c.RestConf.Timeout = 300000 // 5 minutes in milliseconds
+	if err := tools.InitTenancy(c); err != nil {
+		log.Fatalf("Failed to initialize tenancy: %v", err)
 	}
-
-	c.Qwen.Model = getEnv("QWEN_MODEL", "qwen-turbo")
-	if c.Qwen.APIKey == "" {
-		c.Qwen.APIKey = getEnv("QWEN_API_KEY", getEnv("DASHSCOPE_API_KEY", ""))
+	if err := tools.InitMigrations(c); err != nil {
+		log.Fatalf("Failed to initialize migrations: %v", err)
 	}
 
-	c.Anthropic.Model = getEnv("ANTHROPIC_MODEL", "claude-3-haiku-20240307")
-	if c.Anthropic.APIKey == "" {
-		c.Anthropic.APIKey = getEnv("ANTHROPIC_API_KEY", "")
+	if err := tools.InitSnapshots(c); err != nil {
+		log.Fatalf("Failed to initialize snapshots: %v", err)
 	}
 
-	c.OpenAI.Model = getEnv("OPENAI_MODEL", "gpt-4-turbo-preview")
-	if c.OpenAI.APIKey == "" {
-		c.OpenAI.APIKey = getEnv("OPENAI_API_KEY", "")
+	// Run a CLI subcommand instead of the server if one was given
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(c, flag.Args()))
 	}
 
-	// Initialize database
-	if err := tools.InitDatabase(&c); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	if err := tools.InitJobs(c); err != nil {
+		log.Fatalf("Failed to initialize jobs: %v", err)
+	}
+	if err := tools.InitPoetStyles(c); err != nil {
+		log.Fatalf("Failed to initialize poet styles: %v", err)
 	}
 
 	// Create server
 	server := rest.MustNewServer(c.RestConf)
 	defer server.Stop()
 
+	// Admin and API routes are registered before the catch-all routes
+	server.AddRoute(rest.Route{
+		Method:  "GET",
+		Path:    "/admin/audit",
+		Handler: handler.HandleAuditLog(c),
+	})
+	server.AddRoute(rest.Route{
+		Method:  "GET",
+		Path:    "/admin/db/stats",
+		Handler: handler.HandleDatabaseStats(c),
+	})
+	server.AddRoutes([]rest.Route{
+		{Method: "GET", Path: "/admin/poet-styles", Handler: handler.HandleListPoetStyles(c)},
+		{Method: "POST", Path: "/admin/poet-styles", Handler: handler.HandleCreatePoetStyle(c)},
+		{Method: "PUT", Path: "/admin/poet-styles/:id", Handler: handler.HandleUpdatePoetStyle(c)},
+		{Method: "DELETE", Path: "/admin/poet-styles/:id", Handler: handler.HandleDeletePoetStyle(c)},
+	})
+
+	server.AddRoutes([]rest.Route{
+		{Method: "GET", Path: "/feed.xml", Handler: handler.HandleAtomFeed(c)},
+		{Method: "GET", Path: "/rss.xml", Handler: handler.HandleRSSFeed(c)},
+		{Method: "GET", Path: "/poems/:id/card.svg", Handler: handler.HandlePoemCardSVG(c)},
+		{Method: "GET", Path: "/poems/:id/card.png", Handler: handler.HandlePoemCardPNG(c)},
+	})
+
+	server.AddRoutes([]rest.Route{
+		{Method: "POST", Path: "/jobs", Handler: handler.HandleCreateBatchJob(c)},
+		{Method: "GET", Path: "/jobs/:id", Handler: handler.HandleGetJob(c)},
+		{Method: "DELETE", Path: "/jobs/:id", Handler: handler.HandleCancelJob(c)},
+		{Method: "GET", Path: "/jobs/:id/result", Handler: handler.HandleJobResult(c)},
+	})
+
+	server.AddRoutes([]rest.Route{
+		{Method: "GET", Path: "/api/poems", Handler: handler.HandleListPoems(c)},
+		{Method: "POST", Path: "/api/poems", Handler: handler.HandleCreatePoem(c)},
+		{Method: "GET", Path: "/api/poems/search", Handler: handler.HandleSearchPoems(c)},
+		{Method: "GET", Path: "/api/poems/export", Handler: handler.HandleExportPoems(c)},
+		{Method: "GET", Path: "/api/poems/:id", Handler: handler.HandleGetPoem(c)},
+		{Method: "DELETE", Path: "/api/poems/:id", Handler: handler.HandleDeletePoem(c)},
+		{Method: "PUT", Path: "/api/poems/:id/rating", Handler: handler.HandleRatePoem(c)},
+		{Method: "PUT", Path: "/api/poems/:id/favorite", Handler: handler.HandleFavoritePoem(c)},
+		{Method: "DELETE", Path: "/api/poems/:id/favorite", Handler: handler.HandleFavoritePoem(c)},
+		{Method: "PUT", Path: "/api/poems/:id/tags", Handler: handler.HandleTagPoem(c)},
+		{Method: "GET", Path: "/api/tags", Handler: handler.HandleListTags(c)},
+		{Method: "GET", Path: "/api/forms", Handler: handler.HandleListForms(c)},
+		{Method: "GET", Path: "/api/collections", Handler: handler.HandleListCollections(c)},
+		{Method: "POST", Path: "/api/collections", Handler: handler.HandleCreateCollection(c)},
+		{Method: "GET", Path: "/api/collections/:id", Handler: handler.HandleGetCollection(c)},
+		{Method: "DELETE", Path: "/api/collections/:id", Handler: handler.HandleDeleteCollection(c)},
+		{Method: "PUT", Path: "/api/collections/:id/poems/:poem", Handler: handler.HandleCollectionPoem(c)},
+		{Method: "DELETE", Path: "/api/collections/:id/poems/:poem", Handler: handler.HandleCollectionPoem(c)},
+	})
+
 	// Register catch-all route for all methods and paths
-	llmHandler := handler.HandleLLMRequest(&c)
+	llmHandler := handler.HandleLLMRequest(c)
 	server.AddRoute(rest.Route{
 		Method:  "GET",
 		Path:    "/",
@@ -153,10 +195,3 @@ func main() {
 
 	server.Start()
 }
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}